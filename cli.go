@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// isCLI reports whether the binary was started as a client, with -cli or --cli as
+// its first argument.
+func isCLI(args []string) bool {
+	return len(args) > 0 && (args[0] == "-cli" || args[0] == "--cli")
+}
+
+// runCLI is a minimal redis-cli: it sends the command given in args and prints the
+// reply, or without a command it reads commands from stdin in a REPL. It returns the
+// exit status, 1 if the server replied with an error to a one-shot command.
+func runCLI(args []string) int {
+	fs := flag.NewFlagSet("cli", flag.ExitOnError)
+	host := fs.String("h", "127.0.0.1", "Server hostname")
+	port := fs.Int("p", 6379, "Server port")
+	socket := fs.String("s", "", "Server socket, overrides hostname and port")
+	db := fs.Int("n", 0, "Database number")
+	uri := fs.String("u", "", "Server URI, as redis://[[user]:password@]host[:port][/db], rediss:// or unix://, overrides the other options")
+	fs.Parse(args)
+
+	network, addr := "tcp", net.JoinHostPort(*host, strconv.Itoa(*port))
+	if *socket != "" {
+		network, addr = "unix", *socket
+	}
+	var u redisURL
+	if *uri != "" {
+		var err error
+		if u, err = parseRedisURL(*uri); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		network, addr, *db = u.network, u.addr, u.db
+	}
+	var conn net.Conn
+	var err error
+	if u.tls {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.Dial(network, addr, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = net.Dial(network, addr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to %s: %v\n", addr, err)
+		return 1
+	}
+	client := newRESPClient(conn)
+	defer client.Close()
+
+	color := isTerminal(os.Stdout)
+	if u.password != "" {
+		auth := []string{"AUTH", u.password}
+		if u.user != "" {
+			auth = []string{"AUTH", u.user, u.password}
+		}
+		reply, err := client.do(auth...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if _, ok := reply.(resp.Error); ok {
+			fmt.Println(formatReply(reply, color))
+			return 1
+		}
+	}
+	if *db != 0 {
+		reply, err := client.do("SELECT", strconv.Itoa(*db))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if _, ok := reply.(resp.Error); ok {
+			fmt.Println(formatReply(reply, color))
+			return 1
+		}
+	}
+
+	if fs.NArg() > 0 {
+		reply, err := client.do(fs.Args()...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(formatReply(reply, color))
+		if _, ok := reply.(resp.Error); ok {
+			return 1
+		}
+		if isSubscribe(fs.Arg(0)) {
+			return streamMessages(client, color)
+		}
+		return 0
+	}
+
+	return repl(client, addr, *db, color)
+}
+
+// repl reads commands from stdin, one per line, until EOF or QUIT.
+func repl(client *respClient, addr string, db int, color bool) int {
+	interactive := isTerminal(os.Stdin)
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			prompt := addr
+			if db != 0 {
+				prompt += "[" + strconv.Itoa(db) + "]"
+			}
+			fmt.Print(prompt + "> ")
+		}
+		if !scanner.Scan() {
+			return 0
+		}
+		args, err := splitArgs(scanner.Text())
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToLower(args[0])
+		if name == "exit" {
+			return 0
+		}
+
+		reply, err := client.do(args...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(formatReply(reply, color))
+		_, failed := reply.(resp.Error)
+		switch {
+		case name == "quit":
+			return 0
+		case name == "select" && !failed && len(args) == 2:
+			db, _ = strconv.Atoi(args[1])
+		case isSubscribe(name) && !failed:
+			return streamMessages(client, color)
+		}
+	}
+}
+
+func isSubscribe(name string) bool {
+	switch strings.ToLower(name) {
+	case "subscribe", "psubscribe", "ssubscribe":
+		return true
+	}
+	return false
+}
+
+// streamMessages prints the messages received by a subscribed client, until the
+// connection is closed or the process is interrupted with Ctrl-C.
+func streamMessages(client *respClient, color bool) int {
+	if isTerminal(os.Stdout) {
+		fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	}
+	for {
+		reply, err := client.read()
+		if errors.Is(err, io.EOF) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(formatReply(reply, color))
+	}
+}
+
+// formatReply formats a reply as redis-cli does: strings are quoted, integers and
+// the other types are labelled with their type, and the elements of aggregates are
+// numbered. Errors are printed in red if color is true.
+func formatReply(r resp.Reply, color bool) string {
+	switch r := r.(type) {
+	case resp.SimpleString:
+		return string(r)
+	case resp.Error:
+		msg := "(error) " + string(r)
+		if color {
+			msg = "\x1b[31m" + msg + "\x1b[0m"
+		}
+		return msg
+	case resp.Int:
+		return "(integer) " + strconv.FormatInt(int64(r), 10)
+	case resp.BulkString:
+		return quoteBytes(r)
+	case resp.Null, resp.NullArray, nil:
+		return "(nil)"
+	case resp.Double:
+		return "(double) " + strconv.FormatFloat(float64(r), 'g', -1, 64)
+	case resp.Boolean:
+		if r {
+			return "(true)"
+		}
+		return "(false)"
+	case resp.BigNumber:
+		return "(big number) " + string(r)
+	case resp.Verbatim:
+		return r.Text
+	case resp.Array:
+		return formatList(r, ") ", color)
+	case resp.Set:
+		return formatList(r, ") ", color)
+	case resp.Push:
+		return formatList(r, ") ", color)
+	case resp.Map:
+		return formatMap(r, color)
+	}
+	return fmt.Sprint(r)
+}
+
+// formatList numbers the elements, aligning the nested lines of an element with the
+// first one.
+func formatList(elems []resp.Reply, sep string, color bool) string {
+	if len(elems) == 0 {
+		return "(empty array)"
+	}
+	width := len(strconv.Itoa(len(elems)))
+	var b strings.Builder
+	for i, elem := range elems {
+		prefix := fmt.Sprintf("%*d%s", width, i+1, sep)
+		writeIndented(&b, prefix, formatReply(elem, color))
+		if i < len(elems)-1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+func formatMap(elems []resp.Reply, color bool) string {
+	if len(elems) == 0 {
+		return "(empty hash)"
+	}
+	n := len(elems) / 2
+	width := len(strconv.Itoa(n))
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		prefix := fmt.Sprintf("%*d# ", width, i+1)
+		key := formatReply(elems[2*i], color)
+		writeIndented(&b, prefix, key+" => "+formatReply(elems[2*i+1], color))
+		if i < n-1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// writeIndented writes prefix followed by s, indenting the lines of s after the
+// first one by the length of prefix.
+func writeIndented(b *strings.Builder, prefix, s string) {
+	b.WriteString(prefix)
+	indent := strings.Repeat(" ", len(prefix))
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteString("\n" + indent)
+		}
+		b.WriteString(line)
+	}
+}
+
+// quoteBytes quotes a string, escaping the non-printable bytes as \xHH.
+func quoteBytes(s []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// splitArgs splits a line into arguments separated by spaces. As in redis-cli,
+// arguments can be quoted: double quotes support the escapes \n, \r, \t, \", \\ and
+// \xHH, single quotes only \'. A closing quote must be followed by a space.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && unicode.IsSpace(rune(line[i])) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		quote := byte(0)
+		for ; i < len(line); i++ {
+			c := line[i]
+			if quote == 0 {
+				if unicode.IsSpace(rune(c)) {
+					break
+				}
+				if c == '"' || c == '\'' {
+					quote = c
+					continue
+				}
+				arg.WriteByte(c)
+				continue
+			}
+
+			switch {
+			case c == quote:
+				if i+1 < len(line) && !unicode.IsSpace(rune(line[i+1])) {
+					return nil, errors.New("closing quote must be followed by a space")
+				}
+				quote = 0
+			case c == '\\' && i+1 < len(line) && quote == '\'':
+				if line[i+1] == '\'' {
+					i++
+				}
+				arg.WriteByte(line[i])
+			case c == '\\' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				case 'x':
+					if i+2 < len(line) {
+						if n, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+							arg.WriteByte(byte(n))
+							i += 2
+							continue
+						}
+					}
+					arg.WriteByte('x')
+				default:
+					arg.WriteByte(line[i])
+				}
+			default:
+				arg.WriteByte(c)
+			}
+		}
+		if quote != 0 {
+			return nil, errors.New("unbalanced quotes")
+		}
+		args = append(args, arg.String())
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
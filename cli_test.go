@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: []string{}},
+		{line: "   ", want: []string{}},
+		{line: "get foo", want: []string{"get", "foo"}},
+		{line: "  set\tfoo   bar  ", want: []string{"set", "foo", "bar"}},
+		{line: `set "hello world" x`, want: []string{"set", "hello world", "x"}},
+		{line: `set 'hello world' x`, want: []string{"set", "hello world", "x"}},
+		{line: `set k ""`, want: []string{"set", "k", ""}},
+		{line: `set k "a\nb\r\tc"`, want: []string{"set", "k", "a\nb\r\tc"}},
+		{line: `set k "\"quoted\" \\"`, want: []string{"set", "k", `"quoted" \`}},
+		{line: `set k "\x41\x00\xff"`, want: []string{"set", "k", "A\x00\xff"}},
+		{line: `set k "\xZZ"`, want: []string{"set", "k", "xZZ"}},
+		{line: `set k "\x4"`, want: []string{"set", "k", "x4"}},
+		{line: `set k 'it\'s'`, want: []string{"set", "k", "it's"}},
+		// Single quotes only support \'
+		{line: `set k 'a\nb'`, want: []string{"set", "k", `a\nb`}},
+		{line: `set k unquoted\n`, want: []string{"set", "k", `unquoted\n`}},
+		{line: `set k "unbalanced`, wantErr: true},
+		{line: `set k 'unbalanced`, wantErr: true},
+		{line: `set k "ends with a backslash\"`, wantErr: true},
+		{line: `set k "closed"too`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitArgs(%q) = %q, want an error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply resp.Reply
+		color bool
+		want  string
+	}{
+		{"simple string", resp.SimpleString("OK"), false, "OK"},
+		{"error", resp.Error("ERR wrong"), false, "(error) ERR wrong"},
+		{"error in color", resp.Error("ERR wrong"), true, "\x1b[31m(error) ERR wrong\x1b[0m"},
+		{"int", resp.Int(-3), false, "(integer) -3"},
+		{"bulk string", resp.BulkString("a \"b\"\n\x00\xff"), false, `"a \"b\"\n\x00\xff"`},
+		{"null", resp.Null{}, false, "(nil)"},
+		{"null array", resp.NullArray{}, false, "(nil)"},
+		{"double", resp.Double(1.5), false, "(double) 1.5"},
+		{"boolean", resp.Boolean(false), false, "(false)"},
+		{"big number", resp.BigNumber("123456789012345678901"), false, "(big number) 123456789012345678901"},
+		{"verbatim", resp.Verbatim{Format: "txt", Text: "some text"}, false, "some text"},
+		{"empty array", resp.Array{}, false, "(empty array)"},
+		{"empty map", resp.Map{}, false, "(empty hash)"},
+		{
+			"array",
+			resp.Array{resp.BulkString("a"), resp.Null{}, resp.Int(3)},
+			false,
+			"1) \"a\"\n2) (nil)\n3) (integer) 3",
+		},
+		{
+			"numbers aligned",
+			resp.Array{resp.Int(1), resp.Int(2), resp.Int(3), resp.Int(4), resp.Int(5), resp.Int(6), resp.Int(7), resp.Int(8), resp.Int(9), resp.Array{resp.Int(10), resp.Int(11)}},
+			false,
+			" 1) (integer) 1\n 2) (integer) 2\n 3) (integer) 3\n 4) (integer) 4\n 5) (integer) 5\n" +
+				" 6) (integer) 6\n 7) (integer) 7\n 8) (integer) 8\n 9) (integer) 9\n10) 1) (integer) 10\n    2) (integer) 11",
+		},
+		{
+			"nested array",
+			resp.Array{resp.Array{resp.BulkString("x"), resp.Array{resp.Null{}}}, resp.SimpleString("OK")},
+			false,
+			"1) 1) \"x\"\n   2) 1) (nil)\n2) OK",
+		},
+		{
+			"map",
+			resp.Map{resp.BulkString("key"), resp.Int(1), resp.BulkString("list"), resp.Array{resp.Null{}, resp.Error("ERR e")}},
+			false,
+			"1# \"key\" => (integer) 1\n2# \"list\" => 1) (nil)\n   2) (error) ERR e",
+		},
+		{
+			"nested errors in color",
+			resp.Array{resp.Error("ERR e"), resp.Map{resp.SimpleString("k"), resp.Error("ERR f")}},
+			true,
+			"1) \x1b[31m(error) ERR e\x1b[0m\n2) 1# k => \x1b[31m(error) ERR f\x1b[0m",
+		},
+		{"set", resp.Set{resp.BulkString("a")}, false, "1) \"a\""},
+		{"push", resp.Push{resp.BulkString("message"), resp.BulkString("hi")}, false, "1) \"message\"\n2) \"hi\""},
+	}
+	for _, tt := range tests {
+		if got := formatReply(tt.reply, tt.color); got != tt.want {
+			t.Errorf("%s: formatReply =\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
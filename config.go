@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tommasoamici/redis-clone/server"
+)
+
+// config holds the values of the command line flags. The same settings can be given
+// in a configuration file, one per line as `name value`, using the flag names.
+type config struct {
+	configFile      string
+	network         string
+	addrs           addressList
+	tlsAddrs        []string
+	defaultDB       int
+	unixSocket      string
+	unixSocketPerm  string
+	dbNum           int
+	storageEngine   string
+	dir             string
+	timeout         int
+	maxClients      int
+	maxClientsPerIP int
+	acceptRatePerIP int
+	ipExemptLocal   bool
+	tcpKeepAlive    int
+	tcpNoDelay      bool
+	tlsPort         int
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsCACertFile   string
+	tlsAuthClients  string
+	logLevel        string
+	shutdownTimeout time.Duration
+	metricsAddr     string
+	debugAddr       string
+	debugRemote     bool
+	httpAddr        string
+	traceProto      bool
+	traceProtoLen   int
+	auditLog        string
+	auditMaxArgLen  int
+	auditMaxSize    int64
+	healthcheck     bool
+	loadFrom        string
+	lazyUserFlush   bool
+	notifyEvents    string
+	compression     string
+	compressionMin  int64
+	renameCommands  renameList
+	obufLimits      limitList
+	protectedMode   bool
+	protectedConfig bool
+	logFile         string
+	logFileMaxSize  int64
+	logFileKeep     int
+}
+
+func defineFlags(fs *flag.FlagSet) *config {
+	c := &config{}
+	fs.StringVar(&c.configFile, "config", "", "Path of the configuration file, it's reloaded on SIGHUP")
+	fs.StringVar(&c.network, "network", "tcp", `The network must be "tcp", "tcp4", "tcp6", "unix" or "unixpacket".`)
+	fs.Var(&c.addrs, "address", "Address to listen on, can be repeated or a comma-separated list, also as a redis://, rediss:// or unix:// URL (default 127.0.0.1:6379)")
+	fs.StringVar(&c.unixSocket, "unixsocket", "", "Path of a unix socket to listen on, in addition to -address")
+	fs.StringVar(&c.unixSocketPerm, "unixsocketperm", "", "Permissions of the unix sockets in octal, e.g. 700")
+	fs.IntVar(&c.dbNum, "db-num", 16, "Number of databases to create")
+	fs.StringVar(&c.storageEngine, "storage-engine", "memory", `Where the keys are stored: "memory", or "disk" to hold datasets larger than the memory in a file in -dir`)
+	fs.StringVar(&c.dir, "dir", ".", "Directory of the file of the disk storage engine")
+	fs.IntVar(&c.timeout, "timeout", 0, "Close the connection after a client is idle for N seconds (0 to disable)")
+	fs.IntVar(&c.maxClients, "maxclients", server.DefaultMaxClients, "Maximum number of clients connected at the same time")
+	fs.IntVar(&c.maxClientsPerIP, "maxclients-per-ip", 0, "Maximum number of clients connected at the same time from a single IP address (0 to disable)")
+	fs.IntVar(&c.acceptRatePerIP, "accept-rate-per-ip", 0, "Number of connections accepted per second from a single IP address, the others are closed (0 to disable)")
+	fs.BoolVar(&c.ipExemptLocal, "ip-limits-exempt-local", true, "Exempt the loopback interface and unix sockets from -maxclients-per-ip and -accept-rate-per-ip")
+	fs.IntVar(&c.tcpKeepAlive, "tcp-keepalive", int(server.DefaultTCPKeepAlive.Seconds()), "Period in seconds of the TCP keepalive probes sent to clients (0 to disable)")
+	fs.BoolVar(&c.tcpNoDelay, "tcp-nodelay", true, "Disable Nagle's algorithm on client connections")
+	fs.BoolVar(&c.protectedMode, "protected-mode", true, "Only accept connections from the loopback interface and unix sockets")
+	fs.BoolVar(&c.protectedConfig, "enable-protected-configs", false, "Allow CONFIG SET to change the protected parameters, such as logfile")
+	fs.IntVar(&c.tlsPort, "tls-port", 0, "Port to listen on for TLS connections, on the same hosts as -address or on 127.0.0.1 (0 to disable)")
+	fs.StringVar(&c.tlsCertFile, "tls-cert-file", "", "Path of the X.509 certificate used by the server")
+	fs.StringVar(&c.tlsKeyFile, "tls-key-file", "", "Path of the private key of the server certificate")
+	fs.StringVar(&c.tlsCACertFile, "tls-ca-cert-file", "", "Path of the CA certificate used to verify clients")
+	fs.StringVar(&c.tlsAuthClients, "tls-auth-clients", "no", `Require clients to authenticate with a certificate: "yes", "no" or "optional"`)
+	fs.StringVar(&c.logLevel, "loglevel", "notice", `Log verbosity: "debug", "verbose", "notice" or "warning"`)
+	fs.StringVar(&c.logFile, "logfile", "", "Path of the log file, reopened on SIGUSR1 (stderr if empty)")
+	fs.Int64Var(&c.logFileMaxSize, "logfile-max-size", 0, "Size in bytes after which the log file is rotated (0 to disable)")
+	fs.IntVar(&c.logFileKeep, "logfile-keep", 5, "Number of rotated log files to keep")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", 10*time.Second, "Time given to in-flight commands to complete on shutdown")
+	fs.StringVar(&c.metricsAddr, "metrics-addr", "", "Address of the HTTP server exposing Prometheus metrics on /metrics (disabled if empty)")
+	fs.StringVar(&c.auditLog, "audit-log", "", "Path of the file where every write command is recorded (disabled if empty)")
+	fs.IntVar(&c.auditMaxArgLen, "audit-log-max-arg-len", server.DefaultAuditLogMaxArgLen, "Length at which arguments are truncated in the audit log")
+	fs.Int64Var(&c.auditMaxSize, "audit-log-max-size", 0, "Size in bytes after which the audit log is rotated (0 to disable)")
+	fs.StringVar(&c.debugAddr, "debug-addr", "", "Address of the HTTP server exposing expvar and pprof on /debug/ (disabled if empty)")
+	fs.BoolVar(&c.debugRemote, "debug-allow-remote", false, "Allow -debug-addr to be a non-loopback address")
+	fs.BoolVar(&c.traceProto, "trace-proto", false, "Log the raw bytes of every command and reply at debug level")
+	fs.IntVar(&c.traceProtoLen, "trace-proto-max-len", server.DefaultTraceProtoMaxLen, "Number of bytes of each command and reply logged by -trace-proto")
+	fs.StringVar(&c.httpAddr, "http-addr", "", "Address of the HTTP gateway serving the keys on /v1/keys/ and commands on /v1/command (disabled if empty)")
+	fs.BoolVar(&c.lazyUserFlush, "lazyfree-lazy-user-flush", false, "Make FLUSHDB and FLUSHALL asynchronous by default")
+	fs.StringVar(&c.notifyEvents, "notify-keyspace-events", "", `Classes of keyspace events published to the SSUBSCRIBE subscribers, e.g. "Eg" (disabled if empty)`)
+	fs.StringVar(&c.compression, "value-compression", "no", `Compress the large values: "no" or "snappy"`)
+	fs.Int64Var(&c.compressionMin, "value-compression-min-size", server.DefaultValueCompressionMinSize, "Size in bytes from which values are compressed")
+	fs.Var(&c.obufLimits, "client-output-buffer-limit", `Limit the messages queued for a slow client, as "class hard soft seconds", e.g. "pubsub 32mb 8mb 60", can be repeated`)
+	fs.Var(&c.renameCommands, "rename-command", `Rename a command, as "name new-name", or disable it if the new name is missing or ""`)
+	fs.StringVar(&c.loadFrom, "load-from", "", `Execute the RESP encoded commands in the file, or stdin if "-", before accepting connections`)
+	fs.BoolVar(&c.healthcheck, "healthcheck", false, "Send PING to the configured server and exit with status 0 if it replies, instead of starting a server")
+	return c
+}
+
+// setDefaults fills in the defaults that depend on other settings. The addresses
+// given as URLs are replaced by what they describe: redis:// URLs by their address,
+// rediss:// ones by a TLS address, unix:// ones by the unix socket, while their path
+// selects the database of the new connections.
+func (c *config) setDefaults() error {
+	addrs := addressList{}
+	for _, addr := range c.addrs {
+		if !isURL(addr) {
+			addrs = append(addrs, addr)
+			continue
+		}
+		u, err := parseRedisURL(addr)
+		if err != nil {
+			return err
+		}
+		if u.user != "" || u.password != "" {
+			return errors.New("invalid URL: a password can't be set on the server yet, remove the user info")
+		}
+		switch {
+		case u.network == "unix":
+			if c.unixSocket != "" && c.unixSocket != u.addr {
+				return fmt.Errorf("invalid URL: only one unix socket can be listened on, %s is already given", c.unixSocket)
+			}
+			c.unixSocket = u.addr
+		case !strings.HasPrefix(c.network, "tcp"):
+			return fmt.Errorf("invalid URL: TCP addresses can't be listened on with network %s", c.network)
+		case u.tls:
+			c.tlsAddrs = append(c.tlsAddrs, u.addr)
+		default:
+			addrs = append(addrs, u.addr)
+		}
+		// Database 0 is the default, so URLs without a database don't conflict
+		if u.db != 0 {
+			if c.defaultDB != 0 && u.db != c.defaultDB {
+				return fmt.Errorf("invalid URL database %d, another URL selects %d", u.db, c.defaultDB)
+			}
+			c.defaultDB = u.db
+		}
+	}
+	c.addrs = addrs
+	if len(c.addrs) == 0 && c.unixSocket == "" && len(c.tlsAddrs) == 0 {
+		c.addrs = addressList{"127.0.0.1:6379"}
+	}
+	return nil
+}
+
+func (c *config) options() (server.Options, error) {
+	keepAlive := time.Duration(c.tcpKeepAlive) * time.Second
+	if keepAlive == 0 {
+		keepAlive = -1
+	}
+	if c.storageEngine != "memory" && c.storageEngine != "disk" {
+		return server.Options{}, fmt.Errorf("invalid storage-engine %s", c.storageEngine)
+	}
+	if c.compression != "no" && c.compression != "snappy" {
+		return server.Options{}, fmt.Errorf("invalid value-compression %s", c.compression)
+	}
+	if c.defaultDB >= c.dbNum {
+		return server.Options{}, fmt.Errorf("invalid URL database %d, it must be less than db-num", c.defaultDB)
+	}
+	var socketPerm uint64
+	if c.unixSocketPerm != "" {
+		var err error
+		socketPerm, err = strconv.ParseUint(c.unixSocketPerm, 8, 32)
+		if err != nil {
+			return server.Options{}, fmt.Errorf("invalid unixsocketperm %s", c.unixSocketPerm)
+		}
+	}
+
+	return server.Options{
+		Network:        c.network,
+		Addrs:          c.addrs,
+		UnixSocket:     c.unixSocket,
+		UnixSocketPerm: os.FileMode(socketPerm),
+
+		Databases:  c.dbNum,
+		DefaultDB:  c.defaultDB,
+		Timeout:    time.Duration(c.timeout) * time.Second,
+		MaxClients: c.maxClients,
+
+		MaxClientsPerIP:      c.maxClientsPerIP,
+		AcceptRatePerIP:      c.acceptRatePerIP,
+		IPLimitsIncludeLocal: !c.ipExemptLocal,
+
+		TCPKeepAlive:         keepAlive,
+		DisableTCPNoDelay:    !c.tcpNoDelay,
+		DisableProtectedMode: !c.protectedMode,
+
+		EnableProtectedConfigs: c.protectedConfig,
+
+		TLSPort:        c.tlsPort,
+		TLSAddrs:       c.tlsAddrs,
+		TLSCertFile:    c.tlsCertFile,
+		TLSKeyFile:     c.tlsKeyFile,
+		TLSCACertFile:  c.tlsCACertFile,
+		TLSAuthClients: c.tlsAuthClients,
+
+		MetricsAddr:      c.metricsAddr,
+		DebugAddr:        c.debugAddr,
+		DebugAllowRemote: c.debugRemote,
+		HTTPAddr:         c.httpAddr,
+
+		AuditLogFile:      c.auditLog,
+		AuditLogMaxArgLen: c.auditMaxArgLen,
+		AuditLogMaxSize:   c.auditMaxSize,
+
+		LazyFreeUserFlush: c.lazyUserFlush,
+
+		ValueCompression:        c.compression,
+		ValueCompressionMinSize: c.compressionMin,
+
+		TraceProto:       c.traceProto,
+		TraceProtoMaxLen: c.traceProtoLen,
+	}, nil
+}
+
+// loadConfigFile sets the flags of fs from the directives in the configuration file
+// at path, except the ones in skip. Boolean flags accept "yes" and "no", as in the
+// Redis configuration file.
+func loadConfigFile(fs *flag.FlagSet, path string, skip map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open configuration file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		directive := strings.Fields(scanner.Text())
+		if len(directive) == 0 || strings.HasPrefix(directive[0], "#") {
+			continue
+		}
+		name := strings.ToLower(directive[0])
+		value := strings.Trim(strings.Join(directive[1:], " "), `"'`)
+
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown directive '%s'", path, line, directive[0])
+		}
+		if skip[name] {
+			continue
+		}
+		if isBoolFlag(f) {
+			switch strings.ToLower(value) {
+			case "yes":
+				value = "true"
+			case "no":
+				value = "false"
+			}
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value for '%s': %w", path, line, name, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	return nil
+}
+
+// reloadConfigFile parses the configuration file again and applies the parameters
+// that changed to the running server. Parameters that can't be changed at runtime are
+// only logged, and if the file can't be parsed the running configuration is kept.
+func reloadConfigFile(s *server.Server, logger *server.Logger, fs *flag.FlagSet, path string, cmdline map[string]bool) {
+	reloaded := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	cfg := defineFlags(reloaded)
+	if err := loadConfigFile(reloaded, path, cmdline); err != nil {
+		logger.Warningf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+	if err := cfg.setDefaults(); err != nil {
+		logger.Warningf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+
+	reloaded.VisitAll(func(f *flag.Flag) {
+		if cmdline[f.Name] || f.Name == "config" {
+			return
+		}
+		current := fs.Lookup(f.Name).Value.String()
+		value := f.Value.String()
+		if value == current {
+			return
+		}
+
+		configValue := value
+		if isBoolFlag(f) {
+			configValue = "no"
+			if value == "true" {
+				configValue = "yes"
+			}
+		}
+		var err error
+		if f.Name == "logfile" {
+			// Protected from CONFIG SET, but the configuration file is trusted
+			err = logger.SetFile(value)
+		} else {
+			err = s.ConfigSet(f.Name, configValue)
+		}
+		switch {
+		case errors.Is(err, server.ErrConfigNotSettable):
+			logger.Warningf("Configuration %s changed from '%s' to '%s', restart required to apply it", f.Name, current, value)
+		case err != nil:
+			logger.Warningf("Failed to apply configuration %s '%s': %v", f.Name, value, err)
+		default:
+			if list, ok := fs.Lookup(f.Name).Value.(listFlag); ok {
+				list.replace(f.Value)
+			} else {
+				fs.Set(f.Name, value)
+			}
+			logger.Noticef("Configuration %s changed from '%s' to '%s'", f.Name, current, value)
+		}
+	})
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// listFlag is implemented by the flags that can be repeated, which Set appends to:
+// reloading the configuration replaces their values with replace instead.
+type listFlag interface {
+	flag.Value
+	replace(other flag.Value)
+}
+
+// addressList is a flag that can be repeated, each value can also be a
+// comma-separated list of addresses.
+type addressList []string
+
+func (l *addressList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *addressList) Set(value string) error {
+	for _, addr := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		*l = append(*l, addr)
+	}
+	return nil
+}
+
+func (l *addressList) replace(other flag.Value) {
+	*l = append(addressList(nil), *other.(*addressList)...)
+}
+
+// renameList is a flag that can be repeated, each value renames a command from the
+// first word to the second one, or disables it if there's no second word or if it's
+// an empty string in quotes.
+type renameList [][2]string
+
+func (l *renameList) String() string {
+	renames := make([]string, 0, len(*l))
+	for _, rename := range *l {
+		renames = append(renames, strings.TrimSpace(rename[0]+" "+rename[1]))
+	}
+	return strings.Join(renames, ",")
+}
+
+func (l *renameList) Set(value string) error {
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 1:
+		*l = append(*l, [2]string{fields[0], ""})
+	case 2:
+		newName := strings.Trim(fields[1], `"'`)
+		*l = append(*l, [2]string{fields[0], newName})
+	default:
+		return fmt.Errorf("expected a command name and its new name, got %q", value)
+	}
+	return nil
+}
+
+func (l *renameList) replace(other flag.Value) {
+	*l = append(renameList(nil), *other.(*renameList)...)
+}
+
+// limitList is a flag that can be repeated, each value sets the output buffer limits
+// of a client class. The values are joined with spaces, as CONFIG SET expects them.
+type limitList []string
+
+func (l *limitList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *limitList) Set(value string) error {
+	if len(strings.Fields(value)) != 4 {
+		return fmt.Errorf("expected a client class, a hard limit, a soft limit and seconds, got %q", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *limitList) replace(other flag.Value) {
+	*l = append(limitList(nil), *other.(*limitList)...)
+}
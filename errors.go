@@ -1,6 +0,0 @@
-package main
-
-import "errors"
-
-var wrongNumArgsError = errors.New("wrong number of arguments")
-var KeyDoesNotExist = errors.New("key does not exist")
@@ -1,3 +1,12 @@
 module tommasoamici/redis-clone
 
 go 1.18
+
+require (
+	github.com/golang/snappy v0.0.4
+	go.etcd.io/bbolt v1.3.7
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require golang.org/x/sys v0.4.0 // indirect
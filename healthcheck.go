@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// healthcheckTimeout is the time given to the server to reply to the healthcheck.
+const healthcheckTimeout = 5 * time.Second
+
+// healthcheck connects to the server described by the configuration and sends it a
+// PING, returning an error unless it replies with PONG in time. It connects to the
+// TLS port if one is configured, otherwise to the first address, or to the unix
+// socket if there are no addresses.
+func healthcheck(c *config) error {
+	conn, err := c.dialHealthcheck()
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(healthcheckTimeout))
+	client := newRESPClient(conn)
+	defer client.Close()
+
+	reply, err := client.do("PING")
+	if err != nil {
+		return fmt.Errorf("PING failed: %w", err)
+	}
+	if reply != resp.Reply(resp.SimpleString("PONG")) {
+		return fmt.Errorf("unexpected reply to PING: %v", reply)
+	}
+	return nil
+}
+
+func (c *config) dialHealthcheck() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: healthcheckTimeout}
+	if c.tlsPort != 0 {
+		host := "127.0.0.1"
+		if len(c.addrs) > 0 && strings.HasPrefix(c.network, "tcp") {
+			var err error
+			host, _, err = net.SplitHostPort(c.addrs[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %s: %w", c.addrs[0], err)
+			}
+			host = healthcheckHost(host)
+		}
+		config, err := c.healthcheckTLSConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		return tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, strconv.Itoa(c.tlsPort)), config)
+	}
+	if len(c.addrs) > 0 {
+		addr := c.addrs[0]
+		if host, port, err := net.SplitHostPort(addr); err == nil && strings.HasPrefix(c.network, "tcp") {
+			addr = net.JoinHostPort(healthcheckHost(host), port)
+		}
+		return dialer.Dial(c.network, addr)
+	}
+	if len(c.tlsAddrs) > 0 {
+		host, port, err := net.SplitHostPort(c.tlsAddrs[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %w", c.tlsAddrs[0], err)
+		}
+		host = healthcheckHost(host)
+		config, err := c.healthcheckTLSConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		return tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), config)
+	}
+	if c.unixSocket != "" {
+		return dialer.Dial("unix", c.unixSocket)
+	}
+	return nil, errors.New("no address to connect to")
+}
+
+// healthcheckHost returns the host to connect to for a host the server listens on,
+// the wildcard addresses being replaced with localhost, which is also the name the
+// TLS certificate is then verified for.
+func healthcheckHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		return "localhost"
+	}
+	return host
+}
+
+// healthcheckTLSConfig verifies the server with the CA certificate, and presents the
+// server certificate to authenticate the client, as the server requires a certificate
+// signed by the same CA.
+func (c *config) healthcheckTLSConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.tlsCACertFile != "" {
+		pem, err := os.ReadFile(c.tlsCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to load TLS CA certificate: no certificates found in %s", c.tlsCACertFile)
+		}
+	}
+	if c.tlsCertFile != "" && c.tlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.tlsCertFile, c.tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate %s and key %s: %w", c.tlsCertFile, c.tlsKeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/server"
+)
+
+func TestHealthcheckHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"", "localhost"},
+		{"0.0.0.0", "localhost"},
+		{"::", "localhost"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"::1", "::1"},
+		{"localhost", "localhost"},
+		{"redis.example.com", "redis.example.com"},
+	}
+	for _, tt := range tests {
+		if got := healthcheckHost(tt.host); got != tt.want {
+			t.Errorf("healthcheckHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost and its key to dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// freePort returns a port nothing listens on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestHealthcheck(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	port, tlsPort := strconv.Itoa(freePort(t)), freePort(t)
+	socket := filepath.Join(dir, "redis.sock")
+	s := server.New(server.Options{
+		Network:     "tcp",
+		Addrs:       []string{"127.0.0.1:" + port},
+		UnixSocket:  socket,
+		Databases:   16,
+		TLSPort:     tlsPort,
+		TLSCertFile: certFile,
+		TLSKeyFile:  keyFile,
+		Logger:      server.NewLogger(io.Discard, server.LogWarning),
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ListenAndServe()
+	}()
+	select {
+	case <-s.Ready():
+	case err := <-served:
+		t.Fatalf("ListenAndServe: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	// The healthcheck doesn't authenticate, even if redis-cli would
+	t.Setenv("REDISCLI_AUTH", "secret")
+
+	tests := []struct {
+		name    string
+		config  config
+		wantErr bool
+	}{
+		{"address", config{network: "tcp", addrs: addressList{"127.0.0.1:" + port}}, false},
+		{"wildcard address", config{network: "tcp", addrs: addressList{"0.0.0.0:" + port}}, false},
+		{"address without host", config{network: "tcp", addrs: addressList{":" + port}}, false},
+		{"unix socket", config{network: "tcp", unixSocket: socket}, false},
+		{
+			"TLS port on a wildcard address",
+			config{network: "tcp", addrs: addressList{"0.0.0.0:" + port}, tlsPort: tlsPort, tlsCACertFile: certFile},
+			false,
+		},
+		{
+			"TLS port without addresses",
+			config{network: "unix", unixSocket: socket, tlsPort: tlsPort, tlsCACertFile: certFile},
+			false,
+		},
+		{
+			"TLS address on a wildcard address",
+			config{network: "tcp", tlsAddrs: []string{"0.0.0.0:" + strconv.Itoa(tlsPort)}, tlsCACertFile: certFile},
+			false,
+		},
+		{
+			"TLS with a client certificate",
+			config{
+				network: "tcp", tlsPort: tlsPort, addrs: addressList{"127.0.0.1:" + port},
+				tlsCACertFile: certFile, tlsCertFile: certFile, tlsKeyFile: keyFile,
+			},
+			false,
+		},
+		{"TLS without the CA", config{network: "tcp", addrs: addressList{"127.0.0.1:" + port}, tlsPort: tlsPort}, true},
+		{"nothing listening", config{network: "tcp", addrs: addressList{"127.0.0.1:" + strconv.Itoa(freePort(t))}}, true},
+		{"no address", config{network: "tcp"}, true},
+		{"invalid TLS address", config{network: "tcp", tlsAddrs: []string{"no port"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := healthcheck(&tt.config)
+			if tt.wantErr && err == nil {
+				t.Error("healthcheck succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("healthcheck: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const (
+	// MaxBulkLen is the maximum length of a bulk string, 512 MB as in Redis.
+	MaxBulkLen = 512 * 1024 * 1024
+	// MaxMultibulkLen is the maximum number of arguments of a command.
+	MaxMultibulkLen = 1024 * 1024
+	// MaxInlineLen is the maximum length of an inline command.
+	MaxInlineLen = 64 * 1024
+)
+
+// ProtocolError is returned when the data read doesn't follow the protocol. The
+// stream can't be parsed any further after a protocol error.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// Reader parses RESP values from a buffered stream.
+// The buffers of a Reader are reused: the arguments returned by ReadCommand are only
+// valid until the next call, and Release hands the buffers over to other Readers.
+type Reader struct {
+	r *bufio.Reader
+	// buf holds the arguments of the last command, args are sub-slices of it
+	buf  []byte
+	args [][]byte
+	ends []int
+	// tracer records the frames read, if tracing is enabled
+	tracer *Tracer
+}
+
+var bufioReaderPool sync.Pool
+
+// maxPooledBufLen is the largest argument buffer kept between commands, the buffer
+// grown to read a larger command is released afterwards.
+const maxPooledBufLen = 64 * 1024
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	br, _ := bufioReaderPool.Get().(*bufio.Reader)
+	if br == nil {
+		br = bufio.NewReader(r)
+	} else {
+		br.Reset(r)
+	}
+	return &Reader{r: br}
+}
+
+// Release returns the buffers of the Reader to a pool shared by all Readers, the
+// Reader must not be used afterwards.
+func (r *Reader) Release() {
+	if r.r == nil {
+		return
+	}
+	r.r.Reset(nil)
+	bufioReaderPool.Put(r.r)
+	r.r = nil
+	r.buf = nil
+	r.args = nil
+	r.ends = nil
+}
+
+// Buffered returns the number of bytes that have been read from the underlying
+// reader but not parsed yet, e.g. because the client pipelined several commands.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
+// ReadCommand reads the next command sent by a client, in either of the two formats
+// accepted by Redis, and returns its arguments, the command name being the first one.
+// Empty inline commands result in no arguments and no error.
+// The arguments share a buffer that is reused by the next call, callers must copy
+// anything they keep afterwards.
+//
+// A client sends the Redis server a RESP Array consisting of only Bulk Strings.
+// A Redis server replies to clients, sending any valid RESP data type as a reply.
+// So for example a typical interaction could be the following.
+// The client sends the command `LLEN mylist` in order to get the length of the list
+// stored at key `mylist`. Then the server replies with an Integer reply as in the
+// following example (C: is the client, S: the server).
+//     C: *2\r\n
+//     C: $4\r\n
+//     C: LLEN\r\n
+//     C: $6\r\n
+//     C: mylist\r\n
+//     S: :48293\r\n
+// As usual, we separate different parts of the protocol with newlines for simplicity,
+// but the actual interaction is the client sending
+//     *2\r\n$4\r\nLLEN\r\n$6\r\nmylist\r\n.
+// https://redis.io/docs/reference/protocol-spec/#send-commands-to-a-redis-server
+//
+// While the Redis protocol is simple to implement, it is not ideal to use in interactive
+// sessions, and redis-cli may not always be available. For this reason, Redis also
+// accepts commands in the inline command format.
+// Basically, you write space-separated arguments in a telnet session. Since no command
+// starts with * that is instead used in the unified request protocol, Redis is able to
+// detect this condition and parse your command.
+// https://redis.io/docs/reference/protocol-spec/#inline-commands
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	if r.tracer != nil {
+		r.tracer.enter()
+		defer r.tracer.leave()
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if cap(r.buf) > maxPooledBufLen {
+		r.buf = nil
+	}
+	r.buf = r.buf[:0]
+	r.ends = r.ends[:0]
+	if len(line) > 0 && line[0] == ArrayPrefix {
+		return r.readMultibulk(line)
+	}
+	return r.readInline(line), nil
+}
+
+// readInline splits the line on whitespace. line points into the buffer of the
+// bufio.Reader, so the arguments are copied to the argument buffer.
+func (r *Reader) readInline(line []byte) [][]byte {
+	r.buf = append(r.buf, line...)
+	r.args = r.args[:0]
+	start := -1
+	for i, b := range r.buf {
+		if isSpace(b) {
+			if start >= 0 {
+				r.args = append(r.args, r.buf[start:i:i])
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		r.args = append(r.args, r.buf[start:len(r.buf):len(r.buf)])
+	}
+	return r.args
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\r', '\n', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+// readMultibulk reads the bulk strings one after the other in the argument buffer,
+// which may be reallocated while growing, so the arguments are sliced at the end.
+func (r *Reader) readMultibulk(header []byte) ([][]byte, error) {
+	n, ok := parseInt(header[1:])
+	if !ok || n > MaxMultibulkLen {
+		return nil, &ProtocolError{"invalid multibulk length"}
+	}
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != BulkPrefix {
+			got := byte(' ')
+			if len(line) > 0 {
+				got = line[0]
+			}
+			return nil, &ProtocolError{fmt.Sprintf("expected '$', got '%c'", got)}
+		}
+		size, ok := parseInt(line[1:])
+		if !ok || size < 0 || size > MaxBulkLen {
+			return nil, &ProtocolError{"invalid bulk length"}
+		}
+		if err := r.appendBulk(size); err != nil {
+			return nil, err
+		}
+		r.ends = append(r.ends, len(r.buf))
+	}
+	r.args = r.args[:0]
+	start := 0
+	for _, end := range r.ends {
+		r.args = append(r.args, r.buf[start:end:end])
+		start = end
+	}
+	return r.args, nil
+}
+
+// appendBulk reads size bytes followed by CRLF, appending them to the argument buffer.
+func (r *Reader) appendBulk(size int) error {
+	start := len(r.buf)
+	if free := cap(r.buf) - start; free < size+2 {
+		grown := make([]byte, start, start+size+2+start)
+		copy(grown, r.buf)
+		r.buf = grown
+	}
+	r.buf = r.buf[:start+size+2]
+	if _, err := io.ReadFull(r.r, r.buf[start:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if r.tracer != nil {
+		r.tracer.record(r.buf[start:])
+	}
+	if r.buf[start+size] != '\r' || r.buf[start+size+1] != '\n' {
+		return &ProtocolError{"invalid bulk terminator"}
+	}
+	r.buf = r.buf[:start+size]
+	return nil
+}
+
+// parseInt parses a decimal integer without allocating, unlike strconv.Atoi.
+func parseInt(b []byte) (int, bool) {
+	neg := false
+	if len(b) > 0 && (b[0] == '-' || b[0] == '+') {
+		neg = b[0] == '-'
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// ReadReply reads the next value sent by a server, of any of the RESP2 and RESP3
+// types. Attributes are read and discarded.
+func (r *Reader) ReadReply() (Reply, error) {
+	if r.tracer != nil {
+		r.tracer.enter()
+		defer r.tracer.leave()
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, &ProtocolError{"empty reply"}
+	}
+	prefix, body := line[0], line[1:]
+	switch prefix {
+	case SimpleStringPrefix:
+		return SimpleString(body), nil
+	case ErrorPrefix:
+		return Error(body), nil
+	case IntPrefix:
+		n, err := strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return nil, &ProtocolError{"invalid integer"}
+		}
+		return Int(n), nil
+	case NullPrefix:
+		return Null{}, nil
+	case DoublePrefix:
+		f, err := strconv.ParseFloat(string(body), 64)
+		if err != nil {
+			return nil, &ProtocolError{"invalid double"}
+		}
+		return Double(f), nil
+	case BooleanPrefix:
+		switch string(body) {
+		case "t":
+			return Boolean(true), nil
+		case "f":
+			return Boolean(false), nil
+		}
+		return nil, &ProtocolError{"invalid boolean"}
+	case BigNumberPrefix:
+		return BigNumber(body), nil
+	case BulkPrefix, BlobErrorPrefix, VerbatimPrefix:
+		size, err := strconv.Atoi(string(body))
+		if err != nil || size > MaxBulkLen {
+			return nil, &ProtocolError{"invalid bulk length"}
+		}
+		if size < 0 {
+			return Null{}, nil
+		}
+		b, err := r.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		switch prefix {
+		case BlobErrorPrefix:
+			return Error(b), nil
+		case VerbatimPrefix:
+			if len(b) < 4 || b[3] != ':' {
+				return nil, &ProtocolError{"invalid verbatim string"}
+			}
+			return Verbatim{Format: string(b[:3]), Text: string(b[4:])}, nil
+		}
+		return BulkString(b), nil
+	case ArrayPrefix, SetPrefix, PushPrefix, MapPrefix, AttributePrefix:
+		n, err := strconv.Atoi(string(body))
+		if err != nil || n > MaxMultibulkLen {
+			return nil, &ProtocolError{"invalid multibulk length"}
+		}
+		if n < 0 {
+			return NullArray{}, nil
+		}
+		if prefix == MapPrefix || prefix == AttributePrefix {
+			n *= 2
+		}
+		items := make([]Reply, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := r.ReadReply()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		switch prefix {
+		case SetPrefix:
+			return Set(items), nil
+		case PushPrefix:
+			return Push(items), nil
+		case MapPrefix:
+			return Map(items), nil
+		case AttributePrefix:
+			// Attributes describe the reply that follows them
+			return r.ReadReply()
+		}
+		return Array(items), nil
+	}
+	return nil, &ProtocolError{fmt.Sprintf("unknown type '%c'", prefix)}
+}
+
+// readLine returns the next line without the trailing CRLF, or LF for inline commands
+// typed in a telnet session. The line is only valid until the next read.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			if len(long) > MaxInlineLen {
+				return nil, &ProtocolError{"too big inline request"}
+			}
+			line, err = r.r.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.tracer != nil {
+		r.tracer.record(line)
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// readBulk reads size bytes followed by CRLF. Bulk strings are binary safe, so the
+// content is read as is.
+func (r *Reader) readBulk(size int) ([]byte, error) {
+	b := make([]byte, size+2)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.tracer != nil {
+		r.tracer.record(b)
+	}
+	if b[size] != '\r' || b[size+1] != '\n' {
+		return nil, &ProtocolError{"invalid bulk terminator"}
+	}
+	return b[:size:size], nil
+}
@@ -0,0 +1,253 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// command encodes args as a multibulk command.
+func command(args ...string) string {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteArrayLen(len(args))
+	for _, arg := range args {
+		w.WriteBulkString(arg)
+	}
+	return buf.String()
+}
+
+func strs(args [][]byte) []string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = string(arg)
+	}
+	return s
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  [][]string
+	}{
+		{"multibulk", command("SET", "key", "value"), [][]string{{"SET", "key", "value"}}},
+		{"binary arguments", command("SET", "a\r\nb", "\x00\xff"), [][]string{{"SET", "a\r\nb", "\x00\xff"}}},
+		{"empty argument", command("SET", "", ""), [][]string{{"SET", "", ""}}},
+		{"empty multibulk", "*0\r\n", [][]string{{}}},
+		{"inline", "SET key value\r\n", [][]string{{"SET", "key", "value"}}},
+		{"inline with LF", "PING\n", [][]string{{"PING"}}},
+		{"inline with extra spaces", "  GET \t key  \r\n", [][]string{{"GET", "key"}}},
+		{"empty inline", "\r\n", [][]string{{}}},
+		{
+			"pipelined",
+			command("GET", "a") + "PING\r\n" + command("DEL", "a", "b"),
+			[][]string{{"GET", "a"}, {"PING"}, {"DEL", "a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			defer r.Release()
+			for _, want := range tt.want {
+				args, err := r.ReadCommand()
+				if err != nil {
+					t.Fatalf("ReadCommand: %v", err)
+				}
+				if got := strs(args); !reflect.DeepEqual(got, want) {
+					t.Errorf("ReadCommand = %q, want %q", got, want)
+				}
+			}
+			if _, err := r.ReadCommand(); err != io.EOF {
+				t.Errorf("ReadCommand at the end returned %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// protocol is set if the error must be a ProtocolError
+		protocol bool
+	}{
+		{"invalid multibulk length", "*x\r\n", true},
+		{"multibulk too long", "*2000000\r\n", true},
+		{"missing bulk prefix", "*1\r\n:1\r\n", true},
+		{"invalid bulk length", "*1\r\n$-1\r\n", true},
+		{"bulk too long", "*1\r\n$600000000\r\n", true},
+		{"invalid bulk terminator", "*1\r\n$3\r\nabcde\r\n", true},
+		{"inline too long", strings.Repeat("a", 2*MaxInlineLen), true},
+		{"truncated bulk", "*1\r\n$10\r\nabc", false},
+		{"truncated line", "*1\r\n$3", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			defer r.Release()
+			_, err := r.ReadCommand()
+			var protocolErr *ProtocolError
+			if tt.protocol && !errors.As(err, &protocolErr) {
+				t.Errorf("ReadCommand returned %v, want a protocol error", err)
+			}
+			if !tt.protocol && err != io.ErrUnexpectedEOF {
+				t.Errorf("ReadCommand returned %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+}
+
+func TestReadCommandReusesBuffers(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"multibulk", command("SET", "key", "value")},
+		{"inline", "SET key value\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A reader that never runs out of commands
+			src := &repeatReader{data: []byte(tt.input)}
+			r := NewReader(src)
+			defer r.Release()
+
+			first, err := r.ReadCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Appending to an argument mustn't overwrite the next one
+			_ = append(first[1], "XXXXXX"...)
+			if got := strs(first); !reflect.DeepEqual(got, []string{"SET", "key", "value"}) {
+				t.Errorf("arguments = %q after appending to one", got)
+			}
+
+			allocs := testing.AllocsPerRun(100, func() {
+				if _, err := r.ReadCommand(); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("%v allocations per command, want 0", allocs)
+			}
+		})
+	}
+}
+
+func TestReadCommandReleasesLargeBuffers(t *testing.T) {
+	large := strings.Repeat("x", 4*maxPooledBufLen)
+	r := NewReader(strings.NewReader(command("SET", "key", large) + command("GET", "key")))
+	defer r.Release()
+
+	args, err := r.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(args[2]) != large {
+		t.Fatalf("read a value of %d bytes, want %d", len(args[2]), len(large))
+	}
+	if _, err := r.ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if cap(r.buf) > maxPooledBufLen {
+		t.Errorf("argument buffer of %d bytes kept, want at most %d", cap(r.buf), maxPooledBufLen)
+	}
+}
+
+// repeatReader returns data over and over.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol int
+		reply    Reply
+		want     Reply
+	}{
+		{"simple string", 2, SimpleString("OK"), SimpleString("OK")},
+		{"error", 2, Error("ERR wrong"), Error("ERR wrong")},
+		{"int", 2, Int(math.MaxInt64), Int(math.MaxInt64)},
+		{"bulk string", 2, BulkString("a\r\nb"), BulkString("a\r\nb")},
+		{"null", 2, Null{}, Null{}},
+		{"null array", 2, NullArray{}, NullArray{}},
+		{"array", 2, Array{Int(1), BulkString("x"), Array{}}, Array{Int(1), BulkString("x"), Array{}}},
+		{"RESP2 map", 2, Map{BulkString("k"), Int(1)}, Array{BulkString("k"), Int(1)}},
+		{"map", 3, Map{BulkString("k"), Int(1)}, Map{BulkString("k"), Int(1)}},
+		{"set", 3, Set{Int(1)}, Set{Int(1)}},
+		{"push", 3, Push{BulkString("message")}, Push{BulkString("message")}},
+		{"resp3 null", 3, Null{}, Null{}},
+		{"double", 3, Double(2.5), Double(2.5)},
+		{"boolean", 3, Boolean(true), Boolean(true)},
+		{"big number", 3, BigNumber("123456789012345678901234567890"), BigNumber("123456789012345678901234567890")},
+		{"verbatim", 3, Verbatim{"txt", "text"}, Verbatim{"txt", "text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetProtocol(tt.protocol)
+			if err := w.WriteReply(tt.reply); err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(&buf)
+			defer r.Release()
+			got, err := r.ReadReply()
+			if err != nil {
+				t.Fatalf("ReadReply: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadReply = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderRelease(t *testing.T) {
+	r := NewReader(strings.NewReader(command("PING")))
+	if _, err := r.ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	r.Release()
+	// Releasing twice is harmless
+	r.Release()
+
+	// The pooled buffers read from the new source only
+	other := NewReader(strings.NewReader(command("ECHO", "hi")))
+	defer other.Release()
+	args, err := other.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strs(args); !reflect.DeepEqual(got, []string{"ECHO", "hi"}) {
+		t.Errorf("ReadCommand = %q, want [ECHO hi]", got)
+	}
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	r := NewReader(&repeatReader{data: []byte(command("SET", "key:000000000001", "some value"))})
+	defer r.Release()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.ReadCommand(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package resp implements RESP, the Redis serialization protocol, in its versions 2
+// and 3.
+//
+// A Reader parses the commands sent by clients, both in the multibulk and in the
+// inline format, as well as the replies sent by servers. A Writer encodes replies.
+// Both work on any io.Reader and io.Writer, so they can be used on network
+// connections as well as on in-memory buffers.
+// https://redis.io/docs/reference/protocol-spec/
+package resp
+
+// The first byte of every RESP value identifies its type
+const (
+	SimpleStringPrefix = '+'
+	ErrorPrefix        = '-'
+	IntPrefix          = ':'
+	BulkPrefix         = '$'
+	ArrayPrefix        = '*'
+
+	// Types introduced by RESP3
+	NullPrefix      = '_'
+	DoublePrefix    = ','
+	BooleanPrefix   = '#'
+	BlobErrorPrefix = '!'
+	VerbatimPrefix  = '='
+	BigNumberPrefix = '('
+	MapPrefix       = '%'
+	SetPrefix       = '~'
+	AttributePrefix = '|'
+	PushPrefix      = '>'
+)
+
+// Reply is a value of one of the RESP types defined in this package. Readers return
+// replies from ReadReply, and Writers encode them with WriteReply.
+type Reply interface {
+	writeTo(w *Writer) error
+}
+
+// SimpleString is a string that can't contain CR or LF characters.
+type SimpleString string
+
+// Error is an error reply. By convention the first word is the error type, e.g.
+// "ERR" or "WRONGTYPE", followed by the error message.
+type Error string
+
+// Int is a signed 64 bit integer.
+type Int int64
+
+// BulkString is a binary-safe string.
+type BulkString []byte
+
+// Null represents a missing value: a Null Bulk String in RESP2, or the Null type in RESP3.
+type Null struct{}
+
+// NullArray represents a missing array: a Null Array in RESP2, or the Null type in RESP3.
+type NullArray struct{}
+
+// Array is an ordered collection of replies.
+type Array []Reply
+
+// Map is a collection of key and value pairs, stored in the slice one after the other.
+// It's sent as a flat array of keys and values to RESP2 clients.
+type Map []Reply
+
+// Set is an unordered collection of replies, sent as an array to RESP2 clients.
+type Set []Reply
+
+// Push is an out of band message sent by the server, sent as an array to RESP2 clients.
+type Push []Reply
+
+// Multi is a sequence of replies written one after the other, for the commands that
+// reply more than once, such as SUBSCRIBE with several channels.
+type Multi []Reply
+
+// Double is a floating point number, sent as a bulk string to RESP2 clients.
+type Double float64
+
+// Boolean is sent as the integers 1 and 0 to RESP2 clients.
+type Boolean bool
+
+// BigNumber is an integer outside of the range of Int, sent as a bulk string to
+// RESP2 clients.
+type BigNumber string
+
+// Verbatim is a string along with its format, e.g. "txt" or "mkd". It's sent as a
+// bulk string to RESP2 clients.
+type Verbatim struct {
+	Format string
+	Text   string
+}
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+func (r SimpleString) writeTo(w *Writer) error { return w.WriteSimpleString(string(r)) }
+func (r Error) writeTo(w *Writer) error        { return w.WriteError(string(r)) }
+func (r Int) writeTo(w *Writer) error          { return w.WriteInt(int64(r)) }
+func (r BulkString) writeTo(w *Writer) error   { return w.WriteBulk(r) }
+func (r Null) writeTo(w *Writer) error         { return w.WriteNull() }
+func (r NullArray) writeTo(w *Writer) error    { return w.WriteNullArray() }
+func (r Double) writeTo(w *Writer) error       { return w.WriteDouble(float64(r)) }
+func (r Boolean) writeTo(w *Writer) error      { return w.WriteBool(bool(r)) }
+func (r BigNumber) writeTo(w *Writer) error    { return w.WriteBigNumber(string(r)) }
+func (r Verbatim) writeTo(w *Writer) error     { return w.WriteVerbatim(r.Format, r.Text) }
+
+func (r Multi) writeTo(w *Writer) error {
+	return writeAll(w, r)
+}
+
+func (r Array) writeTo(w *Writer) error {
+	if err := w.WriteArrayLen(len(r)); err != nil {
+		return err
+	}
+	return writeAll(w, r)
+}
+
+func (r Map) writeTo(w *Writer) error {
+	if err := w.WriteMapLen(len(r) / 2); err != nil {
+		return err
+	}
+	return writeAll(w, r)
+}
+
+func (r Set) writeTo(w *Writer) error {
+	if err := w.WriteSetLen(len(r)); err != nil {
+		return err
+	}
+	return writeAll(w, r)
+}
+
+func (r Push) writeTo(w *Writer) error {
+	if err := w.WritePushLen(len(r)); err != nil {
+		return err
+	}
+	return writeAll(w, r)
+}
+
+func writeAll(w *Writer, replies []Reply) error {
+	for _, r := range replies {
+		if err := w.WriteReply(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
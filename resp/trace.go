@@ -0,0 +1,90 @@
+package resp
+
+import "io"
+
+// Tracer records the raw bytes of the frames read by a Reader or written by a
+// Writer, to debug the interoperability with clients. A frame is a whole command
+// read with ReadCommand, a whole reply read with ReadReply or a whole value written
+// with WriteReply, exactly as it crossed the wire. Only the first Limit bytes of a
+// frame are kept, so tracing a large bulk string doesn't copy it, but its full size
+// is reported.
+// A Tracer must only be used by a single Reader or Writer.
+type Tracer struct {
+	// Limit is the number of bytes of each frame passed to Trace, the rest of the
+	// frame is only counted.
+	Limit int
+	// Trace is called once a frame has been read or written, with its first Limit
+	// bytes and its full size. frame is only valid until Trace returns.
+	Trace func(frame []byte, size int)
+
+	buf  []byte
+	size int
+	// depth counts the nested calls of WriteReply and ReadReply, the frame ends
+	// when the outermost one returns
+	depth int
+}
+
+func (t *Tracer) record(b []byte) {
+	t.size += len(b)
+	if free := t.Limit - len(t.buf); free > 0 {
+		if len(b) > free {
+			b = b[:free]
+		}
+		t.buf = append(t.buf, b...)
+	}
+}
+
+func (t *Tracer) enter() {
+	t.depth++
+}
+
+// leave ends the frame when the outermost call returns.
+func (t *Tracer) leave() {
+	t.depth--
+	if t.depth == 0 {
+		t.end()
+	}
+}
+
+// end passes the recorded frame to Trace, if anything was recorded.
+func (t *Tracer) end() {
+	if t.size > 0 {
+		t.Trace(t.buf, t.size)
+	}
+	if cap(t.buf) > maxScratchLen {
+		t.buf = nil
+	}
+	t.buf = t.buf[:0]
+	t.size = 0
+}
+
+// tracingWriter records the bytes written to w, it replaces the destination of a
+// Writer while tracing.
+type tracingWriter struct {
+	w io.Writer
+	t *Tracer
+}
+
+func (tw tracingWriter) Write(p []byte) (int, error) {
+	n, err := tw.w.Write(p)
+	tw.t.record(p[:n])
+	return n, err
+}
+
+// SetTracer starts tracing the commands and replies read, or stops if t is nil.
+func (r *Reader) SetTracer(t *Tracer) {
+	r.tracer = t
+}
+
+// SetTracer starts tracing the values written, or stops if t is nil. Values are
+// traced one frame for each call of WriteReply, the bytes written by the other
+// methods are part of the frame written next.
+func (w *Writer) SetTracer(t *Tracer) {
+	if tw, ok := w.w.(tracingWriter); ok {
+		w.w = tw.w
+	}
+	w.tracer = t
+	if t != nil {
+		w.w = tracingWriter{w: w.w, t: t}
+	}
+}
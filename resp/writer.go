@@ -0,0 +1,281 @@
+package resp
+
+import (
+	"io"
+	"math"
+	"strconv"
+)
+
+// Writer encodes RESP values. Values are encoded with RESP2 unless the protocol is
+// changed with SetProtocol, in which case the types introduced by RESP3 are sent as
+// such instead of being converted to the closest RESP2 type.
+// Every value is encoded into a scratch buffer owned by the Writer and then written
+// with a single call, so encoding doesn't allocate. Writer doesn't buffer its output
+// across values, wrap the destination in a bufio.Writer to avoid a syscall per value.
+type Writer struct {
+	w        io.Writer
+	protocol int
+	scratch  []byte
+	// tracer records the frames written, if tracing is enabled
+	tracer *Tracer
+}
+
+// maxScratchLen is the largest scratch buffer kept between values, the buffer grown to
+// encode a larger bulk string is released afterwards.
+const maxScratchLen = 64 * 1024
+
+// NewWriter returns a Writer that encodes values to w using RESP2.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, protocol: 2}
+}
+
+// Protocol returns the version of the protocol used to encode values, 2 or 3.
+func (w *Writer) Protocol() int {
+	return w.protocol
+}
+
+// SetProtocol changes the version of the protocol used to encode values, 2 or 3.
+func (w *Writer) SetProtocol(version int) {
+	w.protocol = version
+}
+
+// flush writes the encoded value in the scratch buffer.
+func (w *Writer) flush(b []byte) error {
+	_, err := w.w.Write(b)
+	if cap(b) > maxScratchLen {
+		w.scratch = nil
+	} else {
+		w.scratch = b[:0]
+	}
+	return err
+}
+
+// appendLine appends a value made of its type prefix, s and CRLF.
+func appendLine(b []byte, prefix byte, s string) []byte {
+	b = append(b, prefix)
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+// appendInt appends a value made of its type prefix, n and CRLF.
+func appendInt(b []byte, prefix byte, n int64) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, n, 10)
+	return append(b, '\r', '\n')
+}
+
+// WriteReply encodes any of the reply types of this package, a nil reply is encoded
+// as Null.
+func (w *Writer) WriteReply(r Reply) error {
+	if w.tracer != nil {
+		w.tracer.enter()
+		defer w.tracer.leave()
+	}
+	if r == nil {
+		return w.WriteNull()
+	}
+	return r.writeTo(w)
+}
+
+// Simple Strings are encoded as follows: a plus character, followed by a string that
+// cannot contain a CR or LF character (no newlines are allowed), and terminated by CRLF (that is "\r\n").
+// For example:
+//     "+OK\r\n"
+// https://redis.io/docs/reference/protocol-spec/#resp-simple-strings
+func (w *Writer) WriteSimpleString(s string) error {
+	return w.flush(appendLine(w.scratch, SimpleStringPrefix, s))
+}
+
+// RESP has a specific data type for errors. They are similar to RESP Simple Strings,
+// but the first character is a minus ‘-’ character instead of a plus. The real
+// difference between Simple Strings and Errors in RESP is that clients treat errors
+// as exceptions, and the string that composes the Error type is the error message itself.
+// https://redis.io/docs/reference/protocol-spec/#resp-errors
+func (w *Writer) WriteError(msg string) error {
+	return w.flush(appendLine(w.scratch, ErrorPrefix, msg))
+}
+
+// This type is just a CRLF-terminated string that represents an integer, prefixed by a
+// ':' byte. For example, ":0\r\n" and ":1000\r\n" are integer replies.
+// https://redis.io/docs/reference/protocol-spec/#resp-integers
+func (w *Writer) WriteInt(n int64) error {
+	return w.flush(appendInt(w.scratch, IntPrefix, n))
+}
+
+// Bulk Strings are used in order to represent a single binary-safe string up to 512 MB in length.
+// Bulk Strings are encoded in the following way:
+//     - A '$' byte followed by the number of bytes composing the string (a prefixed length), terminated by CRLF.
+//     - The actual string data.
+//     - A final CRLF.
+// So the string "hello" is encoded as follows:
+//     "$5\r\nhello\r\n"
+// https://redis.io/docs/reference/protocol-spec/#resp-bulk-strings
+// Strings larger than maxScratchLen are streamed instead, see writeLargeBulk.
+func (w *Writer) WriteBulk(b []byte) error {
+	if len(b) > maxScratchLen {
+		return w.writeLargeBulk(len(b), func(i, j int) error {
+			_, err := w.w.Write(b[i:j])
+			return err
+		})
+	}
+	buf := appendInt(w.scratch, BulkPrefix, int64(len(b)))
+	buf = append(buf, b...)
+	buf = append(buf, '\r', '\n')
+	return w.flush(buf)
+}
+
+// WriteBulkString writes s as a Bulk String.
+func (w *Writer) WriteBulkString(s string) error {
+	if len(s) > maxScratchLen {
+		return w.writeLargeBulk(len(s), func(i, j int) error {
+			_, err := io.WriteString(w.w, s[i:j])
+			return err
+		})
+	}
+	buf := appendInt(w.scratch, BulkPrefix, int64(len(s)))
+	buf = append(buf, s...)
+	buf = append(buf, '\r', '\n')
+	return w.flush(buf)
+}
+
+// writeLargeBulk writes the header of a Bulk String of n bytes, then calls
+// writeChunk to write the payload from i to j, in chunks of maxScratchLen bytes, and
+// finally the CRLF. The payload is never copied as a whole, and a buffered
+// destination passes the chunks on as they're written.
+func (w *Writer) writeLargeBulk(n int, writeChunk func(i, j int) error) error {
+	if err := w.flush(appendInt(w.scratch, BulkPrefix, int64(n))); err != nil {
+		return err
+	}
+	for i := 0; i < n; i += maxScratchLen {
+		j := i + maxScratchLen
+		if j > n {
+			j = n
+		}
+		if err := writeChunk(i, j); err != nil {
+			return err
+		}
+	}
+	return w.flush(append(w.scratch, '\r', '\n'))
+}
+
+// RESP Bulk Strings can also be used in order to signal non-existence of a value using
+// a special format to represent a Null value. In this format, the length is -1, and
+// there is no data. Null is represented as:
+//     "$-1\r\n"
+// This is called a Null Bulk String. RESP3 has a dedicated Null type instead:
+//     "_\r\n"
+// https://redis.io/docs/reference/protocol-spec/#resp-bulk-strings
+func (w *Writer) WriteNull() error {
+	if w.protocol >= 3 {
+		return w.flush(appendLine(w.scratch, NullPrefix, ""))
+	}
+	return w.flush(appendInt(w.scratch, BulkPrefix, -1))
+}
+
+// WriteNullArray writes a Null Array, "*-1\r\n", used by some commands in RESP2 to
+// signal a missing array. In RESP3 it's the Null type.
+func (w *Writer) WriteNullArray() error {
+	if w.protocol >= 3 {
+		return w.flush(appendLine(w.scratch, NullPrefix, ""))
+	}
+	return w.flush(appendInt(w.scratch, ArrayPrefix, -1))
+}
+
+// Arrays are sent using the following format:
+//     - A '*' character as the first byte, followed by the number of elements in the array as a decimal number, followed by CRLF.
+//     - An additional RESP type for every element of the Array.
+// WriteArrayLen writes the header of an array of n elements, which must be followed
+// by the elements themselves.
+// https://redis.io/docs/reference/protocol-spec/#resp-arrays
+func (w *Writer) WriteArrayLen(n int) error {
+	return w.flush(appendInt(w.scratch, ArrayPrefix, int64(n)))
+}
+
+// WriteMapLen writes the header of a map of n key and value pairs, which must be
+// followed by the keys and values. RESP2 clients receive an array of 2*n elements.
+func (w *Writer) WriteMapLen(n int) error {
+	if w.protocol < 3 {
+		return w.WriteArrayLen(2 * n)
+	}
+	return w.flush(appendInt(w.scratch, MapPrefix, int64(n)))
+}
+
+// WriteSetLen writes the header of a set of n elements, which must be followed by
+// the elements. RESP2 clients receive an array.
+func (w *Writer) WriteSetLen(n int) error {
+	if w.protocol < 3 {
+		return w.WriteArrayLen(n)
+	}
+	return w.flush(appendInt(w.scratch, SetPrefix, int64(n)))
+}
+
+// WritePushLen writes the header of a push message of n elements, which must be
+// followed by the elements. RESP2 clients receive an array.
+func (w *Writer) WritePushLen(n int) error {
+	if w.protocol < 3 {
+		return w.WriteArrayLen(n)
+	}
+	return w.flush(appendInt(w.scratch, PushPrefix, int64(n)))
+}
+
+// WriteDouble writes a floating point number. RESP2 clients receive it as a bulk string.
+func (w *Writer) WriteDouble(f float64) error {
+	var tmp [32]byte
+	var num []byte
+	switch {
+	case math.IsInf(f, 1):
+		num = append(tmp[:0], "inf"...)
+	case math.IsInf(f, -1):
+		num = append(tmp[:0], "-inf"...)
+	case math.IsNaN(f):
+		num = append(tmp[:0], "nan"...)
+	default:
+		num = strconv.AppendFloat(tmp[:0], f, 'g', -1, 64)
+	}
+	var buf []byte
+	if w.protocol < 3 {
+		buf = appendInt(w.scratch, BulkPrefix, int64(len(num)))
+	} else {
+		buf = append(w.scratch, DoublePrefix)
+	}
+	buf = append(buf, num...)
+	buf = append(buf, '\r', '\n')
+	return w.flush(buf)
+}
+
+// WriteBool writes a boolean. RESP2 clients receive it as the integers 1 and 0.
+func (w *Writer) WriteBool(b bool) error {
+	if w.protocol < 3 {
+		if b {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	}
+	if b {
+		return w.flush(appendLine(w.scratch, BooleanPrefix, "t"))
+	}
+	return w.flush(appendLine(w.scratch, BooleanPrefix, "f"))
+}
+
+// WriteBigNumber writes an integer of arbitrary size, given as its decimal
+// representation. RESP2 clients receive it as a bulk string.
+func (w *Writer) WriteBigNumber(s string) error {
+	if w.protocol < 3 {
+		return w.WriteBulkString(s)
+	}
+	return w.flush(appendLine(w.scratch, BigNumberPrefix, s))
+}
+
+// WriteVerbatim writes a string along with its three characters format, e.g. "txt".
+// RESP2 clients receive the text as a bulk string.
+func (w *Writer) WriteVerbatim(format, text string) error {
+	if w.protocol < 3 {
+		return w.WriteBulkString(text)
+	}
+	buf := appendInt(w.scratch, VerbatimPrefix, int64(len(format)+1+len(text)))
+	buf = append(buf, format...)
+	buf = append(buf, ':')
+	buf = append(buf, text...)
+	buf = append(buf, '\r', '\n')
+	return w.flush(buf)
+}
@@ -0,0 +1,221 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestWriteReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+		resp2 string
+		resp3 string
+	}{
+		{"simple string", SimpleString("OK"), "+OK\r\n", "+OK\r\n"},
+		{"error", Error("ERR unknown command"), "-ERR unknown command\r\n", "-ERR unknown command\r\n"},
+		{"int", Int(1000), ":1000\r\n", ":1000\r\n"},
+		{"negative int", Int(-42), ":-42\r\n", ":-42\r\n"},
+		{"min int", Int(math.MinInt64), ":-9223372036854775808\r\n", ":-9223372036854775808\r\n"},
+		{"bulk string", BulkString("hello"), "$5\r\nhello\r\n", "$5\r\nhello\r\n"},
+		{"empty bulk string", BulkString(""), "$0\r\n\r\n", "$0\r\n\r\n"},
+		{"binary bulk string", BulkString("a\r\n\x00b"), "$5\r\na\r\n\x00b\r\n", "$5\r\na\r\n\x00b\r\n"},
+		{"nil", nil, "$-1\r\n", "_\r\n"},
+		{"null", Null{}, "$-1\r\n", "_\r\n"},
+		{"null array", NullArray{}, "*-1\r\n", "_\r\n"},
+		{"empty array", Array{}, "*0\r\n", "*0\r\n"},
+		{
+			"nested array",
+			Array{Int(1), Array{BulkString("a"), Null{}}},
+			"*2\r\n:1\r\n*2\r\n$1\r\na\r\n$-1\r\n",
+			"*2\r\n:1\r\n*2\r\n$1\r\na\r\n_\r\n",
+		},
+		{
+			"map",
+			Map{BulkString("k"), Int(1)},
+			"*2\r\n$1\r\nk\r\n:1\r\n",
+			"%1\r\n$1\r\nk\r\n:1\r\n",
+		},
+		{"set", Set{Int(1), Int(2)}, "*2\r\n:1\r\n:2\r\n", "~2\r\n:1\r\n:2\r\n"},
+		{
+			"push",
+			Push{BulkString("message"), BulkString("hi")},
+			"*2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n",
+			">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n",
+		},
+		{"multi", Multi{Int(1), SimpleString("OK")}, ":1\r\n+OK\r\n", ":1\r\n+OK\r\n"},
+		{"double", Double(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"infinite double", Double(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"nan double", Double(math.NaN()), "$3\r\nnan\r\n", ",nan\r\n"},
+		{"true", Boolean(true), ":1\r\n", "#t\r\n"},
+		{"false", Boolean(false), ":0\r\n", "#f\r\n"},
+		{
+			"big number",
+			BigNumber("3492890328409238509324850943850943825024385"),
+			"$43\r\n3492890328409238509324850943850943825024385\r\n",
+			"(3492890328409238509324850943850943825024385\r\n",
+		},
+		{"verbatim", Verbatim{"txt", "Some string"}, "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+	}
+	for _, tt := range tests {
+		for _, protocol := range []int{2, 3} {
+			want := tt.resp2
+			if protocol == 3 {
+				want = tt.resp3
+			}
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetProtocol(protocol)
+			if err := w.WriteReply(tt.reply); err != nil {
+				t.Errorf("%s RESP%d: %v", tt.name, protocol, err)
+				continue
+			}
+			if got := buf.String(); got != want {
+				t.Errorf("%s RESP%d: wrote %q, want %q", tt.name, protocol, got, want)
+			}
+		}
+	}
+}
+
+func TestWriterAllocations(t *testing.T) {
+	value := []byte("some value")
+	tests := []struct {
+		name  string
+		write func(w *Writer) error
+	}{
+		{"simple string", func(w *Writer) error { return w.WriteSimpleString("OK") }},
+		{"error", func(w *Writer) error { return w.WriteError("ERR no such key") }},
+		{"int", func(w *Writer) error { return w.WriteInt(123456789) }},
+		{"bulk", func(w *Writer) error { return w.WriteBulk(value) }},
+		{"bulk string", func(w *Writer) error { return w.WriteBulkString("some value") }},
+		{"null", func(w *Writer) error { return w.WriteNull() }},
+		{"array header", func(w *Writer) error { return w.WriteArrayLen(3) }},
+		{"double", func(w *Writer) error { return w.WriteDouble(3.14) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter(io.Discard)
+			// The first value grows the scratch buffer
+			tt.write(w)
+			if allocs := testing.AllocsPerRun(100, func() { tt.write(w) }); allocs != 0 {
+				t.Errorf("%v allocations per value, want 0", allocs)
+			}
+		})
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestWriterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+	}{
+		{"simple string", SimpleString("OK")},
+		{"bulk string", BulkString("value")},
+		{"array", Array{Int(1), Int(2)}},
+		{"large bulk string", BulkString(make([]byte, 2*maxScratchLen))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewWriter(failingWriter{}).WriteReply(tt.reply); err != io.ErrClosedPipe {
+				t.Errorf("WriteReply returned %v, want %v", err, io.ErrClosedPipe)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteReply(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		reply Reply
+	}{
+		{"simple string", SimpleString("OK")},
+		{"int", Int(123456789)},
+		{"bulk string", BulkString("some value")},
+		{"array", Array{BulkString("a"), BulkString("b"), BulkString("c")}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			w := NewWriter(io.Discard)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.WriteReply(bm.reply)
+			}
+		})
+	}
+}
+
+// recordingWriter records the size of every write.
+type recordingWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWriteLargeBulk(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"scratch size", maxScratchLen},
+		{"one byte larger", maxScratchLen + 1},
+		{"several chunks", 3*maxScratchLen + 5},
+		{"exact chunks", 4 * maxScratchLen},
+	}
+	for _, tt := range tests {
+		value := bytes.Repeat([]byte("0123456789"), tt.size/10+1)[:tt.size]
+		want := "$" + strconv.Itoa(tt.size) + "\r\n" + string(value) + "\r\n"
+		writes := map[string]func(w *Writer) error{
+			"WriteBulk":       func(w *Writer) error { return w.WriteBulk(value) },
+			"WriteBulkString": func(w *Writer) error { return w.WriteBulkString(string(value)) },
+		}
+		for method, write := range writes {
+			t.Run(tt.name+" "+method, func(t *testing.T) {
+				var out recordingWriter
+				w := NewWriter(&out)
+				if err := write(w); err != nil {
+					t.Fatal(err)
+				}
+				if got := out.String(); got != want {
+					t.Fatalf("wrote %d bytes, want %d bytes", len(got), len(want))
+				}
+				// The payload is written in chunks, never copied to the scratch buffer
+				for i, n := range out.writes {
+					if n > maxScratchLen+len(want)-tt.size {
+						t.Errorf("write %d is %d bytes, larger than a chunk", i, n)
+					}
+				}
+				if cap(w.scratch) > maxScratchLen {
+					t.Errorf("scratch buffer of %d bytes kept", cap(w.scratch))
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkWriteLargeBulk(b *testing.B) {
+	for _, size := range []int{1 << 20, 16 << 20} {
+		value := bytes.Repeat([]byte("x"), size)
+		b.Run(strconv.Itoa(size>>20)+"MB", func(b *testing.B) {
+			w := NewWriter(io.Discard)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.WriteBulk(value)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"net"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// respClient sends commands to a server and reads its replies, it's used by the
+// -healthcheck and -cli modes.
+type respClient struct {
+	net.Conn
+	buf *bufio.Writer
+	w   *resp.Writer
+	r   *resp.Reader
+}
+
+func newRESPClient(conn net.Conn) *respClient {
+	buf := bufio.NewWriter(conn)
+	return &respClient{
+		Conn: conn,
+		buf:  buf,
+		w:    resp.NewWriter(buf),
+		r:    resp.NewReader(conn),
+	}
+}
+
+// do sends a command and returns its reply.
+func (c *respClient) do(args ...string) (resp.Reply, error) {
+	c.w.WriteArrayLen(len(args))
+	for _, arg := range args {
+		c.w.WriteBulkString(arg)
+	}
+	if err := c.buf.Flush(); err != nil {
+		return nil, err
+	}
+	return c.r.ReadReply()
+}
+
+// read reads the next reply, for the messages pushed by the server.
+func (c *respClient) read() (resp.Reply, error) {
+	return c.r.ReadReply()
+}
+
+func (c *respClient) Close() error {
+	c.r.Release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultAuditLogMaxArgLen is the length at which the arguments are truncated in
+	// the audit log.
+	DefaultAuditLogMaxArgLen = 64
+	// auditLogQueueLen is the number of entries waiting to be written, once the
+	// queue is full entries are dropped rather than slowing down the commands.
+	auditLogQueueLen = 4096
+)
+
+// auditLog appends a line to a file for every write command executed. Entries are
+// queued by the connections and written by a goroutine of its own, so the commands
+// never wait for the disk.
+type auditLog struct {
+	path      string
+	maxArgLen int
+	maxSize   int64
+	logger    *Logger
+
+	// mu guards closed, entries can't be queued once the channel is closed
+	mu      sync.RWMutex
+	closed  bool
+	entries chan []byte
+	reopen  chan struct{}
+	done    chan struct{}
+	dropped int64
+
+	file *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func newAuditLog(path string, maxArgLen int, maxSize int64, logger *Logger) (*auditLog, error) {
+	if maxArgLen <= 0 {
+		maxArgLen = DefaultAuditLogMaxArgLen
+	}
+	a := &auditLog{
+		path:      path,
+		maxArgLen: maxArgLen,
+		maxSize:   maxSize,
+		logger:    logger,
+		entries:   make(chan []byte, auditLogQueueLen),
+		reopen:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	go a.run()
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	a.file = f
+	a.w = bufio.NewWriter(f)
+	a.size = info.Size()
+	return nil
+}
+
+// record queues the entry of a command, it's dropped if the queue is full.
+func (a *auditLog) record(c *Client, db int, command string, args [][]byte) {
+	entry := make([]byte, 0, 128)
+	entry = time.Now().UTC().AppendFormat(entry, "2006-01-02T15:04:05.000Z")
+	entry = append(entry, " id="...)
+	entry = strconv.AppendUint(entry, c.id, 10)
+	entry = append(entry, " addr="...)
+	entry = append(entry, c.conn.RemoteAddr().String()...)
+	entry = append(entry, " user=default db="...)
+	entry = strconv.AppendInt(entry, int64(db), 10)
+	entry = append(entry, ' ')
+	entry = append(entry, command...)
+	for _, arg := range args {
+		entry = append(entry, ' ')
+		if len(arg) > a.maxArgLen {
+			entry = strconv.AppendQuote(entry, string(arg[:a.maxArgLen]))
+			entry = append(entry, "..."...)
+		} else {
+			entry = strconv.AppendQuote(entry, string(arg))
+		}
+	}
+	entry = append(entry, '\n')
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return
+	}
+	select {
+	case a.entries <- entry:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+// Reopen closes and reopens the file, after it has been moved by an external tool
+// such as logrotate.
+func (a *auditLog) Reopen() {
+	select {
+	case a.reopen <- struct{}{}:
+	default:
+	}
+}
+
+// Close writes the queued entries and closes the file.
+func (a *auditLog) Close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.entries)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+	defer a.file.Close()
+
+	for {
+		select {
+		case entry, ok := <-a.entries:
+			if !ok {
+				a.flush()
+				return
+			}
+			a.write(entry)
+			// Entries are flushed as soon as there are no more waiting, so the file
+			// doesn't lag behind when the server is idle.
+			if len(a.entries) == 0 {
+				a.flush()
+			}
+		case <-a.reopen:
+			a.rotate(false)
+		}
+	}
+}
+
+func (a *auditLog) write(entry []byte) {
+	if a.maxSize > 0 && a.size+int64(len(entry)) > a.maxSize && a.size > 0 {
+		a.rotate(true)
+	}
+	n, err := a.w.Write(entry)
+	a.size += int64(n)
+	if err != nil {
+		a.logger.Warningf("Error writing to the audit log: %v", err)
+		a.w.Reset(a.file)
+	}
+}
+
+func (a *auditLog) flush() {
+	if err := a.w.Flush(); err != nil {
+		a.logger.Warningf("Error writing to the audit log: %v", err)
+		a.w.Reset(a.file)
+	}
+}
+
+// rotate reopens the file. When the size threshold is exceeded the current file is
+// first renamed with the time of the rotation as suffix. If the file can't be
+// rotated the current one is kept, with its name, and the size threshold is ignored
+// from then on rather than retried for every entry.
+func (a *auditLog) rotate(rename bool) {
+	a.flush()
+	old := a.file
+	rotated := ""
+	if rename {
+		// Rotating twice in the same millisecond must not replace the first file
+		name := a.path + "." + time.Now().UTC().Format("20060102T150405.000")
+		rotated = name
+		for i := 1; ; i++ {
+			if _, err := os.Lstat(rotated); err != nil {
+				break
+			}
+			rotated = name + "-" + strconv.Itoa(i)
+		}
+		if err := os.Rename(a.path, rotated); err != nil {
+			a.logger.Warningf("Error rotating the audit log, no longer rotating it: %v", err)
+			a.maxSize = 0
+			return
+		}
+	}
+	if err := a.open(); err != nil {
+		a.logger.Warningf("%v", err)
+		if rotated != "" {
+			a.logger.Warningf("No longer rotating the audit log")
+			a.maxSize = 0
+			if err := os.Rename(rotated, a.path); err != nil {
+				a.logger.Warningf("Error restoring the audit log: %v", err)
+			}
+		}
+		return
+	}
+	old.Close()
+	a.logger.Noticef("Audit log reopened")
+}
+
+// ReopenAuditLog reopens the audit log file, it's meant to be called after the file
+// has been rotated by an external tool. It does nothing if the audit log is disabled.
+func (s *Server) ReopenAuditLog() {
+	if s.audit != nil {
+		s.audit.Reopen()
+	}
+}
+
+// auditDropped returns the number of entries that weren't written because the queue
+// was full.
+func (s *Server) auditDropped() int64 {
+	if s.audit == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.audit.dropped)
+}
+
+func (s *Server) openAuditLog() error {
+	if s.opts.AuditLogFile == "" {
+		return nil
+	}
+	a, err := newAuditLog(s.opts.AuditLogFile, s.opts.AuditLogMaxArgLen, s.opts.AuditLogMaxSize, s.logger)
+	if err != nil {
+		return err
+	}
+	s.audit = a
+	return nil
+}
+
+func (s *Server) closeAuditLog() {
+	if s.audit != nil {
+		s.audit.Close()
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newAuditTestServer serves a server writing its audit log as configured by opts,
+// the audit log being opened by ListenAndServe otherwise.
+func newAuditTestServer(t *testing.T, opts Options) (*Server, string) {
+	t.Helper()
+	opts.Network = "tcp"
+	opts.Databases = 16
+	opts.Logger = NewLogger(io.Discard, LogWarning)
+	s := New(opts)
+	if err := s.openAuditLog(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { shutdownTest(s) })
+	return s, ln.Addr().String()
+}
+
+func shutdownTest(s *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	s.Shutdown(ctx)
+}
+
+// auditEntries returns the lines of the audit log at path, without their timestamp.
+func auditEntries(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		_, entry, _ := strings.Cut(line, " ")
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, addr := newAuditTestServer(t, Options{AuditLogFile: path, AuditLogMaxArgLen: 8})
+	c := dialTest(t, addr)
+	prefix := "id=" + clientID(c) + " addr=" + c.conn.LocalAddr().String() + " user=default "
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "key", "value"}, "db=0 set \"key\" \"value\""},
+		{[]string{"GET", "key"}, ""},
+		{[]string{"EXISTS", "key"}, ""},
+		{[]string{"set", "key", "a much longer value"}, "db=0 set \"key\" \"a much l\"..."},
+		{[]string{"SET", "binary", "\x00\r\n"}, "db=0 set \"binary\" \"\\x00\\r\\n\""},
+		{[]string{"SELECT", "3"}, ""},
+		{[]string{"INCR", "n"}, "db=3 incr \"n\""},
+		{[]string{"DEL", "a", "b"}, "db=3 del \"a\" \"b\""},
+		// The commands refused by the arity check aren't executed
+		{[]string{"INCR", "missing", "extra"}, ""},
+	}
+	want := []string{}
+	for _, tt := range tests {
+		c.do(tt.args...)
+		if tt.want != "" {
+			want = append(want, prefix+tt.want)
+		}
+	}
+	shutdownTest(s)
+
+	got := auditEntries(t, path)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audit log:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	s, addr := newAuditTestServer(t, Options{AuditLogFile: path, AuditLogMaxSize: 256})
+	c := dialTest(t, addr)
+	for i := 0; i < 20; i++ {
+		c.expect(okReply, "SET", "key", strings.Repeat("v", 32))
+	}
+	shutdownTest(s)
+
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("audit log not rotated: %q", files)
+	}
+	entries := 0
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 256 {
+			t.Errorf("%s has %d bytes, more than the maximum size", file, info.Size())
+		}
+		entries += len(auditEntries(t, file))
+	}
+	if entries != 20 {
+		t.Errorf("%d entries in the rotated files, want 20", entries)
+	}
+}
+
+func TestAuditLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	s, addr := newAuditTestServer(t, Options{AuditLogFile: path})
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "before", "1")
+	waitFor(t, "the first entry", func() bool {
+		data, _ := os.ReadFile(path)
+		return len(data) > 0
+	})
+
+	// As logrotate does, the file is moved before telling the server to reopen it
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	s.ReopenAuditLog()
+	waitFor(t, "the audit log to be reopened", func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+	c.expect(okReply, "SET", "after", "2")
+	shutdownTest(s)
+
+	for file, want := range map[string]string{path + ".1": "before", path: "after"} {
+		entries := auditEntries(t, file)
+		if len(entries) != 1 || !strings.Contains(entries[0], " set \""+want+"\" ") {
+			t.Errorf("%s contains %q, want the SET of %s", file, entries, want)
+		}
+	}
+}
+
+func TestAuditLogDropsEntries(t *testing.T) {
+	s := New(Options{Databases: 1, Logger: NewLogger(io.Discard, LogWarning)})
+	// Without its goroutine nothing takes the entries out of the queue
+	s.audit = &auditLog{maxArgLen: DefaultAuditLogMaxArgLen, entries: make(chan []byte, 2)}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newClient(1, conn, s.databases[0], s.clock.Now())
+	for i := 0; i < 5; i++ {
+		s.audit.record(c, 0, "set", [][]byte{[]byte("key"), []byte("value")})
+	}
+	if got := s.auditDropped(); got != 3 {
+		t.Errorf("%d entries dropped, want 3", got)
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// Client holds the state of a single connection, it is created when the connection
+// is accepted and passed to every command handler.
+type Client struct {
+	id        uint64
+	conn      *ReplyWriter
+	createdAt time.Time
+	// db is the logical database selected with SELECT
+	db *Database
+	// ip is the address the connection is counted for by the per IP limits, empty
+	// if it's exempt
+	ip string
+	// reader parses the commands sent by the client, it's nil for the clients
+	// created by the server itself
+	reader *resp.Reader
+	// closeAfterReply is set by commands such as QUIT to close the connection once
+	// the pending replies have been sent, it's the reason logged for closing it
+	closeAfterReply string
+	// shardChannels are the shard channels the client is subscribed to, they're
+	// only modified by the goroutine of the client while holding the pubsub lock
+	shardChannels map[string]struct{}
+	// tracking are the options of client side caching, nil if tracking is off
+	tracking *trackingOptions
+	// caching applies CLIENT CACHING to the current command, nextCaching is
+	// moved to caching when the next command starts
+	caching     int
+	nextCaching int
+	// command is the command being executed, as passed to the middleware
+	command Command
+
+	// mu protects the fields below, which are also read by other connections, for
+	// example by CLIENT LIST, or written by Shutdown.
+	mu              sync.Mutex
+	lastInteraction time.Time
+	lastCmd         string
+	closing         bool
+	// idleSince is when the client started waiting for a command, zero while it's
+	// executing one
+	idleSince time.Time
+	// killReason is set when the server closes the connection, e.g. for CLIENT KILL
+	killReason string
+
+	// softLimitSince is when the queue of pushed messages went above the soft
+	// limit, it's only accessed by checkPushed, with the push lock held
+	softLimitSince time.Time
+}
+
+func newClient(id uint64, conn net.Conn, db *Database, now time.Time) *Client {
+	return &Client{
+		id:              id,
+		conn:            newReplyWriter(conn),
+		createdAt:       now,
+		db:              db,
+		lastInteraction: now,
+		lastCmd:         "NULL",
+	}
+}
+
+// ID returns the unique identifier of the client, as reported by CLIENT LIST.
+func (c *Client) ID() uint64 {
+	return c.id
+}
+
+// DB returns the logical database selected by the client.
+func (c *Client) DB() *Database {
+	return c.db
+}
+
+// setCommand records the last command received by the client, at now.
+func (c *Client) setCommand(command string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastInteraction = now
+	c.idleSince = time.Time{}
+	c.lastCmd = command
+}
+
+// waitCommand records that the client is waiting for its next command since now, so
+// that clientsCron closes it if it stays idle for longer than the timeout. It clears
+// the deadline set by closeIfIdle if the client got a command meanwhile.
+func (c *Client) waitCommand(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing {
+		return
+	}
+	c.idleSince = now
+	c.conn.SetReadDeadline(time.Time{})
+}
+
+// closeIfIdle interrupts the read of the next command if the client has been waiting
+// for it for longer than timeout, closing the connection as timed out.
+func (c *Client) closeIfIdle(now time.Time, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing || c.idleSince.IsZero() || now.Sub(c.idleSince) <= timeout {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// interrupt stops the client from reading new commands. Commands already buffered
+// by the reader are still executed, the deadline only interrupts reads waiting for
+// more data from the client.
+func (c *Client) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closing = true
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// kill closes the connection of the client, interrupting any read in progress.
+// reason is logged as the reason for closing the connection.
+func (c *Client) kill(reason string) {
+	c.mu.Lock()
+	c.killReason = reason
+	c.mu.Unlock()
+
+	c.conn.Close()
+}
+
+// closeReason describes why the connection was closed after err was returned while
+// reading from or writing to it.
+func (c *Client) closeReason(err error) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var netErr net.Error
+	switch {
+	case c.killReason != "":
+		return c.killReason
+	case errors.Is(err, io.EOF):
+		return "EOF"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		if c.closing {
+			return "shutdown"
+		}
+		return "timeout"
+	case err != nil:
+		return "error: " + err.Error()
+	}
+	return "EOF"
+}
+
+// info describes the client in the format used by CLIENT LIST.
+func (c *Client) info(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return fmt.Sprintf(
+		"id=%d addr=%s laddr=%s age=%d idle=%d cmd=%s",
+		c.id,
+		c.conn.RemoteAddr(),
+		c.conn.LocalAddr(),
+		int(now.Sub(c.createdAt).Seconds()),
+		int(now.Sub(c.lastInteraction).Seconds()),
+		c.lastCmd,
+	)
+}
+
+// client is a container command for the subcommands that inspect and manage the
+// connections.
+// `CLIENT LIST` returns information and statistics about the client connections,
+// one per line. https://redis.io/commands/client-list/
+// `CLIENT KILL` closes the connections matching the filters, either a single
+// `ip:port` address or one or more of `ID id`, `ADDR ip:port` and `LADDR ip:port`.
+// https://redis.io/commands/client-kill/
+// `CLIENT REPLY ON|OFF|SKIP` turns the replies to the commands of the connection on
+// or off, or skips the reply to the next command. https://redis.io/commands/client-reply/
+// `CLIENT TRACKING` and `CLIENT CACHING` enable client side caching, see tracking.go.
+// `CLIENT UNBLOCK id [TIMEOUT|ERROR]` wakes a client blocked in a command. No
+// command blocks yet, so it's accepted for compatibility and always replies 0.
+// https://redis.io/commands/client-unblock/
+// `CLIENT TRACE ON|OFF` logs the raw commands and replies of the connection at debug
+// level, see prototrace.go.
+func (s *Server) client(c *Client, args [][]byte) Reply {
+	subcommand := strings.ToLower(string(args[0]))
+	switch subcommand {
+	case "list":
+		if len(args) != 1 {
+			return wrongNumArgsReply("client|list")
+		}
+		return resp.BulkString(s.clientList())
+	case "kill":
+		return s.clientKill(c, args[1:])
+	case "tracking":
+		return s.clientTracking(c, args[1:])
+	case "caching":
+		return s.clientCaching(c, args[1:])
+	case "reply":
+		if len(args) != 2 {
+			return wrongNumArgsReply("client|reply")
+		}
+		switch strings.ToLower(string(args[1])) {
+		case "on":
+			c.conn.setReplyMode(replyOn)
+			return okReply
+		case "off":
+			c.conn.setReplyMode(replyOff)
+			return nil
+		case "skip":
+			c.conn.setReplyMode(replySkip)
+			return nil
+		}
+		return resp.Error("ERR syntax error")
+	case "unblock":
+		return s.clientUnblock(args[1:])
+	case "trace":
+		if len(args) != 2 {
+			return wrongNumArgsReply("client|trace")
+		}
+		switch strings.ToLower(string(args[1])) {
+		case "on":
+			s.setProtoTrace(c, true)
+			return okReply
+		case "off":
+			s.setProtoTrace(c, false)
+			return okReply
+		}
+		return resp.Error("ERR syntax error")
+	}
+	return errorf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[0])
+}
+
+func (s *Server) clientList() string {
+	s.mu.Lock()
+	clients := make([]*Client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].id < clients[j].id
+	})
+
+	now := s.clock.Now()
+	var b strings.Builder
+	for _, c := range clients {
+		b.WriteString(c.info(now))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (s *Server) clientKill(c *Client, args [][]byte) Reply {
+	if len(args) == 0 {
+		return wrongNumArgsReply("client|kill")
+	}
+
+	// The old form only accepts an address and replies with OK
+	if len(args) == 1 {
+		killed := s.killClients(func(target *Client) bool {
+			return target.conn.RemoteAddr().String() == string(args[0])
+		})
+		if killed == 0 {
+			return resp.Error("ERR No such client")
+		}
+		return okReply
+	}
+
+	if len(args)%2 != 0 {
+		return resp.Error("ERR syntax error")
+	}
+	filters := []func(target *Client) bool{}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return resp.Error("ERR client-id should be greater than 0")
+			}
+			filters = append(filters, func(target *Client) bool { return target.id == id })
+		case "addr":
+			filters = append(filters, func(target *Client) bool { return target.conn.RemoteAddr().String() == value })
+		case "laddr":
+			filters = append(filters, func(target *Client) bool { return target.conn.LocalAddr().String() == value })
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+	killed := s.killClients(func(target *Client) bool {
+		for _, filter := range filters {
+			if !filter(target) {
+				return false
+			}
+		}
+		return true
+	})
+	return resp.Int(killed)
+}
+
+func (s *Server) clientUnblock(args [][]byte) Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return wrongNumArgsReply("client|unblock")
+	}
+	if _, err := strconv.ParseUint(string(args[0]), 10, 64); err != nil {
+		return valueIsNotIntReply
+	}
+	if len(args) == 2 {
+		switch strings.ToLower(string(args[1])) {
+		case "timeout", "error":
+		default:
+			return resp.Error("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+		}
+	}
+	// There's no blocked client to wake up
+	return resp.Int(0)
+}
+
+// killClients closes the connections of the clients matching filter, returning how
+// many were closed.
+func (s *Server) killClients(filter func(c *Client) bool) int {
+	s.mu.Lock()
+	targets := []*Client{}
+	for c := range s.clients {
+		if filter(c) {
+			targets = append(targets, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		c.kill("killed")
+	}
+	return len(targets)
+}
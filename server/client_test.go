@@ -0,0 +1,169 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestClientReleasedOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		disconnect func(c *testClient)
+	}{
+		{"QUIT", func(c *testClient) {
+			c.expect(okReply, "QUIT")
+			c.expectClosed()
+		}},
+		{"closed by the client", func(c *testClient) {
+			c.conn.Close()
+		}},
+		{"protocol error", func(c *testClient) {
+			c.conn.Write([]byte("*1\r\n$x\r\n"))
+			c.expectClosed()
+		}},
+		{"CLIENT KILL", func(c *testClient) {
+			other := dialTest(c.t, c.conn.RemoteAddr().String())
+			other.expect(resp.Int(1), "CLIENT", "KILL", "ADDR", c.conn.LocalAddr().String())
+			other.conn.Close()
+			c.expectClosed()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			c.expect(okReply, "SELECT", "3")
+			tt.disconnect(c)
+			waitFor(t, "the client to be released", func() bool {
+				return s.connectedClients() == 0
+			})
+		})
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	pong := resp.SimpleString("PONG")
+	tests := []struct {
+		name     string
+		commands [][]string
+		// want are the replies received, PING being sent after the commands
+		want []resp.Reply
+	}{
+		{
+			"off",
+			[][]string{{"CLIENT", "REPLY", "OFF"}, {"SET", "a", "1"}, {"GET", "a"}},
+			nil,
+		},
+		{
+			"off then on",
+			[][]string{{"CLIENT", "REPLY", "OFF"}, {"SET", "a", "1"}, {"CLIENT", "REPLY", "ON"}, {"GET", "a"}},
+			[]resp.Reply{okReply, resp.BulkString("1"), pong},
+		},
+		{
+			"errors are suppressed too",
+			[][]string{{"CLIENT", "REPLY", "OFF"}, {"NOSUCHCOMMAND"}, {"INCR"}, {"CLIENT", "REPLY", "ON"}},
+			[]resp.Reply{okReply, pong},
+		},
+		{
+			"skip",
+			[][]string{{"CLIENT", "REPLY", "SKIP"}, {"SET", "a", "1"}, {"GET", "a"}},
+			[]resp.Reply{resp.BulkString("1"), pong},
+		},
+		{
+			"skip twice",
+			[][]string{{"CLIENT", "REPLY", "SKIP"}, {"CLIENT", "REPLY", "SKIP"}, {"SET", "a", "1"}, {"GET", "a"}},
+			[]resp.Reply{resp.BulkString("1"), pong},
+		},
+		{
+			"on cancels skip",
+			[][]string{{"CLIENT", "REPLY", "SKIP"}, {"CLIENT", "REPLY", "ON"}, {"SET", "a", "1"}},
+			[]resp.Reply{okReply, okReply, pong},
+		},
+		{
+			"skip then off",
+			[][]string{{"CLIENT", "REPLY", "SKIP"}, {"CLIENT", "REPLY", "OFF"}, {"SET", "a", "1"}, {"CLIENT", "REPLY", "ON"}},
+			[]resp.Reply{okReply, pong},
+		},
+		{
+			"invalid mode",
+			[][]string{{"CLIENT", "REPLY", "MAYBE"}, {"CLIENT", "REPLY"}},
+			[]resp.Reply{
+				resp.Error("ERR syntax error"),
+				resp.Error("ERR wrong number of arguments for 'client|reply' command"),
+				pong,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			for _, args := range tt.commands {
+				c.send(args...)
+			}
+			c.send("PING")
+			if len(tt.want) == 0 {
+				// Nothing comes back, not even the reply to PING
+				c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				if reply, err := c.r.ReadReply(); err == nil {
+					t.Errorf("got %#v with replies off", reply)
+				}
+				return
+			}
+			for i, want := range tt.want {
+				if got := c.read(); !reflect.DeepEqual(got, want) {
+					t.Errorf("reply %d = %#v, want %#v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientReplyOffStillPushes(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	sub := dialTest(t, addr)
+	sub.send("CLIENT", "REPLY", "OFF")
+	sub.send("SSUBSCRIBE", "channel")
+
+	pub := dialTest(t, addr)
+	waitFor(t, "the subscription", func() bool {
+		return pub.do("PUBSUB", "SHARDNUMSUB", "channel").(resp.Array)[1] == resp.Reply(resp.Int(1))
+	})
+	pub.expect(resp.Int(1), "SPUBLISH", "channel", "hello")
+
+	want := resp.Array{resp.BulkString("smessage"), resp.BulkString("channel"), resp.BulkString("hello")}
+	if got := sub.read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %#v, want %#v", got, want)
+	}
+}
+
+func TestClientUnblock(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	other := dialTest(t, addr)
+	id := clientID(other)
+	tests := []struct {
+		args []string
+		want resp.Reply
+	}{
+		// No command blocks, so there's never a client to unblock
+		{[]string{id}, resp.Int(0)},
+		{[]string{id, "TIMEOUT"}, resp.Int(0)},
+		{[]string{id, "error"}, resp.Int(0)},
+		{[]string{clientID(c)}, resp.Int(0)},
+		{[]string{"12345"}, resp.Int(0)},
+		{[]string{}, wrongNumArgsReply("client|unblock")},
+		{[]string{id, "TIMEOUT", "extra"}, wrongNumArgsReply("client|unblock")},
+		{[]string{"abc"}, valueIsNotIntReply},
+		{[]string{"-1"}, valueIsNotIntReply},
+		{[]string{id, "later"}, resp.Error("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, append([]string{"CLIENT", "UNBLOCK"}, tt.args...)...)
+	}
+	// The other client isn't affected
+	other.expect(resp.SimpleString("PONG"), "PING")
+}
@@ -0,0 +1,63 @@
+package server
+
+import "time"
+
+// Clock tells the time to the server: the idle timeout of the clients, their age and
+// idle time in CLIENT LIST, the output buffer limits and the duration of the commands
+// are all measured with it. The real clock is used by default, Options.Clock replaces
+// it, for example with one that a test moves forward instantly instead of sleeping.
+// Network deadlines, which only interrupt reads right away, keep the real time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel receiving the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clientsCronInterval is how often the idle clients are closed, as the 10 Hz of the
+// serverCron of Redis.
+const clientsCronInterval = 100 * time.Millisecond
+
+// clientsCron closes the clients idle for longer than the timeout, except the
+// subscribers, and prunes the buckets of accept-rate-per-ip, until the server shuts
+// down. It's started by the first call of Serve.
+func (s *Server) clientsCron() {
+	for {
+		select {
+		case <-s.clock.After(clientsCronInterval):
+		case <-s.done:
+			return
+		}
+		now := s.clock.Now()
+		s.pruneBuckets(now)
+		timeout := s.idleTimeout()
+		if timeout == 0 {
+			continue
+		}
+		s.mu.Lock()
+		clients := make([]*Client, 0, len(s.clients))
+		for c := range s.clients {
+			clients = append(clients, c)
+		}
+		s.mu.Unlock()
+
+		for _, c := range clients {
+			// As in Redis, the subscribers wait for messages and are never idle
+			if s.pubsub.subscribed(c) {
+				continue
+			}
+			c.closeIfIdle(now, timeout)
+		}
+	}
+}
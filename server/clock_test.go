@@ -0,0 +1,180 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// fakeClock is a Clock that only moves forward when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// advance moves the clock forward by d, firing the channels of After that are due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// waiting returns the number of channels of After that haven't fired yet.
+func (c *fakeClock) waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// advanceCron moves clock forward by d, at least clientsCronInterval, and waits for
+// clientsCron to handle the new time, which it does once it waits for the next tick
+// again.
+func advanceCron(t *testing.T, clock *fakeClock, d time.Duration) {
+	t.Helper()
+	waitFor(t, "clientsCron to wait for a tick", func() bool { return clock.waiting() == 1 })
+	clock.advance(d)
+	waitFor(t, "clientsCron to handle the tick", func() bool { return clock.waiting() == 1 })
+}
+
+func TestIdleTimeout(t *testing.T) {
+	type step struct {
+		elapsed time.Duration
+		// command is sent by the client after the time elapsed, if any
+		command   bool
+		wantAlive bool
+	}
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		steps   []step
+	}{
+		{
+			"idle for longer than the timeout",
+			time.Minute,
+			[]step{{59 * time.Second, false, true}, {2 * time.Second, false, false}},
+		},
+		{
+			"idle for exactly the timeout",
+			time.Minute,
+			[]step{{time.Minute, false, true}, {clientsCronInterval, false, false}},
+		},
+		{
+			"commands reset the idle time",
+			time.Minute,
+			[]step{{50 * time.Second, true, true}, {50 * time.Second, true, true}, {50 * time.Second, false, true}},
+		},
+		{
+			"no timeout",
+			0,
+			[]step{{24 * time.Hour, false, true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			s, addr := newTestServer(t, Options{Timeout: tt.timeout, Clock: clock})
+			c := dialTest(t, addr)
+			c.expect(resp.SimpleString("PONG"), "PING")
+			for i, step := range tt.steps {
+				advanceCron(t, clock, step.elapsed)
+				if !step.wantAlive {
+					waitFor(t, "the idle client to be closed", func() bool { return s.connectedClients() == 0 })
+				} else if s.connectedClients() != 1 {
+					t.Fatalf("step %d: client closed after %v", i, step.elapsed)
+				}
+				if step.command {
+					c.expect(resp.SimpleString("PONG"), "PING")
+				}
+			}
+			if !tt.steps[len(tt.steps)-1].wantAlive {
+				c.expectClosed()
+			}
+		})
+	}
+}
+
+func TestClientListWithClock(t *testing.T) {
+	clock := newFakeClock()
+	_, addr := newTestServer(t, Options{Clock: clock})
+	idle := dialTest(t, addr)
+	idle.expect(resp.SimpleString("PONG"), "PING")
+	id := clientID(idle)
+
+	clock.advance(90 * time.Second)
+	c := dialTest(t, addr)
+	clock.advance(30 * time.Second)
+	list := string(c.do("CLIENT", "LIST").(resp.BulkString))
+	for _, line := range strings.Split(list, "\n") {
+		if !strings.HasPrefix(line, "id="+id+" ") {
+			continue
+		}
+		for _, want := range []string{" age=120 ", " idle=120 ", " cmd=client"} {
+			if !strings.Contains(line, want) {
+				t.Errorf("CLIENT LIST of the idle client without %q:\n%s", want, line)
+			}
+		}
+		return
+	}
+	t.Fatalf("client %s not in CLIENT LIST:\n%s", id, list)
+}
+
+func TestIdleTimeoutExemptsSubscribers(t *testing.T) {
+	clock := newFakeClock()
+	s, addr := newTestServer(t, Options{Timeout: time.Minute, Clock: clock})
+	sub := dialTest(t, addr)
+	sub.expect(resp.Array{resp.BulkString("ssubscribe"), resp.BulkString("channel"), resp.Int(1)}, "SSUBSCRIBE", "channel")
+
+	advanceCron(t, clock, 2*time.Minute)
+	if s.connectedClients() != 1 {
+		t.Fatal("subscriber closed by the idle timeout")
+	}
+	pub := dialTest(t, addr)
+	pub.expect(resp.Int(1), "SPUBLISH", "channel", "hello")
+	want := resp.Array{resp.BulkString("smessage"), resp.BulkString("channel"), resp.BulkString("hello")}
+	if got := sub.read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %#v, want %#v", got, want)
+	}
+
+	// Once it unsubscribes, the client is idle since its last command
+	sub.expect(resp.Array{resp.BulkString("sunsubscribe"), resp.BulkString("channel"), resp.Int(0)}, "SUNSUBSCRIBE", "channel")
+	pub.conn.Close()
+	advanceCron(t, clock, 2*time.Minute)
+	sub.expectClosed()
+}
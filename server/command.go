@@ -0,0 +1,390 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// Reply is what command handlers reply with, any of the types defined in package resp.
+type Reply = resp.Reply
+
+type commandHandler = func(c *Client, args [][]byte) Reply
+
+// ErrUnknownCommand is returned by ExecuteCommand and RenameCommand when there's no
+// command with the given name.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// ErrCommandExists is returned by RegisterCommand when a command with the same name
+// is already registered.
+var ErrCommandExists = errors.New("command already registered")
+
+// CommandFlags describe how a command behaves, they are reported by COMMAND.
+type CommandFlags uint32
+
+const (
+	// FlagWrite marks commands that may modify the dataset.
+	FlagWrite CommandFlags = 1 << iota
+	// FlagReadOnly marks commands that only read the dataset.
+	FlagReadOnly
+	// FlagDenyOOM marks commands that may use more memory.
+	FlagDenyOOM
+	// FlagAdmin marks administrative commands, such as CONFIG.
+	FlagAdmin
+	// FlagNoScript marks commands that can't be called from scripts.
+	FlagNoScript
+	// FlagLoading marks commands allowed while the dataset is being loaded.
+	FlagLoading
+	// FlagStale marks commands allowed while a replica has stale data.
+	FlagStale
+	// FlagFast marks commands that run in constant or logarithmic time.
+	FlagFast
+	// FlagNoAuth marks commands allowed before the client authenticates.
+	FlagNoAuth
+	// FlagPubSub marks the commands related to pub/sub.
+	FlagPubSub
+)
+
+var commandFlagNames = []struct {
+	flag CommandFlags
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadOnly, "readonly"},
+	{FlagDenyOOM, "denyoom"},
+	{FlagAdmin, "admin"},
+	{FlagNoScript, "noscript"},
+	{FlagLoading, "loading"},
+	{FlagStale, "stale"},
+	{FlagFast, "fast"},
+	{FlagNoAuth, "no_auth"},
+	{FlagPubSub, "pubsub"},
+}
+
+// names returns the names of the flags as reported by COMMAND.
+func (f CommandFlags) names() []string {
+	names := []string{}
+	for _, fn := range commandFlagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// command is an entry of the command table.
+// The arity follows the Redis convention and counts the command name too: a positive
+// arity is the exact number of arguments, a negative one is the minimum number.
+type command struct {
+	name    string
+	arity   int
+	flags   CommandFlags
+	handler commandHandler
+	stats   commandStats
+	// firstKey is the position of the first key in the arguments, the command name
+	// being at position 0, lastKey the position of the last one, negative to count
+	// from the end, and keyStep the distance between two keys. The command takes no
+	// keys if firstKey is 0.
+	firstKey int
+	lastKey  int
+	keyStep  int
+}
+
+func (cmd *command) checkArity(argc int) bool {
+	if cmd.arity < 0 {
+		return argc >= -cmd.arity
+	}
+	return argc == cmd.arity
+}
+
+// RegisterCommand adds a command to the server, it's dispatched to handler along
+// with its arguments, the command name excluded. Command names are case insensitive.
+// Clients calling the command with a number of arguments not matching arity receive
+// an error without the handler being called: a positive arity is the exact number of
+// arguments including the command name, a negative one the minimum number.
+// The command is listed by COMMAND as any built-in command.
+// The arguments are only valid until the handler returns, as their memory is reused
+// for the next command: handlers must copy anything they retain. Database.Write
+// already copies the value it stores.
+func (s *Server) RegisterCommand(name string, arity int, flags CommandFlags, handler func(c *Client, args [][]byte) Reply) error {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsAny(name, " \r\n") {
+		return fmt.Errorf("invalid command name %q", name)
+	}
+	if arity == 0 {
+		return fmt.Errorf("invalid arity for command %q", name)
+	}
+	return s.addCommand(&command{
+		name:    name,
+		arity:   arity,
+		flags:   flags,
+		handler: handler,
+	})
+}
+
+func (s *Server) addCommand(cmd *command) error {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+
+	if _, ok := s.commands[cmd.name]; ok {
+		return fmt.Errorf("%w: %s", ErrCommandExists, cmd.name)
+	}
+	s.commands[cmd.name] = cmd
+	return nil
+}
+
+// RenameCommand renames a command, as the rename-command directive of Redis does, or
+// disables it if newName is empty. The command is then only dispatched and listed by
+// COMMAND under its new name, clients calling it with the old one are told that the
+// command is unknown.
+func (s *Server) RenameCommand(name, newName string) error {
+	name, newName = strings.ToLower(name), strings.ToLower(newName)
+	if strings.ContainsAny(newName, " \r\n") {
+		return fmt.Errorf("invalid command name %q", newName)
+	}
+
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+
+	cmd, ok := s.commands[name]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownCommand, name)
+	}
+	if newName == "" {
+		delete(s.commands, name)
+		return nil
+	}
+	if _, ok := s.commands[newName]; ok {
+		return fmt.Errorf("%w: %s", ErrCommandExists, newName)
+	}
+	// The command is replaced rather than modified, as clients may be reading it
+	delete(s.commands, name)
+	s.commands[newName] = &command{
+		name:     newName,
+		arity:    cmd.arity,
+		flags:    cmd.flags,
+		firstKey: cmd.firstKey,
+		lastKey:  cmd.lastKey,
+		keyStep:  cmd.keyStep,
+		handler:  cmd.handler,
+	}
+	return nil
+}
+
+func (s *Server) lookupCommand(name string) (*command, bool) {
+	s.commandsMu.RLock()
+	defer s.commandsMu.RUnlock()
+
+	cmd, ok := s.commands[name]
+	return cmd, ok
+}
+
+// findCommand looks up the command named name case insensitively, as sent by the
+// client. Unlike lookupCommand it doesn't allocate for the names of any known command.
+func (s *Server) findCommand(name []byte) (*command, bool) {
+	var tmp [32]byte
+	if len(name) > len(tmp) {
+		return s.lookupCommand(strings.ToLower(string(name)))
+	}
+	lower := tmp[:len(name)]
+	for i, b := range name {
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		lower[i] = b
+	}
+
+	s.commandsMu.RLock()
+	defer s.commandsMu.RUnlock()
+
+	cmd, ok := s.commands[string(lower)]
+	return cmd, ok
+}
+
+// sortedCommands returns the command table sorted by name.
+func (s *Server) sortedCommands() []*command {
+	s.commandsMu.RLock()
+	cmds := make([]*command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		cmds = append(cmds, cmd)
+	}
+	s.commandsMu.RUnlock()
+
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].name < cmds[j].name
+	})
+	return cmds
+}
+
+// keys returns the keys among args, the arguments following the command name, as
+// described by the key positions of the command.
+func (cmd *command) keys(args [][]byte) [][]byte {
+	if cmd.firstKey == 0 || cmd.firstKey > len(args) {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+	keys := make([][]byte, 0, (last-cmd.firstKey)/cmd.keyStep+1)
+	for i := cmd.firstKey; i <= last; i += cmd.keyStep {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// info returns the description of the command reported by COMMAND: its name, arity,
+// flags and the positions of the first key, the last key and the step between keys.
+func (cmd *command) info() Reply {
+	flags := resp.Set{}
+	for _, name := range cmd.flags.names() {
+		flags = append(flags, resp.SimpleString(name))
+	}
+	return resp.Array{
+		resp.BulkString(cmd.name),
+		resp.Int(cmd.arity),
+		flags,
+		resp.Int(cmd.firstKey),
+		resp.Int(cmd.lastKey),
+		resp.Int(cmd.keyStep),
+	}
+}
+
+// commandCmd returns the details of the commands supported by the server.
+// `COMMAND` describes every command, `COMMAND INFO name [name ...]` only the given ones.
+// `COMMAND COUNT` returns the number of commands and `COMMAND LIST` their names.
+// `COMMAND DOCS [name ...]` returns the documentation of the commands. No
+// documentation is kept, so each command is described by an empty map.
+// `COMMAND GETKEYS command [arg ...]` returns the keys among the arguments of a command.
+// https://redis.io/commands/command/
+func (s *Server) commandCmd(c *Client, args [][]byte) Reply {
+	if len(args) == 0 {
+		reply := resp.Array{}
+		for _, cmd := range s.sortedCommands() {
+			reply = append(reply, cmd.info())
+		}
+		return reply
+	}
+
+	switch strings.ToLower(string(args[0])) {
+	case "count":
+		if len(args) != 1 {
+			return wrongNumArgsReply("command|count")
+		}
+		return resp.Int(len(s.sortedCommands()))
+	case "list":
+		if len(args) != 1 {
+			return wrongNumArgsReply("command|list")
+		}
+		reply := resp.Array{}
+		for _, cmd := range s.sortedCommands() {
+			reply = append(reply, resp.BulkString(cmd.name))
+		}
+		return reply
+	case "info":
+		reply := resp.Array{}
+		for _, name := range args[1:] {
+			cmd, ok := s.lookupCommand(strings.ToLower(string(name)))
+			if !ok {
+				reply = append(reply, resp.NullArray{})
+				continue
+			}
+			reply = append(reply, cmd.info())
+		}
+		return reply
+	case "getkeys":
+		if len(args) < 2 {
+			return wrongNumArgsReply("command|getkeys")
+		}
+		cmd, ok := s.findCommand(args[1])
+		if !ok {
+			return resp.Error("ERR Invalid command specified")
+		}
+		if !cmd.checkArity(len(args) - 1) {
+			return resp.Error("ERR Invalid number of arguments specified for command")
+		}
+		keys := cmd.keys(args[2:])
+		if len(keys) == 0 {
+			return resp.Error("ERR The command has no key arguments")
+		}
+		return bulkStringArray(keys)
+	case "docs":
+		cmds := s.sortedCommands()
+		if len(args) > 1 {
+			cmds = cmds[:0]
+			for _, name := range args[1:] {
+				if cmd, ok := s.lookupCommand(strings.ToLower(string(name))); ok {
+					cmds = append(cmds, cmd)
+				}
+			}
+		}
+		reply := resp.Map{}
+		for _, cmd := range cmds {
+			reply = append(reply, resp.BulkString(cmd.name), resp.Map{})
+		}
+		return reply
+	}
+	return errorf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0])
+}
+
+// ExecuteCommand runs a command in the process, without a connection: it's
+// dispatched, arity checked and executed exactly as if a client selecting the
+// database db had sent it, but the reply is returned instead of being written to a
+// socket. An error reply is returned as the error, a resp.Error. The command isn't
+// executed if ctx is already done, since no command blocks there's nothing to
+// interrupt once it started.
+func (s *Server) ExecuteCommand(ctx context.Context, db int, args ...string) (Reply, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command given")
+	}
+	if db < 0 || db >= len(s.databases) {
+		return nil, fmt.Errorf("DB index %d is out of range", db)
+	}
+	cmd, ok := s.lookupCommand(strings.ToLower(args[0]))
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownCommand, args[0])
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c, release := s.newInternalClient(s.databases[db])
+	defer release()
+
+	argv := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		argv[i] = []byte(arg)
+	}
+	reply, span := s.execute(c, cmd, argv)
+	if span != nil {
+		endCommandSpan(span, reply, 0)
+	}
+	if err, ok := reply.(resp.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// newInternalClient returns a client that isn't connected to anything, for the
+// commands executed by the server itself. The messages pushed to it by other
+// clients, for example if it enabled tracking, are discarded. release must be called
+// once the client isn't used anymore.
+func (s *Server) newInternalClient(db *Database) (c *Client, release func()) {
+	conn, peer := net.Pipe()
+	peer.Close()
+	c = newClient(0, conn, db, s.clock.Now())
+	return c, func() {
+		s.tracking.disable(c)
+		s.pubsub.unsubscribeAll(c)
+		c.conn.release()
+		conn.Close()
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestRegisterCommandErrors(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	handler := func(c *Client, args [][]byte) Reply { return okReply }
+	if err := s.RegisterCommand("custom", 1, 0, handler); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		arity   int
+		wantErr error
+	}{
+		{"custom", 1, ErrCommandExists},
+		{"CUSTOM", 2, ErrCommandExists},
+		{"get", 2, ErrCommandExists},
+		{"", 1, nil},
+		{"two words", 1, nil},
+		{"new\r\nline", 1, nil},
+		{"noarity", 0, nil},
+	}
+	for _, tt := range tests {
+		err := s.RegisterCommand(tt.name, tt.arity, 0, handler)
+		if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("RegisterCommand(%q, %d) = %v, want an error", tt.name, tt.arity, err)
+		}
+	}
+	// The existing commands are left untouched
+	c := dialTest(t, addr)
+	c.expect(resp.Null{}, "GET", "key")
+}
+
+func TestRegisteredCommand(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	var mu sync.Mutex
+	var calls [][]string
+	handler := func(c *Client, args [][]byte) Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		call := []string{}
+		for _, arg := range args {
+			call = append(call, string(arg))
+		}
+		calls = append(calls, call)
+		return resp.Int(len(args))
+	}
+	if err := s.RegisterCommand("Exact", 2, FlagReadOnly|FlagFast, handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterCommand("atleast", -2, FlagWrite, handler); err != nil {
+		t.Fatal(err)
+	}
+	var flags []CommandFlags
+	s.Use(func(next CommandHandler) CommandHandler {
+		return func(c *Client, cmd *Command) Reply {
+			mu.Lock()
+			flags = append(flags, cmd.Flags)
+			mu.Unlock()
+			return next(c, cmd)
+		}
+	})
+
+	c := dialTest(t, addr)
+	tests := []struct {
+		args []string
+		want resp.Reply
+	}{
+		{[]string{"exact", "a"}, resp.Int(1)},
+		{[]string{"EXACT", "a"}, resp.Int(1)},
+		{[]string{"exact"}, wrongNumArgsReply("exact")},
+		{[]string{"exact", "a", "b"}, wrongNumArgsReply("exact")},
+		{[]string{"atleast", "a"}, resp.Int(1)},
+		{[]string{"atleast", "a", "b", "c"}, resp.Int(3)},
+		{[]string{"atleast"}, wrongNumArgsReply("atleast")},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, tt.args...)
+	}
+	mu.Lock()
+	wantCalls := [][]string{{"a"}, {"a"}, {"a"}, {"a", "b", "c"}}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("handler called with %q, want %q", calls, wantCalls)
+	}
+	wantFlags := []CommandFlags{FlagReadOnly | FlagFast, FlagReadOnly | FlagFast, FlagWrite, FlagWrite}
+	if !reflect.DeepEqual(flags, wantFlags) {
+		t.Errorf("middleware saw the flags %v, want %v", flags, wantFlags)
+	}
+	mu.Unlock()
+
+	c.expect(resp.Array{
+		resp.Array{resp.BulkString("exact"), resp.Int(2), resp.Array{resp.SimpleString("readonly"), resp.SimpleString("fast")}, resp.Int(0), resp.Int(0), resp.Int(0)},
+		resp.Array{resp.BulkString("atleast"), resp.Int(-2), resp.Array{resp.SimpleString("write")}, resp.Int(0), resp.Int(0), resp.Int(0)},
+	}, "COMMAND", "INFO", "exact", "atleast")
+	list := c.do("COMMAND", "LIST").(resp.Array)
+	found := 0
+	for _, name := range list {
+		if reflect.DeepEqual(name, resp.BulkString("exact")) || reflect.DeepEqual(name, resp.BulkString("atleast")) {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("COMMAND LIST without the registered commands: %q", list)
+	}
+	c.expect(resp.Array{resp.BulkString("exact"), resp.Array{}}, "COMMAND", "DOCS", "exact")
+}
+
+func TestRenameCommand(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	if err := s.RenameCommand("GET", "Fetch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameCommand("flushall", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "key", "value")
+	c.expect(resp.BulkString("value"), "FETCH", "key")
+	c.expect(resp.BulkString("value"), "fetch", "key")
+	c.expect(unknownCommandReply([]byte("GET"), [][]byte{[]byte("key")}), "GET", "key")
+	c.expect(unknownCommandReply([]byte("flushall"), nil), "flushall")
+	c.expect(wrongNumArgsReply("fetch"), "FETCH")
+
+	// COMMAND only knows the new name, with the details of the old command
+	c.expect(resp.Array{
+		resp.Array{resp.BulkString("fetch"), resp.Int(2), resp.Array{resp.SimpleString("readonly"), resp.SimpleString("fast")}, resp.Int(1), resp.Int(1), resp.Int(1)},
+		resp.NullArray{},
+		resp.NullArray{},
+	}, "COMMAND", "INFO", "fetch", "get", "flushall")
+	c.expect(resp.Array{resp.BulkString("key")}, "COMMAND", "GETKEYS", "fetch", "key")
+	for _, name := range c.do("COMMAND", "LIST").(resp.Array) {
+		if reflect.DeepEqual(name, resp.BulkString("get")) || reflect.DeepEqual(name, resp.BulkString("flushall")) {
+			t.Errorf("COMMAND LIST still has %q", name)
+		}
+	}
+}
+
+func TestRenameCommandErrors(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	tests := []struct {
+		name, newName string
+		wantErr       error
+	}{
+		{"get", "set", ErrCommandExists},
+		{"get", "SET", ErrCommandExists},
+		{"nosuchcommand", "other", ErrUnknownCommand},
+		{"nosuchcommand", "", ErrUnknownCommand},
+		{"get", "two words", nil},
+		{"get", "new\nline", nil},
+	}
+	for _, tt := range tests {
+		err := s.RenameCommand(tt.name, tt.newName)
+		if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+			t.Errorf("RenameCommand(%q, %q) = %v, want an error", tt.name, tt.newName, err)
+		}
+	}
+	// The commands are left untouched
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "key", "value")
+	c.expect(resp.BulkString("value"), "GET", "key")
+
+	// A command that was disabled can't be renamed anymore
+	if err := s.RenameCommand("get", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenameCommand("get", "fetch"); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("RenameCommand of a disabled command = %v, want %v", err, ErrUnknownCommand)
+	}
+}
+
+func TestWrongNumberOfArguments(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, cmd := range s.sortedCommands() {
+		// The number of arguments, the name included, just outside of the arity
+		var argcs []int
+		if cmd.arity > 0 {
+			argcs = append(argcs, cmd.arity-1, cmd.arity+1)
+		} else {
+			argcs = append(argcs, -cmd.arity-1)
+		}
+		for _, argc := range argcs {
+			if argc < 1 {
+				continue
+			}
+			args := []string{strings.ToUpper(cmd.name)}
+			for len(args) < argc {
+				args = append(args, "key")
+			}
+			c.expect(wrongNumArgsReply(cmd.name), args...)
+		}
+	}
+	// None of the commands was executed
+	c.expect(resp.Int(0), "DBSIZE")
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	tests := []struct {
+		args []string
+		want resp.Reply
+	}{
+		{[]string{"get", "key"}, resp.Array{resp.BulkString("key")}},
+		{[]string{"SET", "key", "value"}, resp.Array{resp.BulkString("key")}},
+		{[]string{"move", "key", "1"}, resp.Array{resp.BulkString("key")}},
+		{[]string{"del", "a"}, resp.Array{resp.BulkString("a")}},
+		{[]string{"del", "a", "b", "c"}, resp.Array{resp.BulkString("a"), resp.BulkString("b"), resp.BulkString("c")}},
+		{[]string{"exists", "a", "b"}, resp.Array{resp.BulkString("a"), resp.BulkString("b")}},
+		{[]string{}, wrongNumArgsReply("command|getkeys")},
+		{[]string{"nosuchcommand", "key"}, resp.Error("ERR Invalid command specified")},
+		{[]string{"get"}, resp.Error("ERR Invalid number of arguments specified for command")},
+		{[]string{"get", "a", "b"}, resp.Error("ERR Invalid number of arguments specified for command")},
+		{[]string{"del"}, resp.Error("ERR Invalid number of arguments specified for command")},
+		{[]string{"ping"}, resp.Error("ERR The command has no key arguments")},
+		{[]string{"echo", "message"}, resp.Error("ERR The command has no key arguments")},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, append([]string{"COMMAND", "GETKEYS"}, tt.args...)...)
+	}
+}
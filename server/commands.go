@@ -0,0 +1,365 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// ping returns PONG if no argument is provided, otherwise return a copy of the argument as a bulk.
+// This command is often used to test if a connection is still alive, or to measure latency.
+// https://redis.io/commands/ping/
+func (s *Server) ping(c *Client, args [][]byte) Reply {
+	// Subscribed clients receive messages too, so the reply is a push they can
+	// tell apart from the other replies
+	if c.subscriptions() > 0 && len(args) <= 1 {
+		message := resp.BulkString("")
+		if len(args) == 1 {
+			message = resp.BulkString(args[0])
+		}
+		return resp.Push{resp.BulkString("pong"), message}
+	}
+	switch len(args) {
+	case 0:
+		return resp.SimpleString("PONG")
+	case 1:
+		return resp.BulkString(args[0])
+	}
+	return wrongNumArgsReply("ping")
+}
+
+// echo `message` returns `message`.
+// https://redis.io/commands/echo/
+func (s *Server) echo(c *Client, args [][]byte) Reply {
+	return resp.BulkString(args[0])
+}
+
+// redisVersion is the version of Redis whose commands the server follows, as
+// reported by HELLO.
+const redisVersion = "7.0.0"
+
+// noProtoReply is the error for a protocol version that isn't supported.
+var noProtoReply = resp.Error("NOPROTO unsupported protocol version")
+
+// hello `[protover [AUTH username password] [SETNAME clientname]]` switches the
+// protocol of the connection and returns information about the server. Only RESP2
+// is supported, so clients asking for RESP3 receive a NOPROTO error and keep using
+// RESP2. No password can be set, so AUTH succeeds for the default user, and
+// SETNAME is accepted but the name isn't kept. https://redis.io/commands/hello/
+func (s *Server) hello(c *Client, args [][]byte) Reply {
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return resp.Error("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 {
+			return noProtoReply
+		}
+		for i := 1; i < len(args); i++ {
+			option := strings.ToLower(string(args[i]))
+			switch {
+			case option == "auth" && i+2 < len(args):
+				if string(args[i+1]) != "default" {
+					return resp.Error("WRONGPASS invalid username-password pair or user is disabled.")
+				}
+				i += 2
+			case option == "setname" && i+1 < len(args):
+				i++
+			default:
+				return errorf("ERR Syntax error in HELLO option '%s'", args[i])
+			}
+		}
+	}
+	return resp.Map{
+		resp.BulkString("server"), resp.BulkString("redis"),
+		resp.BulkString("version"), resp.BulkString(redisVersion),
+		resp.BulkString("proto"), resp.Int(2),
+		resp.BulkString("id"), resp.Int(c.id),
+		resp.BulkString("mode"), resp.BulkString("standalone"),
+		resp.BulkString("role"), resp.BulkString("master"),
+		resp.BulkString("modules"), resp.Array{},
+	}
+}
+
+// set `key` to hold the string value. If `key` already holds a value, it is overwritten,
+// regardless of its type. Any previous time to live associated with the `key` is
+// discarded on successful `SET` operation.
+// https://redis.io/commands/set/
+func (s *Server) set(c *Client, args [][]byte) Reply {
+	c.db.Write(string(args[0]), args[1])
+	s.signalModifiedKey(c, string(args[0]))
+	return okReply
+}
+
+// get the value of `key`. If the `key`` does not exist the special value `nil` is returned.
+// An error is returned if the value stored at `key` is not a string, because `GET` only
+// handles string values.
+// https://redis.io/commands/get/
+func (s *Server) get(c *Client, args [][]byte) Reply {
+	val, err := s.lookupKeyRead(c, string(args[0]))
+	if err == KeyDoesNotExist {
+		return resp.Null{}
+	}
+	if err != nil {
+		return resp.Error("ERR " + err.Error())
+	}
+	return resp.BulkString(val)
+}
+
+// exists returns a value if `key` exists.
+// The user should be aware that if the same existing `key` is mentioned in the arguments
+// multiple times, it will be counted multiple times. So if `somekey` exists, `EXIST somekey somekey` will return 2.
+// https://redis.io/commands/exists/
+func (s *Server) exists(c *Client, args [][]byte) Reply {
+	count := 0
+	for _, arg := range args {
+		s.trackKey(c, string(arg))
+		if _, ok := c.db.Storage.Read(string(arg)); ok {
+			count++
+		}
+	}
+	return resp.Int(count)
+}
+
+// del removes the specified keys. A key is ignored if it does not exist.
+// Returns Integer reply: The number of keys that were removed.
+// https://redis.io/commands/del/
+func (s *Server) del(c *Client, args [][]byte) Reply {
+	keys := make([]DBKey, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	deleted := c.db.DeleteKeys(keys...)
+	if deleted > 0 {
+		for _, key := range keys {
+			s.signalModifiedKey(c, key)
+		}
+	}
+	return resp.Int(deleted)
+}
+
+// selectDB selects the Redis logical database having the specified zero-based numeric index.
+// New connections always use the database 0. https://redis.io/commands/select/
+func (s *Server) selectDB(c *Client, args [][]byte) Reply {
+	db, errReply := s.lookupDB(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	c.db = db
+	return okReply
+}
+
+// lookupDB returns the database whose index is arg, or the error to reply with if
+// the index is invalid.
+func (s *Server) lookupDB(arg []byte) (*Database, Reply) {
+	idx, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return nil, valueIsNotIntReply
+	}
+	if idx < 0 || idx >= len(s.databases) {
+		return nil, resp.Error("ERR DB index is out of range")
+	}
+	return s.databases[idx], nil
+}
+
+// move `key` from the currently selected database (see `SELECT`) to the specified
+// destination database. When `key` already exists in the destination database, or it
+// does not exist in the source database, it does nothing.
+// It is possible to use `MOVE` as a locking primitive because of this.
+// https://redis.io/commands/move/
+func (s *Server) move(c *Client, args [][]byte) Reply {
+	newDB, errReply := s.lookupDB(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if newDB == c.db {
+		return resp.Error("ERR source and destination objects are the same")
+	}
+	if !moveKey(c.db, newDB, string(args[0])) {
+		return resp.Int(0)
+	}
+	s.signalModifiedKey(c, string(args[0]))
+	return resp.Int(1)
+}
+
+// keys returns all the keys matching the glob-style `pattern`, see stringMatch.
+// The keys are read from a snapshot of the database, so writers aren't blocked while
+// the keys are matched. https://redis.io/commands/keys/
+func (s *Server) keys(c *Client, args [][]byte) Reply {
+	pattern := string(args[0])
+	keys := c.db.Keys(func(key DBKey) bool {
+		return stringMatch(pattern, key)
+	})
+	reply := make(resp.Array, 0, len(keys))
+	for _, key := range keys {
+		reply = append(reply, resp.BulkString(key))
+	}
+	return reply
+}
+
+// randomKey returns a random key from the currently selected database.
+// The keys are also kept in slices, so a key is picked at random in constant time.
+// https://redis.io/commands/randomkey/
+func (s *Server) randomKey(c *Client, args [][]byte) Reply {
+	key, ok := c.db.RandomKey()
+	if !ok {
+		return resp.Null{}
+	}
+	return resp.BulkString(key)
+}
+
+const (
+	dirIncr = iota
+	dirDecr
+)
+
+// Increments or decrements the number stored at key by one or by the value provided.
+// If the key does not exist, it is set to 0 before performing the operation.
+// An error is returned if the key contains a value of the wrong type or contains a
+// string that can not be represented as integer. This operation is limited to 64 bit signed integers.
+// Note: this is a string operation because Redis does not have a dedicated integer type.
+// The string stored at the key is interpreted as a base-10 64 bit signed integer to
+// execute the operation.
+// Redis stores integers in their integer representation, so for string values that
+// actually hold an integer, there is no overhead for storing the string representation
+// of the integer.
+// https://redis.io/commands/incr/
+// https://redis.io/commands/decr/
+// https://redis.io/commands/incrby/
+// https://redis.io/commands/decrby/
+func (s *Server) incrDecrGenerator(dir int, by bool) commandHandler {
+	var sum func(a, b int) int
+
+	if dir == dirDecr {
+		sum = func(a, b int) int {
+			return a - b
+		}
+	} else {
+		sum = func(a, b int) int {
+			return a + b
+		}
+	}
+
+	return func(c *Client, args [][]byte) Reply {
+		key := string(args[0])
+
+		val, err := c.db.ReadInt(key)
+		if err != nil {
+			if err == KeyDoesNotExist {
+				var v int
+				if by {
+					v, err = strconv.Atoi(string(args[1]))
+					if err != nil {
+						return valueIsNotIntReply
+					}
+				} else {
+					v = 1
+				}
+				c.db.Write(key, []byte(fmt.Sprint(v)))
+				s.signalModifiedKey(c, key)
+				return resp.Int(v)
+			} else if errors.Is(err, errInvalidValue) {
+				return resp.Error("ERR " + err.Error())
+			} else {
+				return valueIsNotIntReply
+			}
+		}
+
+		var v int
+		if by {
+			changeBy, err := strconv.Atoi(string(args[1]))
+			if err != nil {
+				return valueIsNotIntReply
+			}
+			v = sum(val, changeBy)
+		} else {
+			v = sum(val, 1)
+		}
+		c.db.Write(key, []byte(fmt.Sprint(v)))
+		s.signalModifiedKey(c, key)
+		return resp.Int(v)
+	}
+}
+
+// dbSize returns the number of keys in the currently-selected database.
+// https://redis.io/commands/dbsize/
+func (s *Server) dbSize(c *Client, args [][]byte) Reply {
+	return resp.Int(c.db.Size())
+}
+
+// flushDB deletes all the keys of the currently selected database.
+// https://redis.io/commands/flushdb/
+func (s *Server) flushDB(c *Client, args [][]byte) Reply {
+	async, errReply := s.parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	c.db.Flush(async)
+	s.signalFlushed()
+	s.notifyFlush("flushdb", c.db.id)
+	return okReply
+}
+
+// flushAll delete all the keys of all the existing databases, not just
+// the currently selected one.
+// https://redis.io/commands/flushall/
+func (s *Server) flushAll(c *Client, args [][]byte) Reply {
+	async, errReply := s.parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, d := range s.databases {
+		d.Flush(async)
+		s.notifyFlush("flushall", d.id)
+	}
+	s.signalFlushed()
+	return okReply
+}
+
+// parseFlushMode parses the optional ASYNC or SYNC argument of the flush commands.
+// Either way the keys are dropped at once, by swapping in empty shards: a SYNC flush
+// empties the old shards before replying, while an ASYNC one leaves them to a
+// background goroutine. Without arguments the mode is set by lazyfree-lazy-user-flush.
+func (s *Server) parseFlushMode(args [][]byte) (async bool, errReply Reply) {
+	if len(args) == 0 {
+		return atomic.LoadInt32(&s.lazyFlush) == 1, nil
+	}
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "async":
+			return true, nil
+		case "sync":
+			return false, nil
+		}
+	}
+	return false, resp.Error("ERR syntax error")
+}
+
+// quit closes the connection. https://redis.io/commands/quit/
+func (s *Server) quit(c *Client, args [][]byte) Reply {
+	c.closeAfterReply = "QUIT"
+	return okReply
+}
+
+// memory is a container command for the subcommands that report memory usage.
+// `MEMORY USAGE key` returns the estimated number of bytes used by the key and its
+// value, the optional `SAMPLES count` is accepted for compatibility and ignored.
+// https://redis.io/commands/memory-usage/
+func (s *Server) memory(c *Client, args [][]byte) Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "usage":
+		if len(args) != 2 && !(len(args) == 4 && strings.EqualFold(string(args[2]), "samples")) {
+			return wrongNumArgsReply("memory|usage")
+		}
+		size, ok := c.db.KeyMemory(string(args[1]))
+		if !ok {
+			return resp.Null{}
+		}
+		return resp.Int(size)
+	}
+	return errorf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0])
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestBinarySafeKeysAndValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"empty value", "key", ""},
+		{"empty key", "", "value"},
+		{"NUL bytes", "k\x00ey", "v\x00al\x00"},
+		{"CRLF", "k\r\ney", "line\r\nanother\r\n"},
+		{"invalid UTF-8", "\xff\xfe", "\xc3\x28\xa0\xa1"},
+		{"all bytes", "bytes", allBytes()},
+		{"RESP lookalike", "*2\r\n$3\r\nGET", "$-1\r\n"},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+			c.expect(okReply, "SET", tt.key, tt.value)
+			c.expect(resp.BulkString(tt.value), "GET", tt.key)
+			c.expect(resp.Int(1), "EXISTS", tt.key)
+			c.expect(resp.Int(1), "DEL", tt.key)
+			c.expect(resp.Null{}, "GET", tt.key)
+		})
+	}
+}
+
+func allBytes() string {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return string(b)
+}
+
+// infoField returns the value of a field of INFO section.
+func infoField(c *testClient, section, field string) string {
+	c.t.Helper()
+	info := string(c.do("INFO", section).(resp.BulkString))
+	for _, line := range strings.Split(info, "\r\n") {
+		if value := strings.TrimPrefix(line, field+":"); value != line {
+			return value
+		}
+	}
+	c.t.Fatalf("no %s in INFO %s:\n%s", field, section, info)
+	return ""
+}
+
+func TestMemoryUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{"empty value", "key", ""},
+		{"short value", "key", "value"},
+		{"long key", strings.Repeat("k", 1000), "value"},
+		{"large value", "large", strings.Repeat("v", 100000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			c.expect(resp.Null{}, "MEMORY", "USAGE", tt.key)
+
+			// The key, the stored value with its encoding byte, and the overhead
+			want := int64(entryOverhead + len(tt.key) + 1 + len(tt.value))
+			c.expect(okReply, "SET", tt.key, tt.value)
+			c.expect(resp.Int(want), "MEMORY", "USAGE", tt.key)
+			c.expect(resp.Int(want), "MEMORY", "USAGE", tt.key, "SAMPLES", "5")
+			if got := infoField(c, "memory", "used_memory_dataset"); got != strconv.FormatInt(want, 10) {
+				t.Errorf("used_memory_dataset = %s, want %d", got, want)
+			}
+
+			// Overwriting the value replaces its size
+			c.expect(okReply, "SET", tt.key, tt.value+"more")
+			c.expect(resp.Int(want+4), "MEMORY", "USAGE", tt.key)
+
+			// Keys in other databases add up
+			c.expect(okReply, "SELECT", "1")
+			c.expect(okReply, "SET", tt.key, tt.value)
+			if got := infoField(c, "memory", "used_memory_db1"); got != strconv.FormatInt(want, 10) {
+				t.Errorf("used_memory_db1 = %s, want %d", got, want)
+			}
+			if got := infoField(c, "memory", "used_memory_dataset"); got != strconv.FormatInt(2*want+4, 10) {
+				t.Errorf("used_memory_dataset = %s, want %d", got, 2*want+4)
+			}
+
+			c.expect(resp.Int(1), "DEL", tt.key)
+			c.expect(okReply, "SELECT", "0")
+			c.expect(okReply, "FLUSHDB")
+			c.expect(resp.Null{}, "MEMORY", "USAGE", tt.key)
+			if got := infoField(c, "memory", "used_memory_dataset"); got != "0" {
+				t.Errorf("used_memory_dataset = %s after deleting the keys, want 0", got)
+			}
+		})
+	}
+}
+
+func TestMemoryUsageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want resp.Reply
+	}{
+		{"no key", []string{"MEMORY", "USAGE"}, resp.Error("ERR wrong number of arguments for 'memory|usage' command")},
+		{"invalid option", []string{"MEMORY", "USAGE", "key", "COUNT", "5"}, resp.Error("ERR wrong number of arguments for 'memory|usage' command")},
+		{"unknown subcommand", []string{"MEMORY", "DOCTOR\r\n"}, resp.Error("ERR unknown subcommand 'DOCTOR  '. Try MEMORY HELP.")},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+			c.expect(tt.want, tt.args...)
+		})
+	}
+}
+
+func TestLargeValues(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"1MB", 1 << 20},
+		{"16MB", 16 << 20},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+			value := strings.Repeat("v", tt.size)
+			c.expect(okReply, "SET", "large", value)
+			// Replies pipelined around the large one are framed correctly
+			c.send("PING")
+			c.send("GET", "large")
+			c.send("EXISTS", "large")
+			if got := c.read(); got != resp.Reply(resp.SimpleString("PONG")) {
+				t.Errorf("PING = %#v", got)
+			}
+			if got := c.read().(resp.BulkString); string(got) != value {
+				t.Errorf("GET returned %d bytes, want %d", len(got), tt.size)
+			}
+			if got := c.read(); got != resp.Reply(resp.Int(1)) {
+				t.Errorf("EXISTS = %#v, want 1", got)
+			}
+		})
+	}
+}
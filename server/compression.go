@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+)
+
+// DefaultValueCompressionMinSize is the size from which values are compressed, if
+// compression is enabled and the size isn't configured otherwise.
+const DefaultValueCompressionMinSize = 4096
+
+// The encodings of the values, the first byte of every value written to a Storage.
+// The encoding is carried by each value, so changing value-compression at runtime
+// only affects the values written afterwards.
+const (
+	encodingRaw byte = iota
+	encodingSnappy
+)
+
+// valueCompression holds the compression settings shared by the databases. The
+// values are compressed by Database, so compression works with every Storage.
+type valueCompression struct {
+	// enabled is 1 when the values are compressed with snappy
+	enabled int32
+	minSize int64
+}
+
+// compressBuffers are scratch buffers for snappy, the compressed value is copied
+// out of them so the stored value has no spare capacity counted by the memory.
+// Buffers larger than maxPooledCompressBuffer aren't kept, so that a single huge
+// value doesn't pin its buffer.
+var compressBuffers sync.Pool
+
+const maxPooledCompressBuffer = 1 << 20
+
+// encode returns the value to store, compressed if it's large enough and compression
+// makes it smaller.
+func (vc *valueCompression) encode(value []byte) []byte {
+	if atomic.LoadInt32(&vc.enabled) == 1 && int64(len(value)) >= atomic.LoadInt64(&vc.minSize) {
+		var buf []byte
+		if b, ok := compressBuffers.Get().(*[]byte); ok {
+			buf = *b
+		}
+		if n := snappy.MaxEncodedLen(len(value)); cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		compressed := snappy.Encode(buf[:cap(buf)], value)
+		var stored []byte
+		// Incompressible values, such as random bytes, are stored as they are
+		if len(compressed) < len(value) {
+			stored = make([]byte, 1+len(compressed))
+			stored[0] = encodingSnappy
+			copy(stored[1:], compressed)
+		}
+		if cap(buf) <= maxPooledCompressBuffer {
+			compressBuffers.Put(&buf)
+		}
+		if stored != nil {
+			return stored
+		}
+	}
+	stored := make([]byte, 1+len(value))
+	stored[0] = encodingRaw
+	copy(stored[1:], value)
+	return stored
+}
+
+// decodeValue returns the value stored, decompressed if needed. Values that aren't
+// compressed are returned without copying them. The errors wrap errInvalidValue.
+func decodeValue(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("%w without encoding", errInvalidValue)
+	}
+	switch stored[0] {
+	case encodingRaw:
+		return stored[1:], nil
+	case encodingSnappy:
+		value, err := snappy.Decode(nil, stored[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w, failed to decompress it: %v", errInvalidValue, err)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w with unknown encoding %d", errInvalidValue, stored[0])
+}
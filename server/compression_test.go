@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestValueCompression(t *testing.T) {
+	compressible := bytes.Repeat([]byte("abcd"), 2048)
+	random := make([]byte, 8192)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name         string
+		enabled      bool
+		minSize      int64
+		value        []byte
+		wantEncoding byte
+	}{
+		{"disabled", false, 0, compressible, encodingRaw},
+		{"compressed", true, 4096, compressible, encodingSnappy},
+		{"at the minimum size", true, int64(len(compressible)), compressible, encodingSnappy},
+		{"below the minimum size", true, int64(len(compressible)) + 1, compressible, encodingRaw},
+		{"incompressible", true, 0, random, encodingRaw},
+		{"empty", true, 0, []byte{}, encodingRaw},
+		{"empty when disabled", false, 0, []byte{}, encodingRaw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vc := &valueCompression{minSize: tt.minSize}
+			if tt.enabled {
+				vc.enabled = 1
+			}
+			stored := vc.encode(tt.value)
+			if stored[0] != tt.wantEncoding {
+				t.Errorf("encoding %d, want %d", stored[0], tt.wantEncoding)
+			}
+			if tt.wantEncoding == encodingSnappy && len(stored) >= len(tt.value) {
+				t.Errorf("compressed to %d bytes from %d", len(stored), len(tt.value))
+			}
+			if cap(stored) != len(stored) {
+				t.Errorf("stored value with %d bytes of spare capacity", cap(stored)-len(stored))
+			}
+			value, err := decodeValue(stored)
+			if err != nil || !bytes.Equal(value, tt.value) {
+				t.Errorf("decodeValue = %d bytes, %v, want the %d bytes encoded", len(value), err, len(tt.value))
+			}
+		})
+	}
+}
+
+func TestCompressBuffersAreCapped(t *testing.T) {
+	vc := &valueCompression{enabled: 1}
+	small := bytes.Repeat([]byte("a"), 4096)
+	large := bytes.Repeat([]byte("a"), 2*maxPooledCompressBuffer)
+	for i := 0; i < 10; i++ {
+		vc.encode(small)
+		vc.encode(large)
+	}
+	for i := 0; i < 100; i++ {
+		b, ok := compressBuffers.Get().(*[]byte)
+		if !ok {
+			break
+		}
+		if cap(*b) > maxPooledCompressBuffer {
+			t.Fatalf("pooled a buffer of %d bytes, larger than %d", cap(*b), maxPooledCompressBuffer)
+		}
+	}
+}
+
+func TestDecodeValueErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []byte
+	}{
+		{"empty", []byte{}},
+		{"unknown encoding", []byte{7, 'v'}},
+		{"invalid snappy data", append([]byte{encodingSnappy}, "not snappy"...)},
+		{"truncated snappy data", []byte{encodingSnappy, 0xff}},
+	}
+	for _, tt := range tests {
+		if value, err := decodeValue(tt.stored); !errors.Is(err, errInvalidValue) {
+			t.Errorf("%s: decodeValue = %q, %v, want errInvalidValue", tt.name, value, err)
+		}
+	}
+}
+
+func TestValueCompressionConfig(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	value := strings.Repeat("value", 1000)
+
+	c.expect(okReply, "SET", "raw", value)
+	c.expect(okReply, "CONFIG", "SET", "value-compression", "snappy")
+	c.expect(okReply, "CONFIG", "SET", "value-compression-min-size", "1kb")
+	c.expect(okReply, "SET", "compressed", value)
+	c.expect(okReply, "CONFIG", "SET", "value-compression", "no")
+	// The values keep the encoding they were written with
+	for _, key := range []string{"raw", "compressed"} {
+		c.expect(resp.BulkString(value), "GET", key)
+	}
+	raw, compressed := c.do("MEMORY", "USAGE", "raw").(resp.Int), c.do("MEMORY", "USAGE", "compressed").(resp.Int)
+	if compressed >= raw {
+		t.Errorf("MEMORY USAGE of the compressed value %d, not less than %d", compressed, raw)
+	}
+	c.expect(
+		resp.Error("ERR CONFIG SET failed (possibly related to argument 'value-compression') - argument must be one of the following: no, snappy"),
+		"CONFIG", "SET", "value-compression", "gzip",
+	)
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// configParam is a configuration parameter exposed through CONFIG GET. Parameters
+// with a setter can also be changed at runtime with CONFIG SET, the setter validates
+// the new value and returns an error describing why it was rejected. The protected
+// ones are only settable if Options.EnableProtectedConfigs is set.
+type configParam struct {
+	get       func() string
+	set       func(value string) error
+	protected bool
+}
+
+// ErrConfigNotSettable is returned by ConfigSet for parameters that don't exist or
+// that can't be changed while the server is running.
+var ErrConfigNotSettable = errors.New("unknown option or option not settable at runtime")
+
+// ErrConfigProtected is returned by ConfigSet for the protected parameters, unless
+// Options.EnableProtectedConfigs is set.
+var ErrConfigProtected = errors.New("can't set protected config")
+
+// ConfigGet returns the current value of a configuration parameter, formatted as in
+// the reply to CONFIG GET.
+func (s *Server) ConfigGet(name string) (string, bool) {
+	param, ok := s.configParams[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return param.get(), true
+}
+
+// ConfigSet changes a configuration parameter at runtime, as CONFIG SET does.
+func (s *Server) ConfigSet(name, value string) error {
+	param, ok := s.configParams[strings.ToLower(name)]
+	if !ok || param.set == nil {
+		return ErrConfigNotSettable
+	}
+	if param.protected && !s.opts.EnableProtectedConfigs {
+		return ErrConfigProtected
+	}
+	return param.set(value)
+}
+
+func (s *Server) newConfigParams() map[string]configParam {
+	return map[string]configParam{
+		"accept-rate-per-ip": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.acceptRatePerIP), 10)
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("argument must be between 0 and %d inclusive", math.MaxInt32)
+				}
+				atomic.StoreInt64(&s.acceptRatePerIP, int64(n))
+				return nil
+			},
+		},
+		"client-output-buffer-limit": {
+			get: func() string {
+				return formatOutputBufferLimits(s.outputBufferLimits())
+			},
+			set: func(value string) error {
+				s.obufLimitsMu.Lock()
+				defer s.obufLimitsMu.Unlock()
+
+				limits, err := parseOutputBufferLimits(s.outputBufferLimits(), value)
+				if err != nil {
+					return err
+				}
+				s.obufLimits.Store(limits)
+				return nil
+			},
+		},
+		"enable-protected-configs": {
+			get: func() string {
+				return yesNo(s.opts.EnableProtectedConfigs)
+			},
+		},
+		"ip-limits-exempt-local": {
+			get: func() string {
+				return yesNo(atomic.LoadInt32(&s.ipLimitsExemptLocal) == 1)
+			},
+			set: func(value string) error {
+				enabled, err := parseYesNo(value)
+				if err != nil {
+					return err
+				}
+				var v int32
+				if enabled {
+					v = 1
+				}
+				atomic.StoreInt32(&s.ipLimitsExemptLocal, v)
+				return nil
+			},
+		},
+		"lazyfree-lazy-user-flush": {
+			get: func() string {
+				return yesNo(atomic.LoadInt32(&s.lazyFlush) == 1)
+			},
+			set: func(value string) error {
+				enabled, err := parseYesNo(value)
+				if err != nil {
+					return err
+				}
+				var v int32
+				if enabled {
+					v = 1
+				}
+				atomic.StoreInt32(&s.lazyFlush, v)
+				return nil
+			},
+		},
+		// As in Redis, changing the log file is protected, as it lets any client
+		// create and write files anywhere the server can
+		"logfile": {
+			get: func() string {
+				return s.logger.File()
+			},
+			set: func(value string) error {
+				return s.logger.SetFile(value)
+			},
+			protected: true,
+		},
+		"loglevel": {
+			get: func() string {
+				return s.logger.Level().String()
+			},
+			set: func(value string) error {
+				level, err := ParseLogLevel(value)
+				if err != nil {
+					return fmt.Errorf("argument(s) must be one of the following: debug, verbose, notice, warning")
+				}
+				s.logger.SetLevel(level)
+				return nil
+			},
+		},
+		"maxclients": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.maxClients), 10)
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return fmt.Errorf("argument must be between 1 and %d inclusive", math.MaxInt32)
+				}
+				atomic.StoreInt64(&s.maxClients, int64(n))
+				return nil
+			},
+		},
+		"maxclients-per-ip": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.maxClientsPerIP), 10)
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("argument must be between 0 and %d inclusive", math.MaxInt32)
+				}
+				atomic.StoreInt64(&s.maxClientsPerIP, int64(n))
+				return nil
+			},
+		},
+		"notify-keyspace-events": {
+			get: func() string {
+				return keyspaceEventsString(atomic.LoadInt32(&s.notifyFlags))
+			},
+			set: func(value string) error {
+				flags, err := parseKeyspaceEvents(value)
+				if err != nil {
+					return err
+				}
+				atomic.StoreInt32(&s.notifyFlags, flags)
+				return nil
+			},
+		},
+		"protected-mode": {
+			get: func() string {
+				return yesNo(s.protectedMode())
+			},
+			set: func(value string) error {
+				enabled, err := parseYesNo(value)
+				if err != nil {
+					return err
+				}
+				var v int32
+				if enabled {
+					v = 1
+				}
+				atomic.StoreInt32(&s.protected, v)
+				return nil
+			},
+		},
+		"tcp-keepalive": {
+			get: func() string {
+				return strconv.Itoa(int(s.tcpKeepAlive().Seconds()))
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("argument couldn't be parsed into an integer")
+				}
+				atomic.StoreInt64(&s.keepAlive, int64(time.Duration(n)*time.Second))
+				return nil
+			},
+		},
+		"tcp-nodelay": {
+			get: func() string {
+				return yesNo(s.tcpNoDelay())
+			},
+			set: func(value string) error {
+				enabled, err := parseYesNo(value)
+				if err != nil {
+					return err
+				}
+				var v int32
+				if enabled {
+					v = 1
+				}
+				atomic.StoreInt32(&s.noDelay, v)
+				return nil
+			},
+		},
+		"timeout": {
+			get: func() string {
+				return strconv.Itoa(int(s.idleTimeout().Seconds()))
+			},
+			set: func(value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					return fmt.Errorf("argument couldn't be parsed into an integer")
+				}
+				atomic.StoreInt64(&s.timeout, int64(time.Duration(n)*time.Second))
+				return nil
+			},
+		},
+		"tls-auth-clients": {
+			get: func() string {
+				if s.opts.TLSAuthClients == "" {
+					return "no"
+				}
+				return s.opts.TLSAuthClients
+			},
+		},
+		"tls-ca-cert-file": {
+			get: func() string { return s.opts.TLSCACertFile },
+		},
+		"tls-cert-file": {
+			get: func() string { return s.opts.TLSCertFile },
+		},
+		"tls-key-file": {
+			get: func() string { return s.opts.TLSKeyFile },
+		},
+		"tls-port": {
+			get: func() string { return strconv.Itoa(s.opts.TLSPort) },
+		},
+		"trace-proto": {
+			get: func() string {
+				return yesNo(s.traceProtoEnabled())
+			},
+			set: func(value string) error {
+				enabled, err := parseYesNo(value)
+				if err != nil {
+					return err
+				}
+				var v int32
+				if enabled {
+					v = 1
+				}
+				atomic.StoreInt32(&s.traceProto, v)
+				return nil
+			},
+		},
+		"trace-proto-max-len": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.traceProtoMaxLen), 10)
+			},
+			set: func(value string) error {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || n < 1 {
+					return fmt.Errorf("argument must be between 1 and %d inclusive", math.MaxInt32)
+				}
+				atomic.StoreInt64(&s.traceProtoMaxLen, n)
+				return nil
+			},
+		},
+		"tracking-table-max-keys": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.tracking.maxKeys), 10)
+			},
+			set: func(value string) error {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || n < 0 {
+					return fmt.Errorf("argument couldn't be parsed into an integer")
+				}
+				atomic.StoreInt64(&s.tracking.maxKeys, n)
+				return nil
+			},
+		},
+		"unixsocket": {
+			get: func() string { return s.opts.UnixSocket },
+		},
+		"unixsocketperm": {
+			get: func() string { return strconv.FormatUint(uint64(s.opts.UnixSocketPerm), 8) },
+		},
+		"value-compression": {
+			get: func() string {
+				if atomic.LoadInt32(&s.compression.enabled) == 1 {
+					return "snappy"
+				}
+				return "no"
+			},
+			set: func(value string) error {
+				var v int32
+				switch strings.ToLower(value) {
+				case "snappy":
+					v = 1
+				case "no":
+				default:
+					return fmt.Errorf("argument must be one of the following: no, snappy")
+				}
+				atomic.StoreInt32(&s.compression.enabled, v)
+				return nil
+			},
+		},
+		"value-compression-min-size": {
+			get: func() string {
+				return strconv.FormatInt(atomic.LoadInt64(&s.compression.minSize), 10)
+			},
+			set: func(value string) error {
+				n, err := parseMemory(value)
+				if err != nil {
+					return err
+				}
+				atomic.StoreInt64(&s.compression.minSize, n)
+				return nil
+			},
+		},
+	}
+}
+
+// idleTimeout is the time after which idle clients are disconnected, zero means
+// that clients are never disconnected.
+func (s *Server) idleTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.timeout))
+}
+
+// config is a container command for the subcommands that manage the configuration.
+// `CONFIG GET parameter [parameter ...]` reads the configuration parameters matching
+// the glob-style patterns, replying with a list of name and value pairs.
+// `CONFIG SET parameter value [parameter value ...]` changes the configuration at
+// runtime, without restarting the server.
+// https://redis.io/commands/config-get/
+// https://redis.io/commands/config-set/
+func (s *Server) config(c *Client, args [][]byte) Reply {
+	subcommand := strings.ToLower(string(args[0]))
+	switch subcommand {
+	case "get":
+		if len(args) < 2 {
+			return wrongNumArgsReply("config|get")
+		}
+		names := make([]string, 0, len(s.configParams))
+		for name := range s.configParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		reply := [][]byte{}
+		for _, name := range names {
+			for _, pattern := range args[1:] {
+				if stringMatch(strings.ToLower(string(pattern)), name) {
+					reply = append(reply, []byte(name), []byte(s.configParams[name].get()))
+					break
+				}
+			}
+		}
+		return bulkStringArray(reply)
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return wrongNumArgsReply("config|set")
+		}
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(string(args[i]))
+			err := s.ConfigSet(name, string(args[i+1]))
+			if err == ErrConfigNotSettable {
+				return errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i])
+			}
+			if err != nil {
+				return errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, err)
+			}
+		}
+		return okReply
+	}
+	return errorf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0])
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no'")
+}
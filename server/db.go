@@ -0,0 +1,554 @@
+package server
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+type DBKey = string
+
+// dbShards is the number of shards of a memoryStorage, it must be a power of two.
+const dbShards = 32
+
+// Database is a logical database, as selected with SELECT, holding its keys in a
+// Storage. The values are encoded, and possibly compressed, by Database, so that
+// the storage only sees opaque bytes.
+type Database struct {
+	// id is the index of the database, as selected with SELECT
+	id          int
+	compression *valueCompression
+	// maxKeySize is the MaxKeySize of the storage, or 0 if keys can be of any size
+	maxKeySize int
+	Storage
+}
+
+// memoryStorage stores the keys in shards selected by the hash of the key, each with
+// its own lock, so that commands on different keys rarely wait for each other.
+// Operations on the whole storage, such as Flush, lock every shard, always in the
+// same order so they can't deadlock with multi-key operations.
+type memoryStorage struct {
+	// id orders the locks taken by Move, it's the id of the database
+	id     int
+	shards [dbShards]dbShard
+}
+
+// Adapted from https://stackoverflow.com/a/68217701/5008494
+// The keys slice allows picking a random key in constant time.
+type dbShard struct {
+	mu        sync.RWMutex
+	container map[DBKey][]byte
+	keys      []DBKey
+	keyIndex  map[DBKey]int
+	// used is the estimated memory used by the keys and values of the shard, it's
+	// written with the lock held and read atomically
+	used int64
+}
+
+// The overhead of a key stored in a shard: the headers of the key and value in the
+// map and in the keys slice, plus the bookkeeping of the two maps.
+const entryOverhead = 16 + 24 + 16 + 8 + 48
+
+// stringEntrySize estimates the memory used by a key holding a string value.
+func stringEntrySize(key DBKey, value []byte) int64 {
+	return int64(entryOverhead + len(key) + cap(value))
+}
+
+func newMemoryStorage(id int) *memoryStorage {
+	db := &memoryStorage{id: id}
+	for i := range db.shards {
+		db.shards[i].reset()
+	}
+	return db
+}
+
+// shardIndex hashes key with FNV-1a.
+func shardIndex(key DBKey) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h & (dbShards - 1))
+}
+
+func (db *memoryStorage) shard(key DBKey) *dbShard {
+	return &db.shards[shardIndex(key)]
+}
+
+// Read securely from memoryStorage
+func (db *memoryStorage) Read(key DBKey) (v []byte, ok bool) {
+	s := db.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok = s.container[key]
+	return
+}
+
+// Write securely to memoryStorage.
+func (db *memoryStorage) Write(key DBKey, value []byte) {
+	s := db.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.write(key, value)
+}
+
+// Delete securely from memoryStorage.
+func (db *memoryStorage) Delete(key DBKey) {
+	s := db.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(key)
+}
+
+// Flush deletes all the keys of the memoryStorage. The shards are swapped with empty
+// ones while locked, then the old ones are emptied, by a background goroutine if
+// async is set, so that the clients only wait for the swap.
+func (db *memoryStorage) Flush(async bool) {
+	containers := make([]map[DBKey][]byte, 0, dbShards)
+	indexes := make([]map[DBKey]int, 0, dbShards)
+	db.lockAll()
+	for i := range db.shards {
+		containers = append(containers, db.shards[i].container)
+		indexes = append(indexes, db.shards[i].keyIndex)
+		db.shards[i].reset()
+	}
+	db.unlockAll()
+
+	if async {
+		go dropShards(containers, indexes)
+	} else {
+		dropShards(containers, indexes)
+	}
+}
+
+// dropShards empties the maps of flushed shards, releasing the keys and values they
+// reference for the garbage collector.
+func dropShards(containers []map[DBKey][]byte, indexes []map[DBKey]int) {
+	for _, container := range containers {
+		for key := range container {
+			delete(container, key)
+		}
+	}
+	for _, index := range indexes {
+		for key := range index {
+			delete(index, key)
+		}
+	}
+}
+
+// Size returns the number of keys stored in the memoryStorage
+func (db *memoryStorage) Size() int {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	size := 0
+	for i := range db.shards {
+		size += len(db.shards[i].container)
+	}
+	return size
+}
+
+// RandomKey returns a key picked at random, ok is false if the memoryStorage is empty.
+func (db *memoryStorage) RandomKey() (key DBKey, ok bool) {
+	db.rlockAll()
+	defer db.runlockAll()
+
+	size := 0
+	for i := range db.shards {
+		size += len(db.shards[i].keys)
+	}
+	if size == 0 {
+		return "", false
+	}
+	index := rand.Intn(size)
+	for i := range db.shards {
+		keys := db.shards[i].keys
+		if index < len(keys) {
+			return keys[index], true
+		}
+		index -= len(keys)
+	}
+	return "", false
+}
+
+// DeleteKeys deletes keys atomically, returning how many of them existed.
+func (db *memoryStorage) DeleteKeys(keys ...DBKey) int {
+	unlock := db.lockKeys(keys...)
+	defer unlock()
+
+	count := 0
+	for _, key := range keys {
+		s := db.shard(key)
+		if _, ok := s.container[key]; ok {
+			s.delete(key)
+			count++
+		}
+	}
+	return count
+}
+
+// populateBatch is the number of keys Populate inserts with each lock of the shards.
+const populateBatch = 64 * 1024
+
+// Populate adds count keys named prefix:N, N going from 0 to count-1, with the values
+// returned by value, as DEBUG POPULATE does. The keys that already exist are left
+// untouched. The keys are inserted in batches, each shard being locked once per
+// batch, and the number of keys added is returned.
+func (db *memoryStorage) Populate(count int, prefix string, value func(i int) []byte) int {
+	type entry struct {
+		key   DBKey
+		value []byte
+	}
+	var batches [dbShards][]entry
+	added := 0
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mu.Lock()
+		s.grow(count / dbShards)
+		s.mu.Unlock()
+	}
+	for start := 0; start < count; start += populateBatch {
+		end := start + populateBatch
+		if end > count {
+			end = count
+		}
+		for i := start; i < end; i++ {
+			key := prefix + ":" + strconv.Itoa(i)
+			j := shardIndex(key)
+			batches[j] = append(batches[j], entry{key, value(i)})
+		}
+		for j := range batches {
+			s := &db.shards[j]
+			s.mu.Lock()
+			for _, e := range batches[j] {
+				if _, ok := s.container[e.key]; !ok {
+					s.write(e.key, e.value)
+					added++
+				}
+			}
+			s.mu.Unlock()
+			batches[j] = batches[j][:0]
+		}
+	}
+	return added
+}
+
+// snapshotBatch is the number of values Iterate reads with each lock of a shard.
+const snapshotBatch = 512
+
+// snapshotKeys copies the keys of the shard, it only holds the lock for the time of
+// copying the slice, without reading the keys or the values.
+func (s *dbShard) snapshotKeys() []DBKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]DBKey(nil), s.keys...)
+}
+
+// Keys returns the keys for which match returns true, match is called without
+// holding any lock so long iterations don't block the writers.
+func (db *memoryStorage) Keys(match func(key DBKey) bool) []DBKey {
+	keys := []DBKey{}
+	for i := range db.shards {
+		for _, key := range db.shards[i].snapshotKeys() {
+			if match(key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Iterate calls fn for every key and its value, until fn returns false. The keys of
+// each shard are copied first, then the values are read in batches of snapshotBatch
+// keys, so the lock of a shard is never held for long and fn is called without it.
+// As with SCAN, a key that exists for the whole iteration is visited exactly once,
+// while keys added or deleted during the iteration may or may not be visited.
+func (db *memoryStorage) Iterate(fn func(key DBKey, value []byte) bool) {
+	type entry struct {
+		key   DBKey
+		value []byte
+	}
+	batch := make([]entry, 0, snapshotBatch)
+	for i := range db.shards {
+		s := &db.shards[i]
+		keys := s.snapshotKeys()
+		for len(keys) > 0 {
+			n := snapshotBatch
+			if n > len(keys) {
+				n = len(keys)
+			}
+			batch = batch[:0]
+			s.mu.RLock()
+			for _, key := range keys[:n] {
+				if value, ok := s.container[key]; ok {
+					batch = append(batch, entry{key, value})
+				}
+			}
+			s.mu.RUnlock()
+			keys = keys[n:]
+
+			for _, e := range batch {
+				if !fn(e.key, e.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// UsedMemory returns the estimated memory used by the keys and values of the
+// memoryStorage, it's kept up to date by every write so it's cheap to call.
+func (db *memoryStorage) UsedMemory() int64 {
+	var used int64
+	for i := range db.shards {
+		used += atomic.LoadInt64(&db.shards[i].used)
+	}
+	return used
+}
+
+// KeyMemory returns the estimated memory used by key and its value, ok is false if
+// the key doesn't exist.
+func (db *memoryStorage) KeyMemory(key DBKey) (size int64, ok bool) {
+	s := db.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.container[key]
+	if !ok {
+		return 0, false
+	}
+	return stringEntrySize(key, value), true
+}
+
+// Read returns the value of key, decompressed if needed, or KeyDoesNotExist. The
+// value must not be modified.
+func (db *Database) Read(key DBKey) ([]byte, error) {
+	stored, ok := db.Storage.Read(key)
+	if !ok {
+		return nil, KeyDoesNotExist
+	}
+	return decodeValue(stored)
+}
+
+// Write sets key to value, compressed if it's large enough. The value is copied, so
+// callers can reuse it, as the arguments of the commands are.
+func (db *Database) Write(key DBKey, value []byte) {
+	db.Storage.Write(key, db.compression.encode(value))
+}
+
+// Iterate calls fn for every key and its value, decompressed if needed. It stops at
+// the first value that can't be decoded, returning the error.
+func (db *Database) Iterate(fn func(key DBKey, value []byte) bool) error {
+	var err error
+	db.Storage.Iterate(func(key DBKey, stored []byte) bool {
+		var value []byte
+		if value, err = decodeValue(stored); err != nil {
+			return false
+		}
+		return fn(key, value)
+	})
+	return err
+}
+
+// Populate adds the keys of DEBUG POPULATE, compressing the values as Write does.
+func (db *Database) Populate(count int, prefix string, value func(i int) []byte) int {
+	return db.Storage.Populate(count, prefix, func(i int) []byte {
+		return db.compression.encode(value(i))
+	})
+}
+
+func (db *Database) ReadInt(key DBKey) (int, error) {
+	v, err := db.Read(key)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// lockKeys locks the shards holding keys for writing, in ascending order so that
+// concurrent multi-key operations can't deadlock. It returns the function unlocking
+// them.
+func (db *memoryStorage) lockKeys(keys ...DBKey) (unlock func()) {
+	indexes := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		i := shardIndex(key)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		db.shards[i].mu.Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			db.shards[indexes[j]].mu.Unlock()
+		}
+	}
+}
+
+func (db *memoryStorage) lockAll() {
+	for i := range db.shards {
+		db.shards[i].mu.Lock()
+	}
+}
+
+func (db *memoryStorage) unlockAll() {
+	for i := len(db.shards) - 1; i >= 0; i-- {
+		db.shards[i].mu.Unlock()
+	}
+}
+
+func (db *memoryStorage) rlockAll() {
+	for i := range db.shards {
+		db.shards[i].mu.RLock()
+	}
+}
+
+func (db *memoryStorage) runlockAll() {
+	for i := len(db.shards) - 1; i >= 0; i-- {
+		db.shards[i].mu.RUnlock()
+	}
+}
+
+// Move moves key to dst, another memoryStorage, unless it doesn't exist here or it
+// already exists in dst. The shards of both storages are locked in the order of their
+// ids, so concurrent moves in opposite directions can't deadlock.
+func (db *memoryStorage) Move(dst Storage, key DBKey) bool {
+	other, ok := dst.(*memoryStorage)
+	if !ok || other == db {
+		return false
+	}
+	i := shardIndex(key)
+	from, to := &db.shards[i], &other.shards[i]
+	first, second := from, to
+	if other.id < db.id {
+		first, second = to, from
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	value, ok := from.container[key]
+	if !ok {
+		return false
+	}
+	if _, ok := to.container[key]; ok {
+		return false
+	}
+	to.write(key, value)
+	from.delete(key)
+	return true
+}
+
+// The methods of dbShard expect the caller to hold the lock.
+
+// grow makes room for n more keys in an empty shard, so that inserting many keys at
+// once doesn't rehash the maps over and over.
+func (s *dbShard) grow(n int) {
+	if len(s.container) > 0 {
+		return
+	}
+	s.container = make(map[DBKey][]byte, n)
+	s.keys = make([]DBKey, 0, n)
+	s.keyIndex = make(map[DBKey]int, n)
+}
+
+func (s *dbShard) reset() {
+	s.container = make(map[DBKey][]byte)
+	s.keys = []DBKey{}
+	s.keyIndex = make(map[DBKey]int)
+	atomic.StoreInt64(&s.used, 0)
+}
+
+func (s *dbShard) write(key DBKey, value []byte) {
+	delta := stringEntrySize(key, value)
+	if old, ok := s.container[key]; ok {
+		delta -= stringEntrySize(key, old)
+	} else {
+		s.keys = append(s.keys, key)
+		s.keyIndex[key] = len(s.keys) - 1
+	}
+	s.container[key] = value
+	atomic.AddInt64(&s.used, delta)
+}
+
+func (s *dbShard) delete(key DBKey) {
+	index, ok := s.keyIndex[key]
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&s.used, -stringEntrySize(key, s.container[key]))
+
+	delete(s.keyIndex, key)
+
+	lastIndex := len(s.keys) - 1
+	wasLastIndex := index == lastIndex
+
+	// swap last key in place of the deleted one and update its index
+	if !wasLastIndex {
+		s.keys[index] = s.keys[lastIndex]
+		lastKey := s.keys[index]
+		s.keyIndex[lastKey] = index
+	}
+	// remove last element from keys slice
+	s.keys = s.keys[:lastIndex]
+
+	delete(s.container, key)
+}
+
+// newDatabases creates the logical databases, indexed by their id, with the storages
+// returned by storage, or in memory if it's nil, and the shared compression settings.
+func newDatabases(n int, storage func(id int) Storage, compression *valueCompression) []*Database {
+	databases := make([]*Database, n)
+	for i := range databases {
+		databases[i] = &Database{id: i, compression: compression}
+		if storage != nil {
+			databases[i].Storage = storage(i)
+		} else {
+			databases[i].Storage = newMemoryStorage(i)
+		}
+		if limiter, ok := databases[i].Storage.(keySizeLimiter); ok {
+			databases[i].maxKeySize = limiter.MaxKeySize()
+		}
+	}
+	return databases
+}
+
+// keysTooLarge reports whether any of keys is longer than the storage can hold.
+func (db *Database) keysTooLarge(keys [][]byte) bool {
+	for _, key := range keys {
+		if len(key) > db.maxKeySize {
+			return true
+		}
+	}
+	return false
+}
+
+// moveKey moves key from src to dst, unless it doesn't exist in src or it already
+// exists in dst.
+func moveKey(src, dst *Database, key DBKey) bool {
+	if src == dst {
+		return false
+	}
+	return src.Move(dst.Storage, key)
+}
+
+// ID returns the index of the database, as selected with SELECT.
+func (db *Database) ID() int {
+	return db.id
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// checkShards fails the test unless every shard of db is consistent: its keys are
+// the ones hashed to it, and the keys slice, key index and memory estimate agree
+// with the container.
+func checkShards(t *testing.T, db *memoryStorage) {
+	t.Helper()
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mu.RLock()
+		if len(s.keys) != len(s.container) || len(s.keyIndex) != len(s.container) {
+			t.Errorf("shard %d has %d keys, %d indexed and %d stored", i, len(s.keys), len(s.keyIndex), len(s.container))
+		}
+		var used int64
+		for j, key := range s.keys {
+			if shardIndex(key) != i {
+				t.Errorf("key %q in shard %d, want %d", key, i, shardIndex(key))
+			}
+			if s.keyIndex[key] != j {
+				t.Errorf("key %q at %d indexed at %d", key, j, s.keyIndex[key])
+			}
+			value, ok := s.container[key]
+			if !ok {
+				t.Errorf("key %q of shard %d not stored", key, i)
+			}
+			used += stringEntrySize(key, value)
+		}
+		if s.used != used {
+			t.Errorf("shard %d uses %d bytes, want %d", i, s.used, used)
+		}
+		s.mu.RUnlock()
+	}
+}
+
+func TestShardIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		keys int
+		// minShards is the least number of shards the keys must spread over
+		minShards int
+	}{
+		{"few keys", 8, 4},
+		{"many keys", 10000, dbShards},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make(map[int]int)
+			for i := 0; i < tt.keys; i++ {
+				key := "key:" + strconv.Itoa(i)
+				index := shardIndex(key)
+				if index < 0 || index >= dbShards {
+					t.Fatalf("shardIndex(%q) = %d, out of range", key, index)
+				}
+				if again := shardIndex(key); again != index {
+					t.Fatalf("shardIndex(%q) = %d then %d", key, index, again)
+				}
+				counts[index]++
+			}
+			if len(counts) < tt.minShards {
+				t.Errorf("%d keys hashed to %d shards, want at least %d", tt.keys, len(counts), tt.minShards)
+			}
+		})
+	}
+}
+
+func TestShardsConcurrentUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		// keys is the number of keys the workers share
+		keys int
+	}{
+		{"contended keys", 8, 4},
+		{"spread keys", 8, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := newMemoryStorage(0), newMemoryStorage(1)
+			var wg sync.WaitGroup
+			for w := 0; w < tt.workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 2000; i++ {
+						key := "key:" + strconv.Itoa((w*7+i)%tt.keys)
+						other := "key:" + strconv.Itoa((w*13+i)%tt.keys)
+						switch i % 6 {
+						case 0, 1:
+							src.Write(key, []byte(strconv.Itoa(i)))
+						case 2:
+							src.Read(key)
+						case 3:
+							src.DeleteKeys(key, other)
+						case 4:
+							// Moves in both directions can't deadlock
+							if w%2 == 0 {
+								src.Move(dst, key)
+							} else {
+								dst.Move(src, key)
+							}
+						case 5:
+							src.Delete(key)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			checkShards(t, src)
+			checkShards(t, dst)
+			if size := len(src.Keys(func(DBKey) bool { return true })); size != src.Size() {
+				t.Errorf("Keys returned %d keys, Size %d", size, src.Size())
+			}
+		})
+	}
+}
+
+func TestDeleteKeysAcrossShards(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []string
+		delete []string
+		want   int
+	}{
+		{"no keys", nil, []string{"a", "b"}, 0},
+		{"one shard", []string{"a"}, []string{"a", "a"}, 1},
+		{"several shards", []string{"a", "b", "c", "d"}, []string{"a", "c", "missing", "d"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMemoryStorage(0)
+			for _, key := range tt.stored {
+				db.Write(key, []byte("v"))
+			}
+			if got := db.DeleteKeys(tt.delete...); got != tt.want {
+				t.Errorf("DeleteKeys(%q) = %d, want %d", tt.delete, got, tt.want)
+			}
+			if size := db.Size(); size != len(tt.stored)-tt.want {
+				t.Errorf("%d keys left, want %d", size, len(tt.stored)-tt.want)
+			}
+			checkShards(t, db)
+		})
+	}
+}
+
+func BenchmarkConcurrentWrites(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		keys int
+	}{
+		{"one key", 1},
+		{"many keys", 1 << 16},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			db := newMemoryStorage(0)
+			keys := make([]DBKey, bm.keys)
+			for i := range keys {
+				keys[i] = "key:" + strconv.Itoa(i)
+			}
+			value := []byte("value")
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						db.Read(key)
+					} else {
+						db.Write(key, value)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// debug is a container command for the subcommands that help testing the server.
+// `DEBUG POPULATE count [prefix] [size]` creates count keys named key:N, or prefix:N,
+// whose values are value:N or, if size is given, size random bytes. The keys that
+// already exist are left untouched. The keys are written directly in the database,
+// in batches, so millions of them take seconds. https://redis.io/commands/debug/
+func (s *Server) debug(c *Client, args [][]byte) Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "populate":
+		if len(args) < 2 || len(args) > 4 {
+			return wrongNumArgsReply("debug|populate")
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return resp.Error("ERR count is out of range")
+		}
+		prefix := "key"
+		if len(args) > 2 {
+			prefix = string(args[2])
+		}
+		value := func(i int) []byte {
+			return []byte("value:" + strconv.Itoa(i))
+		}
+		if len(args) > 3 {
+			size, err := strconv.Atoi(string(args[3]))
+			if err != nil || size < 0 || size > resp.MaxBulkLen {
+				return resp.Error("ERR size is out of range")
+			}
+			value = randomValues(size)
+		}
+		if c.db.maxKeySize > 0 && len(prefix)+1+len(strconv.Itoa(count)) > c.db.maxKeySize {
+			return keyTooLargeReply
+		}
+		c.db.Populate(count, prefix, value)
+		return okReply
+	}
+	return errorf("ERR unknown subcommand '%s'. Try DEBUG HELP.", args[0])
+}
+
+// randomValues returns a function generating values of size random bytes. The values
+// are carved out of larger chunks, so generating them doesn't allocate each time.
+func randomValues(size int) func(i int) []byte {
+	if size == 0 {
+		return func(i int) []byte { return []byte{} }
+	}
+	perChunk := 64 * 1024 / size
+	if perChunk == 0 {
+		perChunk = 1
+	}
+	var chunk []byte
+	return func(i int) []byte {
+		if len(chunk) == 0 {
+			chunk = make([]byte, perChunk*size)
+			rand.Read(chunk)
+		}
+		v := chunk[:size:size]
+		chunk = chunk[size:]
+		return v
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestDebugPopulate(t *testing.T) {
+	for _, engine := range []string{"memory", "disk"} {
+		t.Run(engine, func(t *testing.T) {
+			opts := Options{}
+			if engine == "disk" {
+				opts.Storage = openTestDiskStorage(t).Database
+			}
+			_, addr := newTestServer(t, opts)
+			c := dialTest(t, addr)
+
+			c.expect(okReply, "SET", "key:1", "mine")
+			c.expect(okReply, "DEBUG", "POPULATE", "3")
+			c.expect(resp.Int(3), "DBSIZE")
+			c.expect(resp.BulkString("value:0"), "GET", "key:0")
+			c.expect(resp.BulkString("mine"), "GET", "key:1")
+			c.expect(resp.BulkString("value:2"), "GET", "key:2")
+			c.expect(resp.Null{}, "GET", "key:3")
+
+			// Populating again only adds the missing keys
+			c.expect(okReply, "debug", "populate", "5")
+			c.expect(resp.Int(5), "DBSIZE")
+			c.expect(resp.BulkString("mine"), "GET", "key:1")
+			c.expect(resp.BulkString("value:4"), "GET", "key:4")
+
+			c.expect(okReply, "DEBUG", "POPULATE", "2", "item")
+			c.expect(resp.BulkString("value:1"), "GET", "item:1")
+			c.expect(okReply, "DEBUG", "POPULATE", "100", "random", "10")
+			for i := 0; i < 100; i++ {
+				v := c.do("GET", "random:"+strconv.Itoa(i))
+				if b, ok := v.(resp.BulkString); !ok || len(b) != 10 {
+					t.Fatalf("GET random:%d = %q, want 10 bytes", i, v)
+				}
+			}
+			c.expect(okReply, "DEBUG", "POPULATE", "1", "empty", "0")
+			c.expect(resp.BulkString(""), "GET", "empty:0")
+			c.expect(okReply, "DEBUG", "POPULATE", "0")
+			c.expect(resp.Int(108), "DBSIZE")
+
+			// Only the selected database is populated
+			c.expect(okReply, "SELECT", "1")
+			c.expect(resp.Int(0), "DBSIZE")
+			c.expect(okReply, "DEBUG", "POPULATE", "10")
+			c.expect(resp.Int(10), "DBSIZE")
+			c.expect(okReply, "SELECT", "0")
+			c.expect(resp.Int(108), "DBSIZE")
+		})
+	}
+}
+
+func TestDebugPopulateManyKeys(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	// More keys than a batch of populateBatch
+	n := populateBatch*2 + 10
+	c.expect(okReply, "DEBUG", "POPULATE", strconv.Itoa(n))
+	c.expect(resp.Int(n), "DBSIZE")
+	last := strconv.Itoa(n - 1)
+	c.expect(resp.BulkString("value:"+last), "GET", "key:"+last)
+}
+
+func TestDebugErrors(t *testing.T) {
+	_, addr := newTestServer(t, Options{Storage: openTestDiskStorage(t).Database})
+	c := dialTest(t, addr)
+	tests := []struct {
+		args []string
+		want resp.Reply
+	}{
+		{[]string{"POPULATE"}, wrongNumArgsReply("debug|populate")},
+		{[]string{"POPULATE", "1", "key", "10", "extra"}, wrongNumArgsReply("debug|populate")},
+		{[]string{"POPULATE", "-1"}, resp.Error("ERR count is out of range")},
+		{[]string{"POPULATE", "many"}, resp.Error("ERR count is out of range")},
+		{[]string{"POPULATE", "1", "key", "-1"}, resp.Error("ERR size is out of range")},
+		{[]string{"POPULATE", "1", "key", "big"}, resp.Error("ERR size is out of range")},
+		{[]string{"POPULATE", "1", "key", strconv.Itoa(resp.MaxBulkLen + 1)}, resp.Error("ERR size is out of range")},
+		{[]string{"POPULATE", "1", strings.Repeat("k", 40000)}, keyTooLargeReply},
+		{[]string{"NOSUCH"}, resp.Error("ERR unknown subcommand 'NOSUCH'. Try DEBUG HELP.")},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, append([]string{"DEBUG"}, tt.args...)...)
+	}
+	c.expect(wrongNumArgsReply("debug"), "DEBUG")
+	c.expect(resp.Int(0), "DBSIZE")
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DiskStorageFile is the name of the file of a DiskStorage, in the directory given
+// to OpenDiskStorage.
+const DiskStorageFile = "storage.bolt"
+
+// bbolt doesn't accept empty keys, so every key is stored after diskKeyPrefix.
+const diskKeyPrefix byte = 'k'
+
+// The type of a value stored on disk, written before it so other types can be added
+// without breaking the format.
+const diskTypeString byte = 's'
+
+// DiskStorage keeps the keys of every logical database in a bbolt file, one bucket
+// per database, so the dataset can grow larger than the memory at the cost of the
+// latency of reading from the disk. Writes are serialized by bbolt, while reads run
+// concurrently with them.
+// The disk only extends the memory: as with the in-memory storage the keys don't
+// outlive the server, so the file is recreated when it's opened and it isn't synced.
+// Keys are limited to MaxKeySize bytes, the write commands refuse longer ones. I/O
+// errors can't be recovered from, they panic, which fails the command with an error
+// reply and closes the connection of the client.
+type DiskStorage struct {
+	db *bolt.DB
+}
+
+// OpenDiskStorage creates the file of a DiskStorage in dir, replacing any previous
+// one. It must be closed once the server has shut down.
+func OpenDiskStorage(dir string) (*DiskStorage, error) {
+	path := dir + string(os.PathSeparator) + DiskStorageFile
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		// Another server using the same file fails to start instead of hanging
+		Timeout:        time.Second,
+		NoSync:         true,
+		NoFreelistSync: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &DiskStorage{db: db}, nil
+}
+
+// Database returns the Storage of the logical database id, it can be passed as
+// Options.Storage.
+func (d *DiskStorage) Database(id int) Storage {
+	return &diskBucket{db: d.db, name: []byte("db" + strconv.Itoa(id))}
+}
+
+// Close closes the file, once nothing uses the storages of the databases anymore.
+func (d *DiskStorage) Close() error {
+	return d.db.Close()
+}
+
+// diskBucket is the Storage of a logical database in a DiskStorage. The bucket is
+// only created by the first write, a missing bucket is an empty database.
+type diskBucket struct {
+	db   *bolt.DB
+	name []byte
+	// size is the number of keys, counting them would read the whole bucket
+	size int64
+}
+
+// MaxKeySize is the longest key bbolt accepts, after diskKeyPrefix.
+func (b *diskBucket) MaxKeySize() int {
+	return bolt.MaxKeySize - 1
+}
+
+func diskKey(key DBKey) []byte {
+	return append(append(make([]byte, 0, len(key)+1), diskKeyPrefix), key...)
+}
+
+func (b *diskBucket) view(fn func(bucket *bolt.Bucket)) {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(b.name); bucket != nil {
+			fn(bucket)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("disk storage: %w", err))
+	}
+}
+
+// update runs fn in a write transaction, adding the number of keys it returns to the
+// size while the transaction still serializes the writers, so that the size can't
+// be changed out of order.
+func (b *diskBucket) update(fn func(bucket *bolt.Bucket) (added int64, err error)) {
+	var added int64
+	counted := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(b.name)
+		if err != nil {
+			return err
+		}
+		if added, err = fn(bucket); err != nil {
+			return err
+		}
+		atomic.AddInt64(&b.size, added)
+		counted = true
+		return nil
+	})
+	if err != nil {
+		if counted {
+			// The commit failed after the size was changed
+			atomic.AddInt64(&b.size, -added)
+		}
+		panic(fmt.Errorf("disk storage: %w", err))
+	}
+}
+
+func encodeDiskValue(value []byte) []byte {
+	return append(append(make([]byte, 0, len(value)+1), diskTypeString), value...)
+}
+
+// decodeDiskValue copies the value out of the page of bbolt, which is only valid
+// during the transaction.
+func decodeDiskValue(data []byte) []byte {
+	if len(data) == 0 || data[0] != diskTypeString {
+		panic(fmt.Errorf("disk storage: invalid value %q", data))
+	}
+	value := make([]byte, len(data)-1)
+	copy(value, data[1:])
+	return value
+}
+
+func (b *diskBucket) Read(key DBKey) (v []byte, ok bool) {
+	b.view(func(bucket *bolt.Bucket) {
+		if data := bucket.Get(diskKey(key)); data != nil {
+			v, ok = decodeDiskValue(data), true
+		}
+	})
+	return
+}
+
+func (b *diskBucket) Write(key DBKey, value []byte) {
+	b.update(func(bucket *bolt.Bucket) (int64, error) {
+		k := diskKey(key)
+		added := int64(0)
+		if bucket.Get(k) == nil {
+			added = 1
+		}
+		return added, bucket.Put(k, encodeDiskValue(value))
+	})
+}
+
+func (b *diskBucket) Delete(key DBKey) {
+	b.DeleteKeys(key)
+}
+
+func (b *diskBucket) DeleteKeys(keys ...DBKey) int {
+	var count int64
+	b.update(func(bucket *bolt.Bucket) (int64, error) {
+		for _, key := range keys {
+			k := diskKey(key)
+			if bucket.Get(k) == nil {
+				continue
+			}
+			if err := bucket.Delete(k); err != nil {
+				return -count, err
+			}
+			count++
+		}
+		return -count, nil
+	})
+	return int(count)
+}
+
+// Move moves key to dst, a bucket of the same DiskStorage, in a single transaction.
+func (b *diskBucket) Move(dst Storage, key DBKey) bool {
+	other, ok := dst.(*diskBucket)
+	if !ok || other.db != b.db || other == b {
+		return false
+	}
+	k := diskKey(key)
+	moved := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		from := tx.Bucket(b.name)
+		if from == nil {
+			return nil
+		}
+		data := from.Get(k)
+		if data == nil {
+			return nil
+		}
+		to, err := tx.CreateBucketIfNotExists(other.name)
+		if err != nil {
+			return err
+		}
+		if to.Get(k) != nil {
+			return nil
+		}
+		// data belongs to the page of from, which Delete may change
+		if err := to.Put(k, append([]byte(nil), data...)); err != nil {
+			return err
+		}
+		if err := from.Delete(k); err != nil {
+			return err
+		}
+		moved = true
+		atomic.AddInt64(&b.size, -1)
+		atomic.AddInt64(&other.size, 1)
+		return nil
+	})
+	if err != nil {
+		if moved {
+			atomic.AddInt64(&b.size, 1)
+			atomic.AddInt64(&other.size, -1)
+		}
+		panic(fmt.Errorf("disk storage: %w", err))
+	}
+	return moved
+}
+
+// Flush deletes the bucket, in a single transaction whether async is set or not. The
+// file doesn't shrink, bbolt reuses the free pages.
+func (b *diskBucket) Flush(async bool) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(b.name)
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		atomic.StoreInt64(&b.size, 0)
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("disk storage: %w", err))
+	}
+}
+
+func (b *diskBucket) Size() int {
+	return int(atomic.LoadInt64(&b.size))
+}
+
+// RandomKey walks the keys up to one picked at random, bbolt can't seek to the Nth
+// key, so it's linear in the number of keys.
+func (b *diskBucket) RandomKey() (key DBKey, ok bool) {
+	b.view(func(bucket *bolt.Bucket) {
+		size := bucket.Stats().KeyN
+		if size == 0 {
+			return
+		}
+		index := rand.Intn(size)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if index == 0 {
+				key, ok = string(k[1:]), true
+				return
+			}
+			index--
+		}
+	})
+	return
+}
+
+// scan calls fn for the keys, and the values if withValues is set, in batches of
+// snapshotBatch keys each read with its own transaction, so that fn is called
+// outside of them and long iterations don't keep old pages from being reused. A
+// batch resumes after the last key of the previous one, so keys are visited once.
+func (b *diskBucket) scan(withValues bool, fn func(key DBKey, value []byte) bool) {
+	type entry struct {
+		key   DBKey
+		value []byte
+	}
+	batch := make([]entry, 0, snapshotBatch)
+	var last []byte
+	for {
+		batch = batch[:0]
+		b.view(func(bucket *bolt.Bucket) {
+			c := bucket.Cursor()
+			k, v := c.First()
+			if last != nil {
+				k, v = c.Seek(last)
+				if k != nil && string(k) == string(last) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(batch) < snapshotBatch; k, v = c.Next() {
+				e := entry{key: string(k[1:])}
+				if withValues {
+					e.value = decodeDiskValue(v)
+				}
+				batch = append(batch, e)
+			}
+		})
+		if len(batch) == 0 {
+			return
+		}
+		for _, e := range batch {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+		last = diskKey(batch[len(batch)-1].key)
+	}
+}
+
+func (b *diskBucket) Keys(match func(key DBKey) bool) []DBKey {
+	keys := []DBKey{}
+	b.scan(false, func(key DBKey, _ []byte) bool {
+		if match(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+func (b *diskBucket) Iterate(fn func(key DBKey, value []byte) bool) {
+	b.scan(true, fn)
+}
+
+// Populate inserts the keys with a transaction per batch of populateBatch keys.
+func (b *diskBucket) Populate(count int, prefix string, value func(i int) []byte) int {
+	added := int64(0)
+	for start := 0; start < count; start += populateBatch {
+		end := start + populateBatch
+		if end > count {
+			end = count
+		}
+		b.update(func(bucket *bolt.Bucket) (int64, error) {
+			n := int64(0)
+			for i := start; i < end; i++ {
+				key := diskKey(prefix + ":" + strconv.Itoa(i))
+				if bucket.Get(key) != nil {
+					continue
+				}
+				if err := bucket.Put(key, encodeDiskValue(value(i))); err != nil {
+					return n, err
+				}
+				n++
+			}
+			added += n
+			return n, nil
+		})
+	}
+	return int(added)
+}
+
+// UsedMemory is zero, the keys and values are on disk and the pages cached by the
+// operating system aren't counted.
+func (b *diskBucket) UsedMemory() int64 {
+	return 0
+}
+
+// KeyMemory returns the same estimate as the in-memory storage, so MEMORY USAGE
+// doesn't depend on the engine.
+func (b *diskBucket) KeyMemory(key DBKey) (size int64, ok bool) {
+	value, ok := b.Read(key)
+	if !ok {
+		return 0, false
+	}
+	return stringEntrySize(key, value), true
+}
@@ -0,0 +1,7 @@
+package server
+
+import "errors"
+
+var KeyDoesNotExist = errors.New("key does not exist")
+var errInvalidValue = errors.New("invalid stored value")
+var maxClientsReachedError = errors.New("max number of clients reached")
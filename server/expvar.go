@@ -0,0 +1,80 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The statistics of the servers running in the process are published as a single
+// expvar variable, expvar panics if the same name is published twice.
+var (
+	expvarOnce    sync.Once
+	expvarMu      sync.Mutex
+	expvarServers = map[*Server]struct{}{}
+)
+
+// publishExpvar adds the statistics of s to the "redis" expvar variable, which maps
+// the address of each server to its counters. The counters are the same INFO reads.
+func publishExpvar(s *Server) {
+	expvarOnce.Do(func() {
+		if expvar.Get("redis") == nil {
+			expvar.Publish("redis", expvar.Func(expvarStats))
+		}
+	})
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	expvarServers[s] = struct{}{}
+}
+
+func unpublishExpvar(s *Server) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	delete(expvarServers, s)
+}
+
+func expvarStats() interface{} {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	stats := make(map[string]interface{}, len(expvarServers))
+	for s := range expvarServers {
+		name := s.name()
+		if _, ok := stats[name]; ok {
+			name = fmt.Sprintf("%s (%p)", name, s)
+		}
+		stats[name] = s.expvarStats()
+	}
+	return stats
+}
+
+// name identifies the server by the addresses it listens on.
+func (s *Server) name() string {
+	addrs := append([]string{}, s.opts.Addrs...)
+	if s.opts.UnixSocket != "" {
+		addrs = append(addrs, s.opts.UnixSocket)
+	}
+	return strings.Join(addrs, ",")
+}
+
+func (s *Server) expvarStats() map[string]interface{} {
+	commands := make(map[string]int64)
+	for _, cmd := range s.sortedCommands() {
+		if calls := atomic.LoadInt64(&cmd.stats.calls); calls > 0 {
+			commands[cmd.name] = calls
+		}
+	}
+	return map[string]interface{}{
+		"connected_clients":          s.connectedClients(),
+		"total_connections_received": atomic.LoadInt64(&s.stats.connectionsReceived),
+		"total_commands_processed":   atomic.LoadInt64(&s.stats.commandsProcessed),
+		"commands":                   commands,
+		"keyspace_hits":              atomic.LoadInt64(&s.stats.keyspaceHits),
+		"keyspace_misses":            atomic.LoadInt64(&s.stats.keyspaceMisses),
+		"goroutines":                 runtime.NumGoroutine(),
+	}
+}
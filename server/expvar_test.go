@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+// expvarServer returns the counters published for the server named name, or nil.
+func expvarServer(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	v := expvar.Get("redis")
+	if v == nil {
+		t.Fatal("no redis expvar variable")
+	}
+	var servers map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(v.String()), &servers); err != nil {
+		t.Fatalf("invalid redis expvar variable %s: %v", v, err)
+	}
+	return servers[name]
+}
+
+func TestExpvar(t *testing.T) {
+	// Each server publishes under the same variable without expvar panicking
+	a, addrA := newTestServer(t, Options{Addrs: []string{"expvar-a:6379"}})
+	_, addrB := newTestServer(t, Options{Addrs: []string{"expvar-b:6379"}})
+
+	c := dialTest(t, addrA)
+	c.expect(okReply, "SET", "key", "value")
+	c.do("GET", "key")
+	c.do("GET", "key")
+	c.do("GET", "missing")
+	dialTest(t, addrB).do("PING")
+
+	tests := []struct {
+		server string
+		field  string
+		want   interface{}
+	}{
+		{"expvar-a:6379", "connected_clients", 1.0},
+		{"expvar-a:6379", "total_connections_received", 1.0},
+		{"expvar-a:6379", "total_commands_processed", 4.0},
+		{"expvar-a:6379", "keyspace_hits", 2.0},
+		{"expvar-a:6379", "keyspace_misses", 1.0},
+		{"expvar-a:6379", "commands", map[string]interface{}{"get": 3.0, "set": 1.0}},
+		{"expvar-b:6379", "total_commands_processed", 1.0},
+		{"expvar-b:6379", "commands", map[string]interface{}{"ping": 1.0}},
+	}
+	for _, tt := range tests {
+		stats := expvarServer(t, tt.server)
+		if stats == nil {
+			t.Fatalf("%s not published", tt.server)
+		}
+		if got := stats[tt.field]; !jsonEqual(got, tt.want) {
+			t.Errorf("%s %s = %v, want %v", tt.server, tt.field, got, tt.want)
+		}
+	}
+	// The counters are the ones INFO reports
+	for _, field := range []string{"total_commands_processed", "keyspace_hits", "keyspace_misses"} {
+		stats := expvarServer(t, "expvar-a:6379")
+		want := infoField(c, "stats", field)
+		if got, _ := json.Marshal(stats[field]); string(got) != want {
+			t.Errorf("expvar %s = %s, INFO %s", field, got, want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	a.Shutdown(ctx)
+	if stats := expvarServer(t, "expvar-a:6379"); stats != nil {
+		t.Errorf("the server is still published after shutting down: %v", stats)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
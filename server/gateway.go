@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// gatewayHandler serves the HTTP gateway enabled by HTTPAddr, for the clients that
+// can't speak RESP:
+//
+//	GET /v1/keys/{key}     returns the value of key, 404 if it doesn't exist
+//	PUT /v1/keys/{key}     sets key to the body of the request
+//	DELETE /v1/keys/{key}  deletes key, 404 if it doesn't exist
+//	POST /v1/command       executes the command in the body, a JSON array of strings,
+//	                       and returns its reply converted to JSON
+//
+// The database is selected with the db query parameter, 0 by default. Commands are
+// executed with ExecuteCommand, so they're counted in the statistics, audited and
+// invalidate the keys tracked by clients exactly as those sent over RESP. No password
+// can be set, so as for RESP connections, protected mode only accepts requests from
+// the loopback interface.
+func (s *Server) gatewayHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/", s.gatewayKey)
+	mux.HandleFunc("/v1/command", s.gatewayCommand)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.protectedMode() && !isLoopbackRemote(r.RemoteAddr) {
+			writeJSONError(w, http.StatusForbidden, string(protectedModeReply))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func isLoopbackRemote(remoteAddr string) bool {
+	addr, err := net.ResolveTCPAddr("tcp", remoteAddr)
+	return err == nil && isLoopbackAddr(addr)
+}
+
+func (s *Server) gatewayKey(w http.ResponseWriter, r *http.Request) {
+	// The escaped path is used so keys can contain slashes, as %2F
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/v1/keys/"))
+	if err != nil || key == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid key")
+		return
+	}
+	db, ok := s.gatewayDB(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		reply, err := s.ExecuteCommand(r.Context(), db, "get", key)
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		value, ok := reply.(resp.BulkString)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		w.Write(value)
+	case http.MethodPut:
+		value, err := io.ReadAll(io.LimitReader(r.Body, resp.MaxBulkLen+1))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(value) > resp.MaxBulkLen {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "value too large")
+			return
+		}
+		if _, err := s.ExecuteCommand(r.Context(), db, "set", key, string(value)); err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		reply, err := s.ExecuteCommand(r.Context(), db, "del", key)
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		if reply == resp.Int(0) {
+			writeJSONError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) gatewayCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	db, ok := s.gatewayDB(w, r)
+	if !ok {
+		return
+	}
+	var args []string
+	if err := json.NewDecoder(io.LimitReader(r.Body, resp.MaxBulkLen)).Decode(&args); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "the body must be a JSON array of strings: "+err.Error())
+		return
+	}
+	if len(args) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "no command given")
+		return
+	}
+
+	reply, err := s.ExecuteCommand(r.Context(), db, args...)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, replyToJSON(reply))
+}
+
+// gatewayDB returns the database selected by the db query parameter, writing the
+// error and returning false if it isn't a valid index.
+func (s *Server) gatewayDB(w http.ResponseWriter, r *http.Request) (int, bool) {
+	param := r.URL.Query().Get("db")
+	if param == "" {
+		return 0, true
+	}
+	db, err := strconv.Atoi(param)
+	if err != nil || db < 0 || db >= len(s.databases) {
+		writeJSONError(w, http.StatusBadRequest, "DB index is out of range")
+		return 0, false
+	}
+	return db, true
+}
+
+// writeCommandError writes the error returned by ExecuteCommand: error replies and
+// unknown commands are the fault of the client, anything else of the server.
+func writeCommandError(w http.ResponseWriter, err error) {
+	var replyErr resp.Error
+	switch {
+	case errors.As(err, &replyErr):
+		writeJSONError(w, http.StatusBadRequest, string(replyErr))
+	case errors.Is(err, ErrUnknownCommand):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// replyToJSON converts a reply to a value encoded by encoding/json: strings, numbers,
+// booleans, null for the missing values, arrays and objects for maps. Values that
+// JSON can't represent exactly, big numbers and infinite doubles, become strings.
+func replyToJSON(reply Reply) interface{} {
+	switch r := reply.(type) {
+	case resp.SimpleString:
+		return string(r)
+	case resp.BulkString:
+		return string(r)
+	case resp.Int:
+		return int64(r)
+	case resp.Double:
+		if math.IsInf(float64(r), 0) || math.IsNaN(float64(r)) {
+			return strconv.FormatFloat(float64(r), 'g', -1, 64)
+		}
+		return float64(r)
+	case resp.Boolean:
+		return bool(r)
+	case resp.BigNumber:
+		return string(r)
+	case resp.Verbatim:
+		return r.Text
+	case resp.Error:
+		return map[string]string{"error": string(r)}
+	case resp.Array:
+		return repliesToJSON(r)
+	case resp.Set:
+		return repliesToJSON(r)
+	case resp.Push:
+		return repliesToJSON(r)
+	case resp.Multi:
+		return repliesToJSON(r)
+	case resp.Map:
+		m := make(map[string]interface{}, len(r)/2)
+		for i := 0; i+1 < len(r); i += 2 {
+			key, ok := replyToJSON(r[i]).(string)
+			if !ok {
+				key = fmt.Sprint(replyToJSON(r[i]))
+			}
+			m[key] = replyToJSON(r[i+1])
+		}
+		return m
+	}
+	return nil
+}
+
+func repliesToJSON(replies []Reply) []interface{} {
+	values := make([]interface{}, len(replies))
+	for i, r := range replies {
+		values[i] = replyToJSON(r)
+	}
+	return values
+}
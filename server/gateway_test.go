@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestGateway(t *testing.T) {
+	const local = "127.0.0.1:1234"
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		remote string
+		// disabled turns protected mode off
+		disabled   bool
+		wantStatus int
+		wantBody   string
+		// check is a command run afterwards, with the reply it must get
+		check []string
+		want  resp.Reply
+	}{
+		{"get", "GET", "/v1/keys/existing", "", local, false, 200, "value", nil, nil},
+		{"get missing", "GET", "/v1/keys/missing", "", local, false, 404, `{"error":"key not found"}` + "\n", nil, nil},
+		{"get in a database", "GET", "/v1/keys/other?db=1", "", local, false, 200, "in db1", nil, nil},
+		{"get escaped key", "GET", "/v1/keys/a%2Fb%20c", "", local, false, 200, "slashed", nil, nil},
+		{
+			"put", "PUT", "/v1/keys/new", "new\x00value", local, false, 204, "",
+			[]string{"GET", "new"}, resp.BulkString("new\x00value"),
+		},
+		{
+			"put in a database", "PUT", "/v1/keys/new?db=2", "two", local, false, 204, "",
+			[]string{"GET", "new"}, resp.Null{},
+		},
+		{
+			"delete", "DELETE", "/v1/keys/existing", "", local, false, 204, "",
+			[]string{"EXISTS", "existing"}, resp.Int(0),
+		},
+		{"delete missing", "DELETE", "/v1/keys/missing", "", local, false, 404, `{"error":"key not found"}` + "\n", nil, nil},
+		{"invalid method", "POST", "/v1/keys/existing", "", local, false, 405, `{"error":"method not allowed"}` + "\n", nil, nil},
+		{"empty key", "GET", "/v1/keys/", "", local, false, 400, `{"error":"invalid key"}` + "\n", nil, nil},
+		{"invalid database", "GET", "/v1/keys/existing?db=99", "", local, false, 400, `{"error":"DB index is out of range"}` + "\n", nil, nil},
+		{"command", "POST", "/v1/command", `["INCR","counter"]`, local, false, 200, "1\n", nil, nil},
+		{"command with an array reply", "POST", "/v1/command", `["KEYS","exist*"]`, local, false, 200, `["existing"]` + "\n", nil, nil},
+		{"command with a null reply", "POST", "/v1/command", `["GET","missing"]`, local, false, 200, "null\n", nil, nil},
+		{
+			"command in a database", "POST", "/v1/command?db=3", `["SET","k","v"]`, local, false, 200, `"OK"` + "\n",
+			[]string{"EXISTS", "k"}, resp.Int(0),
+		},
+		{
+			"failing command", "POST", "/v1/command", `["INCR","existing"]`, local, false, 400,
+			`{"error":"ERR value is not an integer or out of range"}` + "\n", nil, nil,
+		},
+		{"unknown command", "POST", "/v1/command", `["NOSUCHCOMMAND"]`, local, false, 400, "", nil, nil},
+		{"empty command", "POST", "/v1/command", `[]`, local, false, 400, `{"error":"no command given"}` + "\n", nil, nil},
+		{"invalid body", "POST", "/v1/command", `{"cmd":"PING"}`, local, false, 400, "", nil, nil},
+		{"command with GET", "GET", "/v1/command", "", local, false, 405, `{"error":"method not allowed"}` + "\n", nil, nil},
+		{
+			"remote client", "PUT", "/v1/keys/existing", "changed", "192.0.2.1:1234", false, 403, "",
+			[]string{"GET", "existing"}, resp.BulkString("value"),
+		},
+		{"remote client without protected mode", "GET", "/v1/keys/existing", "", "192.0.2.1:1234", true, 200, "value", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{
+				Network:              "tcp",
+				Databases:            16,
+				DisableProtectedMode: tt.disabled,
+				Logger:               NewLogger(io.Discard, LogWarning),
+			})
+			ctx := context.Background()
+			for _, args := range [][]string{{"SET", "existing", "value"}, {"SET", "a/b c", "slashed"}} {
+				if _, err := s.ExecuteCommand(ctx, 0, args...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.ExecuteCommand(ctx, 1, "SET", "other", "in db1"); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.RemoteAddr = tt.remote
+			rec := httptest.NewRecorder()
+			s.gatewayHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body %q, want %q", rec.Body, tt.wantBody)
+			}
+			if rec.Code >= 400 && !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("error with Content-Type %q, want JSON", rec.Header().Get("Content-Type"))
+			}
+			if tt.check != nil {
+				reply, err := s.ExecuteCommand(ctx, 0, tt.check...)
+				if err != nil || !reflect.DeepEqual(reply, tt.want) {
+					t.Errorf("%q = %#v, %v, want %#v", tt.check, reply, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplyToJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+		want  interface{}
+	}{
+		{"simple string", resp.SimpleString("OK"), "OK"},
+		{"bulk string", resp.BulkString("value"), "value"},
+		{"int", resp.Int(-3), int64(-3)},
+		{"double", resp.Double(1.5), 1.5},
+		{"infinite double", resp.Double(math.Inf(1)), "+Inf"},
+		{"boolean", resp.Boolean(true), true},
+		{"big number", resp.BigNumber("12345678901234567890"), "12345678901234567890"},
+		{"verbatim", resp.Verbatim{Format: "txt", Text: "text"}, "text"},
+		{"null", resp.Null{}, nil},
+		{"error", resp.Error("ERR wrong"), map[string]string{"error": "ERR wrong"}},
+		{"array", resp.Array{resp.Int(1), resp.Null{}}, []interface{}{int64(1), nil}},
+		{"set", resp.Set{resp.BulkString("a")}, []interface{}{"a"}},
+		{
+			"map",
+			resp.Map{resp.BulkString("a"), resp.Int(1), resp.Int(2), resp.BulkString("b")},
+			map[string]interface{}{"a": int64(1), "2": "b"},
+		},
+	}
+	for _, tt := range tests {
+		if got := replyToJSON(tt.reply); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: replyToJSON = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGatewayServed(t *testing.T) {
+	addr := freeAddr(t)
+	s := New(Options{
+		Network:   "tcp",
+		Databases: 16,
+		HTTPAddr:  addr,
+		Logger:    NewLogger(io.Discard, LogWarning),
+	})
+	if err := s.listenHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	client := &http.Client{Timeout: testTimeout}
+	req, _ := http.NewRequest(http.MethodPut, "http://"+addr+"/v1/keys/key", strings.NewReader("value"))
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT status %d, want 204", res.StatusCode)
+	}
+	res, err = client.Get("http://" + addr + "/v1/keys/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "value" {
+		t.Errorf("GET = %d %q, want 200 \"value\"", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+// stringMatch reports whether str matches the glob-style pattern, with the syntax
+// used by KEYS and the other commands taking a pattern:
+//     h?llo matches hello, hallo and hxllo
+//     h*llo matches hllo and heeeello
+//     h[ae]llo matches hello and hallo, but not hillo
+//     h[^e]llo matches hallo, hbllo, ... but not hello
+//     h[a-b]llo matches hallo and hbllo
+// Use \ to escape the special characters. Unlike path.Match, * also matches /.
+func stringMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if stringMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], str[0])
+			if !matched || rest == "" {
+				return false
+			}
+			// rest starts at the closing bracket
+			pattern = rest
+			str = str[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern, after
+// the opening bracket. It returns the pattern starting at the closing bracket, or at
+// its last character if the class isn't closed.
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case pattern[0] == ']':
+			return matched != not, pattern
+		case len(pattern) > 2 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case pattern[0] == c:
+			matched = true
+		}
+		if len(pattern) == 1 {
+			break
+		}
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"hello", "hello", true},
+		{"hello", "HELLO", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"?", "", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hellx", false},
+		{"*", "", true},
+		{"**a", "bba", true},
+		{"*a*b", "xaxb", true},
+		{"*a*b", "xbxa", false},
+		{"user:*", "user:1/profile", true},
+		{"*/*", "a/b", true},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{"[\\]]", "]", true},
+		{"[\\-]", "-", true},
+		{"[abc", "a", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"h\\?llo", "h?llo", true},
+		{"h\\?llo", "hello", false},
+		{"a\\", "a\\", true},
+		{"\x00*", "\x00\xff", true},
+	}
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("stringMatch(%q, %q) = %t, want %t", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestPatternCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want resp.Reply
+	}{
+		{"KEYS with slashes", []string{"KEYS", "user:*"}, resp.Array{resp.BulkString("user:1/profile")}},
+		{"KEYS with a class", []string{"KEYS", "[ab]"}, resp.Array{resp.BulkString("a")}},
+		{"KEYS escaped", []string{"KEYS", "\\*"}, resp.Array{}},
+		{
+			"CONFIG GET glob",
+			[]string{"CONFIG", "GET", "maxclient?"},
+			resp.Map{resp.BulkString("maxclients"), resp.BulkString("10000")},
+		},
+		{
+			"CONFIG GET case insensitive",
+			[]string{"CONFIG", "GET", "MAXCLIENTS"},
+			resp.Map{resp.BulkString("maxclients"), resp.BulkString("10000")},
+		},
+		{"CONFIG GET no match", []string{"CONFIG", "GET", "no-such-*"}, resp.Map{}},
+		{
+			"PUBSUB SHARDCHANNELS with slashes",
+			[]string{"PUBSUB", "SHARDCHANNELS", "news*"},
+			resp.Array{resp.BulkString("news/sport"), resp.BulkString("news/tech")},
+		},
+		{
+			"PUBSUB SHARDCHANNELS with a class",
+			[]string{"PUBSUB", "SHARDCHANNELS", "news/[s]*"},
+			resp.Array{resp.BulkString("news/sport")},
+		},
+	}
+	_, addr := newTestServer(t, Options{MaxClients: 10000})
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "user:1/profile", "1")
+	c.expect(okReply, "SET", "a", "1")
+	sub := dialTest(t, addr)
+	sub.send("SSUBSCRIBE", "news/sport", "news/tech")
+	sub.read()
+	sub.read()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// CONFIG GET replies with a map, sent as an array in RESP2
+			want := tt.want
+			if m, ok := want.(resp.Map); ok {
+				want = resp.Array(m)
+			}
+			if got := c.do(tt.args...); !reflect.DeepEqual(got, want) {
+				t.Errorf("%q = %#v, want %#v", tt.args, got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkStringMatch(b *testing.B) {
+	benchmarks := []struct {
+		pattern string
+		str     string
+	}{
+		{"user:*", "user:1234567890"},
+		{"*:profile", "user:1234567890:profile"},
+		{"user:[0-9]*:?rofile", "user:1234567890:profile"},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.pattern, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				stringMatch(bm.pattern, bm.str)
+			}
+		})
+	}
+}
+
+// BenchmarkKeysWhileWriting measures KEYS on a large database while other clients
+// write to it, the shards are only locked while their keys are copied.
+func BenchmarkKeysWhileWriting(b *testing.B) {
+	db := newMemoryStorage(0)
+	db.Populate(100000, "key", func(i int) []byte { return []byte("value") })
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value := []byte("value")
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			db.Write("key:"+strconv.Itoa(i%100000), value)
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Keys(func(key DBKey) bool {
+			return stringMatch("key:1*", key)
+		})
+	}
+	b.StopTimer()
+	close(stop)
+	<-done
+}
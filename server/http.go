@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// listenHTTP starts the HTTP servers enabled in the Options: the metrics on
+// MetricsAddr, the debugging endpoints, expvar and pprof, on DebugAddr and the
+// gateway on HTTPAddr. They are stopped by Shutdown along with the rest of the server.
+func (s *Server) listenHTTP() error {
+	if s.opts.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		if err := s.serveHTTP("metrics", s.opts.MetricsAddr, mux); err != nil {
+			return err
+		}
+	}
+	if s.opts.DebugAddr != "" {
+		if err := checkLoopback(s.opts.DebugAddr); err != nil && !s.opts.DebugAllowRemote {
+			s.closeHTTP()
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		if err := s.serveHTTP("debug endpoints", s.opts.DebugAddr, mux); err != nil {
+			s.closeHTTP()
+			return err
+		}
+	}
+	if s.opts.HTTPAddr != "" {
+		if err := s.serveHTTP("HTTP gateway", s.opts.HTTPAddr, s.gatewayHandler()); err != nil {
+			s.closeHTTP()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(name, addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start listening for %s on %s: %w", name, addr, err)
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.httpServers = append(s.httpServers, srv)
+	s.mu.Unlock()
+
+	s.logger.Noticef("Serving %s on http://%s", name, ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Warningf("HTTP server for %s error: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+// checkLoopback returns an error unless addr is a loopback address, the debugging
+// endpoints expose the internals of the process and mustn't be public by mistake.
+func checkLoopback(addr string) error {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid debug address %s: %w", addr, err)
+	}
+	if tcpAddr.IP == nil || !tcpAddr.IP.IsLoopback() {
+		return fmt.Errorf("refusing to serve the debugging endpoints on the non-loopback address %s", addr)
+	}
+	return nil
+}
+
+// closeHTTP closes the HTTP servers immediately, it's used when the server fails
+// to start.
+func (s *Server) closeHTTP() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, srv := range s.httpServers {
+		srv.Close()
+	}
+	s.httpServers = nil
+}
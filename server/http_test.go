@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a loopback address with a port nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestCheckLoopback(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"127.0.0.1:6060", false},
+		{"127.0.0.2:6060", false},
+		{"[::1]:6060", false},
+		{"localhost:6060", false},
+		{"0.0.0.0:6060", true},
+		{":6060", true},
+		{"[::]:6060", true},
+		{"192.0.2.1:6060", true},
+		{"not an address", true},
+	}
+	for _, tt := range tests {
+		if err := checkLoopback(tt.addr); (err != nil) != tt.wantErr {
+			t.Errorf("checkLoopback(%q) = %v, want error %t", tt.addr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		addr        string
+		allowRemote bool
+		wantErr     bool
+	}{
+		{"loopback", "127.0.0.1", false, false},
+		{"all interfaces", "0.0.0.0", false, true},
+		{"all interfaces allowed", "0.0.0.0", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, port, _ := net.SplitHostPort(freeAddr(t))
+			s := New(Options{
+				Network:          "tcp",
+				Databases:        16,
+				DebugAddr:        net.JoinHostPort(tt.addr, port),
+				DebugAllowRemote: tt.allowRemote,
+				Logger:           NewLogger(io.Discard, LogWarning),
+			})
+			err := s.listenHTTP()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("listenHTTP succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenHTTP: %v", err)
+			}
+
+			// Without keep-alive the transport doesn't dial spare connections, which
+			// Shutdown waits for as they never send a request
+			client := &http.Client{
+				Timeout:   testTimeout,
+				Transport: &http.Transport{DisableKeepAlives: true},
+			}
+			base := "http://" + net.JoinHostPort("127.0.0.1", port)
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+				res, err := client.Get(base + path)
+				if err != nil {
+					t.Fatalf("GET %s: %v", path, err)
+				}
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					t.Errorf("GET %s: status %d, want 200", path, res.StatusCode)
+				}
+			}
+
+			// Shutdown stops the debugging endpoints too
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown: %v", err)
+			}
+			client.Timeout = time.Second
+			if res, err := client.Get(base + "/debug/vars"); err == nil {
+				res.Body.Close()
+				t.Error("debugging endpoints served after Shutdown")
+			}
+		})
+	}
+}
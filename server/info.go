@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// infoSection is a section of the INFO reply, it returns its fields formatted as
+// `field:value` lines. Sections that aren't part of the default ones are only
+// returned when requested by name, or with `all` or `everything`.
+type infoSection struct {
+	name       string
+	fields     func() string
+	nonDefault bool
+}
+
+func (s *Server) infoSections() []infoSection {
+	return []infoSection{
+		{"Clients", s.infoClients, false},
+		{"Memory", s.infoMemory, false},
+		{"Stats", s.infoStats, false},
+		{"Commandstats", s.infoCommandStats, true},
+		{"Keyspace", s.infoKeyspace, false},
+	}
+}
+
+func (s *Server) infoClients() string {
+	return fmt.Sprintf(
+		"connected_clients:%d\r\nmaxclients:%d\r\n",
+		s.connectedClients(),
+		atomic.LoadInt64(&s.maxClients),
+	)
+}
+
+// infoMemory reports the memory allocated by the process and the estimated memory
+// used by the keys, in total and for each database holding keys.
+func (s *Server) infoMemory() string {
+	var b strings.Builder
+	var dataset int64
+	for _, db := range s.databases {
+		dataset += db.UsedMemory()
+	}
+	fmt.Fprintf(&b, "used_memory:%d\r\nused_memory_dataset:%d\r\n", usedMemory(), dataset)
+	for _, db := range s.databases {
+		if used := db.UsedMemory(); used > 0 {
+			fmt.Fprintf(&b, "used_memory_db%d:%d\r\n", db.id, used)
+		}
+	}
+	return b.String()
+}
+
+func (s *Server) infoStats() string {
+	return fmt.Sprintf(
+		"total_connections_received:%d\r\ntotal_commands_processed:%d\r\n"+
+			"expired_keys:%d\r\nevicted_keys:%d\r\nkeyspace_hits:%d\r\nkeyspace_misses:%d\r\n"+
+			"audit_log_dropped_entries:%d\r\nclient_output_buffer_limit_disconnections:%d\r\n"+
+			"ip_rejected_connections:%d\r\nip_rate_limited_connections:%d\r\n",
+		atomic.LoadInt64(&s.stats.connectionsReceived),
+		atomic.LoadInt64(&s.stats.commandsProcessed),
+		atomic.LoadInt64(&s.stats.expiredKeys),
+		atomic.LoadInt64(&s.stats.evictedKeys),
+		atomic.LoadInt64(&s.stats.keyspaceHits),
+		atomic.LoadInt64(&s.stats.keyspaceMisses),
+		s.auditDropped(),
+		atomic.LoadInt64(&s.stats.outputBufferLimitDisconnections),
+		atomic.LoadInt64(&s.stats.ipRejectedConnections),
+		atomic.LoadInt64(&s.stats.ipRateLimitedConnections),
+	)
+}
+
+// infoCommandStats lists the commands that were called at least once.
+func (s *Server) infoCommandStats() string {
+	var b strings.Builder
+	for _, cmd := range s.sortedCommands() {
+		calls := atomic.LoadInt64(&cmd.stats.calls)
+		if calls == 0 {
+			continue
+		}
+		usec := atomic.LoadInt64(&cmd.stats.usec)
+		fmt.Fprintf(&b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			cmd.name, calls, usec, float64(usec)/float64(calls))
+	}
+	return b.String()
+}
+
+// infoKeyspace reports the number of keys of each database holding any, empty
+// databases are omitted. Keys can't have a TTL, so expires and avg_ttl are always 0.
+func (s *Server) infoKeyspace() string {
+	var b strings.Builder
+	for _, db := range s.databases {
+		if size := db.Size(); size > 0 {
+			fmt.Fprintf(&b, "db%d:keys=%d,expires=0,avg_ttl=0\r\n", db.id, size)
+		}
+	}
+	return b.String()
+}
+
+// usedMemory returns the number of bytes allocated on the heap.
+func usedMemory() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// info returns information and statistics about the server in a format that is
+// simple to parse by computers and easy to read by humans. The optional parameters
+// select the sections to return, by default the most common ones are returned.
+// https://redis.io/commands/info/
+func (s *Server) info(c *Client, args [][]byte) Reply {
+	selected := make(map[string]bool)
+	for _, arg := range args {
+		selected[strings.ToLower(string(arg))] = true
+	}
+	defaults := len(args) == 0 || selected["default"]
+	all := selected["all"] || selected["everything"]
+
+	var b strings.Builder
+	for _, section := range s.infoSections() {
+		included := all || (defaults && !section.nonDefault)
+		if !included && !selected[strings.ToLower(section.name)] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + section.name + "\r\n")
+		b.WriteString(section.fields())
+	}
+	return resp.BulkString(b.String())
+}
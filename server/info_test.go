@@ -0,0 +1,90 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestInfoKeyspace(t *testing.T) {
+	for _, engine := range []string{"memory", "disk"} {
+		t.Run(engine, func(t *testing.T) {
+			opts := Options{}
+			if engine == "disk" {
+				opts.Storage = openTestDiskStorage(t).Database
+			}
+			_, addr := newTestServer(t, opts)
+			c := dialTest(t, addr)
+			expectKeyspace := func(lines ...string) {
+				t.Helper()
+				want := "# Keyspace\r\n"
+				for _, line := range lines {
+					want += line + "\r\n"
+				}
+				c.expect(resp.BulkString(want), "INFO", "keyspace")
+			}
+
+			// Empty databases are omitted
+			expectKeyspace()
+			c.expect(okReply, "SET", "a", "1")
+			c.expect(okReply, "SET", "b", "2")
+			c.expect(okReply, "SET", "a", "3")
+			expectKeyspace("db0:keys=2,expires=0,avg_ttl=0")
+
+			c.expect(okReply, "SELECT", "12")
+			c.expect(okReply, "DEBUG", "POPULATE", "10")
+			expectKeyspace("db0:keys=2,expires=0,avg_ttl=0", "db12:keys=10,expires=0,avg_ttl=0")
+
+			c.expect(okReply, "SELECT", "0")
+			c.expect(resp.Int(1), "MOVE", "a", "3")
+			c.expect(resp.Int(1), "DEL", "b")
+			expectKeyspace("db3:keys=1,expires=0,avg_ttl=0", "db12:keys=10,expires=0,avg_ttl=0")
+
+			c.expect(okReply, "SELECT", "12")
+			c.expect(okReply, "FLUSHDB")
+			expectKeyspace("db3:keys=1,expires=0,avg_ttl=0")
+			c.expect(okReply, "FLUSHALL")
+			expectKeyspace()
+		})
+	}
+}
+
+func TestInfoSections(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "key", "value")
+	c.expect(okReply, "SET", "key", "value")
+
+	sections := func(args ...string) []string {
+		t.Helper()
+		info := string(c.do(append([]string{"INFO"}, args...)...).(resp.BulkString))
+		var names []string
+		for _, line := range strings.Split(info, "\r\n") {
+			if strings.HasPrefix(line, "# ") {
+				names = append(names, line[2:])
+			}
+		}
+		return names
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "Clients,Memory,Stats,Keyspace"},
+		{[]string{"default"}, "Clients,Memory,Stats,Keyspace"},
+		{[]string{"all"}, "Clients,Memory,Stats,Commandstats,Keyspace"},
+		{[]string{"everything"}, "Clients,Memory,Stats,Commandstats,Keyspace"},
+		{[]string{"KEYSPACE"}, "Keyspace"},
+		{[]string{"commandstats", "clients"}, "Clients,Commandstats"},
+		{[]string{"nosuchsection"}, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(sections(tt.args...), ","); got != tt.want {
+			t.Errorf("INFO %q sections = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+	if got := infoField(c, "default", "db0"); got != "keys=1,expires=0,avg_ttl=0" {
+		t.Errorf("db0 in the default INFO = %q", got)
+	}
+}
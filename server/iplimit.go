@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// maxClientsPerIPReply is sent to the clients refused by maxclients-per-ip, before
+// closing their connection.
+var maxClientsPerIPReply = resp.Error("ERR max number of clients from this address reached")
+
+// errMaxClientsPerIP is returned by admitConn for the connections over
+// maxclients-per-ip, which are told so with maxClientsPerIPReply.
+var errMaxClientsPerIP = errors.New("max number of clients from this address reached")
+
+// errAcceptRateLimited is returned by admitConn for the connections over the accept
+// rate of their address, which are closed without a reply.
+var errAcceptRateLimited = errors.New("accept rate of the address reached")
+
+// ipLimiter counts the connections from each address, to enforce
+// maxclients-per-ip, and holds the token buckets enforcing accept-rate-per-ip: each
+// address can open up to accept-rate-per-ip connections at once, then that many per
+// second.
+type ipLimiter struct {
+	mu      sync.Mutex
+	clients map[string]int
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPLimiter() *ipLimiter {
+	return &ipLimiter{
+		clients: make(map[string]int),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// connIP returns the address conn is limited by, the IP of TCP connections and
+// "unix" for the unix sockets, or "" if it's exempt from the limits.
+func (s *Server) connIP(conn net.Conn) string {
+	if atomic.LoadInt32(&s.ipLimitsExemptLocal) == 1 && isLocalConn(conn) {
+		return ""
+	}
+	if strings.HasPrefix(conn.LocalAddr().Network(), "unix") {
+		return "unix"
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// admitConn applies accept-rate-per-ip and maxclients-per-ip to a connection just
+// accepted. It returns the address the connection is counted for, to be passed to
+// releaseIP once it's closed, or "" if it's exempt. The rejected connections are
+// counted in the statistics.
+func (s *Server) admitConn(conn net.Conn) (string, error) {
+	ip := s.connIP(conn)
+	if ip == "" {
+		return "", nil
+	}
+	maxClients := atomic.LoadInt64(&s.maxClientsPerIP)
+	rate := float64(atomic.LoadInt64(&s.acceptRatePerIP))
+	now := s.clock.Now()
+
+	l := s.ipLimits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate > 0 {
+		b, ok := l.buckets[ip]
+		if !ok {
+			b = &tokenBucket{tokens: rate, last: now}
+			l.buckets[ip] = b
+		}
+		b.refill(now, rate)
+		if b.tokens < 1 {
+			atomic.AddInt64(&s.stats.ipRateLimitedConnections, 1)
+			return "", errAcceptRateLimited
+		}
+		b.tokens--
+	}
+	if maxClients > 0 && int64(l.clients[ip]) >= maxClients {
+		atomic.AddInt64(&s.stats.ipRejectedConnections, 1)
+		return "", errMaxClientsPerIP
+	}
+	// The connections are counted even without a limit, so that setting one at
+	// runtime applies to the clients already connected
+	l.clients[ip]++
+	return ip, nil
+}
+
+// releaseIP stops counting a connection from ip, once it's closed.
+func (s *Server) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+	l := s.ipLimits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.clients[ip] <= 1 {
+		delete(l.clients, ip)
+	} else {
+		l.clients[ip]--
+	}
+}
+
+// pruneBuckets forgets the addresses whose bucket is full again, so the buckets of
+// the addresses that stopped connecting don't pile up. It's called by clientsCron.
+func (s *Server) pruneBuckets(now time.Time) {
+	rate := float64(atomic.LoadInt64(&s.acceptRatePerIP))
+
+	l := s.ipLimits
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ip, b := range l.buckets {
+		if rate == 0 || b.tokens+now.Sub(b.last).Seconds()*rate >= rate {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// refill adds the tokens earned since the last refill, up to rate.
+func (b *tokenBucket) refill(now time.Time, rate float64) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		b.last = now
+	}
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// addrConn is a connection with the given local and remote addresses.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr  { return c.local }
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestConnIP(t *testing.T) {
+	server := tcpAddr("10.0.0.1")
+	unix := &net.UnixAddr{Name: "/tmp/redis.sock", Net: "unix"}
+	tests := []struct {
+		name          string
+		local, remote net.Addr
+		exemptLocal   bool
+		want          string
+	}{
+		{"IPv4", server, tcpAddr("192.0.2.1"), false, "192.0.2.1"},
+		{"IPv6", server, tcpAddr("2001:db8::1"), false, "2001:db8::1"},
+		{"other port", server, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}, false, "192.0.2.1"},
+		{"loopback", server, tcpAddr("127.0.0.1"), false, "127.0.0.1"},
+		{"exempt loopback", server, tcpAddr("127.0.0.1"), true, ""},
+		{"exempt IPv6 loopback", server, tcpAddr("::1"), true, ""},
+		{"remote with local exempt", server, tcpAddr("192.0.2.1"), true, "192.0.2.1"},
+		{"unix socket", unix, unix, false, "unix"},
+		{"exempt unix socket", unix, unix, true, ""},
+		{"other address", server, stringAddr("192.0.2.7:1234"), false, "192.0.2.7"},
+	}
+	for _, tt := range tests {
+		s := New(Options{IPLimitsIncludeLocal: !tt.exemptLocal, Logger: NewLogger(io.Discard, LogWarning)})
+		if got := s.connIP(&addrConn{local: tt.local, remote: tt.remote}); got != tt.want {
+			t.Errorf("%s: connIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAdmitConn(t *testing.T) {
+	type attempt struct {
+		// elapsed is the time passed since the previous attempt
+		elapsed time.Duration
+		ip      string
+		// release closes the connection admitted by the attempt at this index
+		release int
+		wantErr error
+	}
+	const none = -1
+	tests := []struct {
+		name       string
+		maxClients int
+		rate       int
+		attempts   []attempt
+	}{
+		{"no limits", 0, 0, []attempt{
+			{0, "192.0.2.1", none, nil}, {0, "192.0.2.1", none, nil}, {0, "192.0.2.1", none, nil},
+		}},
+		{"max clients", 2, 0, []attempt{
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, errMaxClientsPerIP},
+			{0, "192.0.2.2", none, nil},
+			{0, "192.0.2.1", 0, nil},
+			{0, "192.0.2.1", none, errMaxClientsPerIP},
+		}},
+		{"accept rate", 0, 2, []attempt{
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, errAcceptRateLimited},
+			{0, "192.0.2.2", none, nil},
+			{250 * time.Millisecond, "192.0.2.1", none, errAcceptRateLimited},
+			{250 * time.Millisecond, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, errAcceptRateLimited},
+		}},
+		{"accept rate burst is capped", 0, 2, []attempt{
+			{time.Hour, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", none, errAcceptRateLimited},
+		}},
+		{"rate limited connections don't count as clients", 1, 1, []attempt{
+			{0, "192.0.2.1", none, nil},
+			{0, "192.0.2.1", 0, errAcceptRateLimited},
+			{time.Second, "192.0.2.1", none, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			s := New(Options{
+				MaxClientsPerIP: tt.maxClients,
+				AcceptRatePerIP: tt.rate,
+				Clock:           clock,
+				Logger:          NewLogger(io.Discard, LogWarning),
+			})
+			admitted := make([]string, len(tt.attempts))
+			var rejected, limited int64
+			for i, a := range tt.attempts {
+				clock.advance(a.elapsed)
+				if a.release != none {
+					s.releaseIP(admitted[a.release])
+				}
+				ip, err := s.admitConn(&addrConn{local: tcpAddr("10.0.0.1"), remote: tcpAddr(a.ip)})
+				if err != a.wantErr {
+					t.Fatalf("attempt %d from %s: admitConn = %q, %v, want %v", i, a.ip, ip, err, a.wantErr)
+				}
+				switch err {
+				case nil:
+					if ip != a.ip {
+						t.Errorf("attempt %d counted for %q, want %q", i, ip, a.ip)
+					}
+				case errMaxClientsPerIP:
+					rejected++
+				case errAcceptRateLimited:
+					limited++
+				}
+				admitted[i] = ip
+			}
+			if s.stats.ipRejectedConnections != rejected || s.stats.ipRateLimitedConnections != limited {
+				t.Errorf("%d rejected and %d rate limited connections, want %d and %d",
+					s.stats.ipRejectedConnections, s.stats.ipRateLimitedConnections, rejected, limited)
+			}
+		})
+	}
+}
+
+func TestPruneBuckets(t *testing.T) {
+	clock := newFakeClock()
+	s := New(Options{AcceptRatePerIP: 10, Clock: clock, Logger: NewLogger(io.Discard, LogWarning)})
+	for _, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		ip, err := s.admitConn(&addrConn{local: tcpAddr("10.0.0.1"), remote: tcpAddr(ip)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.releaseIP(ip)
+	}
+	tests := []struct {
+		elapsed time.Duration
+		want    []string
+	}{
+		{0, []string{"192.0.2.1", "192.0.2.2"}},
+		// 192.0.2.2 spent one token, refilled in 100ms
+		{100 * time.Millisecond, []string{"192.0.2.1"}},
+		{100 * time.Millisecond, nil},
+	}
+	for i, tt := range tests {
+		clock.advance(tt.elapsed)
+		s.pruneBuckets(clock.Now())
+		if len(s.ipLimits.buckets) != len(tt.want) {
+			t.Fatalf("step %d: %d buckets, want %q", i, len(s.ipLimits.buckets), tt.want)
+		}
+		for _, ip := range tt.want {
+			if s.ipLimits.buckets[ip] == nil {
+				t.Errorf("step %d: bucket of %s pruned", i, ip)
+			}
+		}
+	}
+	if len(s.ipLimits.clients) != 0 {
+		t.Errorf("clients still counted after closing them: %v", s.ipLimits.clients)
+	}
+}
+
+func TestMaxClientsPerIP(t *testing.T) {
+	_, addr := newTestServer(t, Options{MaxClientsPerIP: 2, IPLimitsIncludeLocal: true})
+	first := dialTest(t, addr)
+	first.expect(resp.SimpleString("PONG"), "PING")
+	second := dialTest(t, addr)
+	second.expect(resp.SimpleString("PONG"), "PING")
+
+	refused := dialTest(t, addr)
+	if got := refused.read(); got != resp.Reply(maxClientsPerIPReply) {
+		t.Errorf("third client got %#v, want %#v", got, maxClientsPerIPReply)
+	}
+	refused.expectClosed()
+	if n := infoField(second, "stats", "ip_rejected_connections"); n != "1" {
+		t.Errorf("ip_rejected_connections:%s, want 1", n)
+	}
+
+	// Closing a client lets another one in
+	first.conn.Close()
+	waitFor(t, "the first client to be released", func() bool {
+		return strings.Count(string(second.do("CLIENT", "LIST").(resp.BulkString)), "\n") == 1
+	})
+	third := dialTest(t, addr)
+	third.expect(resp.SimpleString("PONG"), "PING")
+
+	// Raising the limit at runtime applies to the clients already connected
+	second.expect(okReply, "CONFIG", "SET", "maxclients-per-ip", "3")
+	dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+}
+
+func TestIPLimitsExemptLocal(t *testing.T) {
+	_, addr := newTestServer(t, Options{MaxClientsPerIP: 1})
+	for i := 0; i < 3; i++ {
+		dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+	}
+}
+
+func TestAcceptRatePerIP(t *testing.T) {
+	clock := newFakeClock()
+	_, addr := newTestServer(t, Options{AcceptRatePerIP: 2, IPLimitsIncludeLocal: true, Clock: clock})
+	first := dialTest(t, addr)
+	first.expect(resp.SimpleString("PONG"), "PING")
+	dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+
+	// The connections over the rate are closed without a reply
+	limited := dialTest(t, addr)
+	limited.send("PING")
+	limited.expectClosed()
+	if n := infoField(first, "stats", "ip_rate_limited_connections"); n != "1" {
+		t.Errorf("ip_rate_limited_connections:%s, want 1", n)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+}
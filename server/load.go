@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// LoadStats summarizes the commands executed by LoadCommands.
+type LoadStats struct {
+	// Commands is the number of commands executed.
+	Commands int
+	// Errors is the number of commands that replied with an error, or that don't
+	// exist.
+	Errors int
+}
+
+// LoadCommands executes the commands read from r, in the same RESP format clients
+// send, as redis-cli --pipe does, until EOF. The commands are executed as if a
+// client sent them on database 0 and their replies are discarded, the ones that
+// fail are logged and counted. A protocol error stops the loading, the error reports
+// the offset of the command that couldn't be parsed.
+func (s *Server) LoadCommands(r io.Reader) (LoadStats, error) {
+	var stats LoadStats
+	counter := &countingReader{r: r}
+	reader := resp.NewReader(counter)
+	defer reader.Release()
+	c, release := s.newInternalClient(s.databases[0])
+	defer release()
+
+	for {
+		offset := counter.n - int64(reader.Buffered())
+		args, err := reader.ReadCommand()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read the command at offset %d: %w", offset, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		stats.Commands++
+		cmd, ok := s.findCommand(args[0])
+		if !ok {
+			stats.Errors++
+			s.logger.Verbosef("Unknown command '%s' at offset %d", args[0], offset)
+			continue
+		}
+		reply, span := s.execute(c, cmd, args[1:])
+		if span != nil {
+			endCommandSpan(span, reply, 0)
+		}
+		if err, ok := reply.(resp.Error); ok {
+			stats.Errors++
+			s.logger.Verbosef("Command '%s' at offset %d failed: %s", cmd.name, offset, err)
+		}
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// encodeCommands encodes commands as clients send them.
+func encodeCommands(commands ...[]string) string {
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	return b.String()
+}
+
+func TestLoadCommands(t *testing.T) {
+	valid := encodeCommands([]string{"SET", "a", "1"}, []string{"INCR", "a"})
+	tests := []struct {
+		name      string
+		input     string
+		wantStats LoadStats
+		// wantErr is the start of the error, empty if there's none
+		wantErr string
+		// want are the values of a and b once loaded, resp.Null if missing
+		want []resp.Reply
+	}{
+		{"empty", "", LoadStats{}, "", []resp.Reply{resp.Null{}, resp.Null{}}},
+		{"commands", valid, LoadStats{Commands: 2}, "", []resp.Reply{resp.BulkString("2"), resp.Null{}}},
+		{"inline commands", "SET a 1\r\nSET b 2\n", LoadStats{Commands: 2}, "", []resp.Reply{resp.BulkString("1"), resp.BulkString("2")}},
+		{
+			"binary values",
+			encodeCommands([]string{"SET", "b", "\r\n\x00"}),
+			LoadStats{Commands: 1}, "", []resp.Reply{resp.Null{}, resp.BulkString("\r\n\x00")},
+		},
+		{
+			"failing commands",
+			encodeCommands([]string{"SET", "a", "x"}, []string{"INCR", "a"}, []string{"NOSUCHCOMMAND"}, []string{"GET"}, []string{"SET", "b", "1"}),
+			LoadStats{Commands: 5, Errors: 3}, "", []resp.Reply{resp.BulkString("x"), resp.BulkString("1")},
+		},
+		{
+			"protocol error",
+			valid + "*2\r\n$3\r\nGET\r\n$x\r\n",
+			LoadStats{Commands: 2}, fmt.Sprintf("failed to read the command at offset %d: ", len(valid)),
+			[]resp.Reply{resp.BulkString("2"), resp.Null{}},
+		},
+		{
+			"truncated command",
+			valid + "*2\r\n$3\r\nGET",
+			LoadStats{Commands: 2}, fmt.Sprintf("failed to read the command at offset %d: ", len(valid)),
+			[]resp.Reply{resp.BulkString("2"), resp.Null{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, Options{})
+			stats, err := s.LoadCommands(strings.NewReader(tt.input))
+			if stats != tt.wantStats {
+				t.Errorf("stats %+v, want %+v", stats, tt.wantStats)
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("LoadCommands: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Errorf("LoadCommands error %v, want %q", err, tt.wantErr)
+			}
+			for i, key := range []string{"a", "b"} {
+				got, _ := s.ExecuteCommand(context.Background(), 0, "GET", key)
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("%s = %#v, want %#v", key, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadCommands(b *testing.B) {
+	var input bytes.Buffer
+	const commands = 10000
+	for i := 0; i < commands; i++ {
+		input.WriteString(encodeCommands([]string{"SET", "key:" + strconv.Itoa(i), "value"}))
+	}
+	s, _ := newTestServer(b, Options{})
+	b.SetBytes(int64(input.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stats, err := s.LoadCommands(bytes.NewReader(input.Bytes()))
+		if err != nil || stats.Commands != commands {
+			b.Fatalf("LoadCommands = %+v, %v", stats, err)
+		}
+	}
+}
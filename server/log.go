@@ -0,0 +1,262 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel is the severity of a log message, messages below the level of the Logger
+// are discarded.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogVerbose
+	LogNotice
+	LogWarning
+)
+
+var logLevelNames = []string{"debug", "verbose", "notice", "warning"}
+
+// Every level is marked by a character in the log lines, as Redis does
+var logLevelMarks = []byte{'.', '-', '*', '#'}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the LogLevel named level, one of "debug", "verbose",
+// "notice" or "warning".
+func ParseLogLevel(level string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q", level)
+}
+
+// Logger writes log lines in the same format as Redis, prefixed by the process id,
+// the role, a timestamp with millisecond precision and the level:
+//     1234:M 15 Oct 2022 10:30:00.123 * Ready to accept connections
+// It's safe to use from multiple goroutines.
+// The messages can be written to a file instead, set with SetFile, which is reopened
+// by Reopen and optionally rotated by the Logger itself once it grows too large. If
+// writing to the file fails, the messages are written to the writer given to
+// NewLogger rather than being lost.
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	pid   int
+	level int32
+	buf   []byte
+
+	file     *os.File
+	path     string
+	size     int64
+	maxSize  int64
+	keep     int
+	failures int
+}
+
+// NewLogger creates a Logger that writes the messages at level or above to out.
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	return &Logger{
+		out:   out,
+		pid:   os.Getpid(),
+		level: int32(level),
+	}
+}
+
+// Level returns the minimum level of the messages that are logged.
+func (l *Logger) Level() LogLevel {
+	return LogLevel(atomic.LoadInt32(&l.level))
+}
+
+// SetLevel changes the minimum level of the messages that are logged.
+func (l *Logger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Enabled reports whether messages at level are logged, it can be used to skip
+// building expensive messages.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.Level()
+}
+
+// Logf logs a message at level, formatted as with fmt.Sprintf.
+func (l *Logger) Logf(level LogLevel, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := fmt.Sprintf(format, v...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = l.buf[:0]
+	l.buf = strconv.AppendInt(l.buf, int64(l.pid), 10)
+	l.buf = append(l.buf, ":M "...)
+	l.buf = now.AppendFormat(l.buf, "02 Jan 2006 15:04:05.000")
+	l.buf = append(l.buf, ' ', logLevelMarks[level], ' ')
+	l.buf = append(l.buf, msg...)
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		l.buf = append(l.buf, '\n')
+	}
+	l.write(l.buf)
+}
+
+// write writes a line to the log file, or to out if there's no file or if writing
+// to it fails.
+func (l *Logger) write(line []byte) {
+	if l.file == nil {
+		l.out.Write(line)
+		return
+	}
+	if l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize {
+		l.rotate()
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.failures++
+		if l.failures == 1 {
+			fmt.Fprintf(l.out, "%d:M Failed writing to log file %s, logging here until it's reopened: %v\n", l.pid, l.path, err)
+		}
+		l.out.Write(line)
+		return
+	}
+	l.failures = 0
+}
+
+// SetFile writes the messages to the file at path, which is created if it doesn't
+// exist, instead of the writer given to NewLogger. An empty path goes back to it.
+func (l *Logger) SetFile(path string) error {
+	var f *os.File
+	var size int64
+	if path != "" {
+		var err error
+		f, size, err = openLogFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, l.path, l.size, l.failures = f, path, size, 0
+	return nil
+}
+
+// File returns the path of the log file, empty if the messages aren't written to a
+// file.
+func (l *Logger) File() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.path
+}
+
+// SetRotation makes the Logger rotate the log file once it exceeds maxSize bytes,
+// keeping keep old files, named after the log file with the suffixes .1, .2 and so on,
+// .1 being the most recent. A zero maxSize disables the rotation.
+func (l *Logger) SetRotation(maxSize int64, keep int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxSize, l.keep = maxSize, keep
+}
+
+// Reopen closes and reopens the log file, after it has been moved by a tool such as
+// logrotate. It does nothing if the messages aren't written to a file.
+func (l *Logger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.reopen()
+}
+
+// rotate shifts the old log files by one, dropping the oldest, and moves the current
+// file to the first position. The new file is opened first, under a temporary name,
+// so that nothing is moved if it can't be created: the rotation is then retried once
+// another maxSize bytes are written, rather than on every line.
+func (l *Logger) rotate() {
+	tmp := l.path + ".rotating"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(l.out, "%d:M Failed rotating log file %s, keeping the current one: %v\n", l.pid, l.path, err)
+		l.size = 0
+		return
+	}
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if l.keep > 0 {
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		fmt.Fprintf(l.out, "%d:M Failed rotating log file %s, keeping the current one: %v\n", l.pid, l.path, err)
+		f.Close()
+		os.Remove(tmp)
+		l.size = 0
+		return
+	}
+	l.file.Close()
+	l.file, l.size, l.failures = f, 0, 0
+}
+
+func (l *Logger) reopen() error {
+	f, size, err := openLogFile(l.path)
+	if err != nil {
+		fmt.Fprintf(l.out, "%d:M Failed reopening log file %s, keeping the current one: %v\n", l.pid, l.path, err)
+		return err
+	}
+	l.file.Close()
+	l.file, l.size, l.failures = f, size, 0
+	return nil
+}
+
+func openLogFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.Logf(LogDebug, format, v...)
+}
+
+func (l *Logger) Verbosef(format string, v ...interface{}) {
+	l.Logf(LogVerbose, format, v...)
+}
+
+func (l *Logger) Noticef(format string, v ...interface{}) {
+	l.Logf(LogNotice, format, v...)
+}
+
+func (l *Logger) Warningf(format string, v ...interface{}) {
+	l.Logf(LogWarning, format, v...)
+}
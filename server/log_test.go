@@ -0,0 +1,249 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// newFileLogger returns a Logger writing to a file in a temporary directory, and what
+// it writes to the writer of NewLogger.
+func newFileLogger(t *testing.T) (*Logger, string, *syncBuffer) {
+	t.Helper()
+	out := &syncBuffer{}
+	l := NewLogger(out, LogNotice)
+	path := filepath.Join(t.TempDir(), "redis.log")
+	if err := l.SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.SetFile("") })
+	return l, path, out
+}
+
+// readLog returns the lines of the log file at path, without their prefix.
+func readLog(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		// The prefix ends with the level mark, e.g. "1234:M 15 Oct 2022 10:30:00.123 * "
+		msgs = append(msgs, strings.TrimSuffix(line[strings.Index(line, " * ")+3:], "\n"))
+	}
+	return msgs
+}
+
+func expectLog(t *testing.T, path string, want ...string) {
+	t.Helper()
+	if got := readLog(t, path); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("%s holds %q, want %q", filepath.Base(path), got, want)
+	}
+}
+
+func TestLoggerSetFile(t *testing.T) {
+	l, path, out := newFileLogger(t)
+	l.Noticef("first")
+	l.Verbosef("discarded")
+	if got := l.File(); got != path {
+		t.Errorf("File() = %q, want %q", got, path)
+	}
+
+	// The messages are appended to an existing file
+	if err := l.SetFile(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("second")
+	expectLog(t, path, "first", "second")
+
+	// A file that can't be opened keeps the current one
+	if err := l.SetFile(filepath.Join(path, "redis.log")); err == nil {
+		t.Error("SetFile of a path under a file succeeded")
+	}
+	l.Noticef("third")
+	expectLog(t, path, "first", "second", "third")
+
+	if err := l.SetFile(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.File(); got != "" {
+		t.Errorf("File() = %q after going back to the writer, want empty", got)
+	}
+	l.Noticef("fourth")
+	expectLog(t, path, "first", "second", "third")
+	if got := out.String(); !strings.HasSuffix(got, " * fourth\n") || strings.Count(got, "\n") != 1 {
+		t.Errorf("writer got %q, want only the fourth message", got)
+	}
+}
+
+func TestLoggerReopen(t *testing.T) {
+	l, path, out := newFileLogger(t)
+	l.Noticef("before")
+	// As logrotate does, the file is moved and the server told to reopen it
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("moved")
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("after")
+	expectLog(t, path+".old", "before", "moved")
+	expectLog(t, path, "after")
+
+	// If the file can't be reopened the messages still go to the current one
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err == nil {
+		t.Error("Reopen succeeded with a directory in place of the file")
+	}
+	l.Noticef("kept")
+	expectLog(t, path+".old", "after", "kept")
+	if got := out.String(); !strings.Contains(got, "Failed reopening log file") {
+		t.Errorf("writer got %q, want the reopening failure", got)
+	}
+
+	// Without a file there's nothing to reopen
+	if err := NewLogger(out, LogNotice).Reopen(); err != nil {
+		t.Errorf("Reopen without a file = %v", err)
+	}
+}
+
+func TestLoggerRotation(t *testing.T) {
+	l, path, _ := newFileLogger(t)
+	l.Noticef("0")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every file holds two lines, as all of them have the same length
+	l.SetRotation(2*info.Size(), 2)
+	for _, msg := range []string{"1", "2", "3", "4", "5", "6"} {
+		l.Noticef(msg)
+	}
+	expectLog(t, path, "6")
+	expectLog(t, path+".1", "4", "5")
+	expectLog(t, path+".2", "2", "3")
+	for _, name := range []string{path + ".3", path + ".rotating"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s exists: %v", filepath.Base(name), err)
+		}
+	}
+
+	// Without old files to keep the current one is truncated
+	l.SetRotation(2*info.Size(), 0)
+	l.Noticef("7")
+	l.Noticef("8")
+	expectLog(t, path, "8")
+	expectLog(t, path+".1", "4", "5")
+
+	l.SetRotation(0, 2)
+	for _, msg := range []string{"9", "10", "11"} {
+		l.Noticef(msg)
+	}
+	expectLog(t, path, "8", "9", "10", "11")
+}
+
+func TestLoggerRotationFailure(t *testing.T) {
+	l, path, out := newFileLogger(t)
+	l.Noticef("0")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetRotation(2*info.Size(), 2)
+	// The new file can't be created, permissions don't stop root
+	if err := os.Mkdir(path+".rotating", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"1", "2", "3"} {
+		l.Noticef(msg)
+	}
+	// Nothing was moved, and the rotation is only retried after maxSize more bytes
+	expectLog(t, path, "0", "1", "2", "3")
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("%s.1 exists: %v", filepath.Base(path), err)
+	}
+	if n := strings.Count(out.String(), "Failed rotating log file"); n != 1 {
+		t.Errorf("writer got %q, want a single rotation failure", out.String())
+	}
+
+	if err := os.Remove(path + ".rotating"); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("4")
+	expectLog(t, path, "4")
+	expectLog(t, path+".1", "0", "1", "2", "3")
+}
+
+func TestLoggerFallback(t *testing.T) {
+	l, path, out := newFileLogger(t)
+	l.Noticef("written")
+	// The file is closed behind the Logger's back, writing to it fails
+	l.file.Close()
+	l.Noticef("lost 1")
+	l.Noticef("lost 2")
+	got := out.String()
+	if n := strings.Count(got, "Failed writing to log file"); n != 1 {
+		t.Errorf("writer got %q, want a single writing failure", got)
+	}
+	if !strings.Contains(got, " * lost 1\n") || !strings.Contains(got, " * lost 2\n") {
+		t.Errorf("writer got %q, want the messages that failed", got)
+	}
+
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Noticef("reopened")
+	expectLog(t, path, "written", "reopened")
+	if out.String() != got {
+		t.Errorf("writer got %q after reopening", strings.TrimPrefix(out.String(), got))
+	}
+}
+
+func TestConfigSetLogfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.log")
+	s, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	if err := s.ConfigSet("logfile", path); err != ErrConfigProtected {
+		t.Errorf("ConfigSet(logfile) = %v, want ErrConfigProtected", err)
+	}
+	c.expect(
+		resp.Error("ERR CONFIG SET failed (possibly related to argument 'logfile') - can't set protected config"),
+		"CONFIG", "SET", "logfile", path,
+	)
+	c.expect(resp.Array{resp.BulkString("logfile"), resp.BulkString("")}, "CONFIG", "GET", "logfile")
+	c.expect(resp.Array{resp.BulkString("enable-protected-configs"), resp.BulkString("no")}, "CONFIG", "GET", "enable-protected-configs")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("log file created: %v", err)
+	}
+
+	logger := NewLogger(&syncBuffer{}, LogNotice)
+	t.Cleanup(func() { logger.SetFile("") })
+	s, addr = newTestServer(t, Options{Logger: logger, EnableProtectedConfigs: true})
+	c = dialTest(t, addr)
+	c.expect(resp.Array{resp.BulkString("enable-protected-configs"), resp.BulkString("yes")}, "CONFIG", "GET", "enable-protected-configs")
+	c.expect(okReply, "CONFIG", "SET", "logfile", path)
+	c.expect(resp.Array{resp.BulkString("logfile"), resp.BulkString(path)}, "CONFIG", "GET", "logfile")
+	s.logger.Noticef("to the file")
+	expectLog(t, path, "to the file")
+	c.expect(
+		resp.Error("ERR CONFIG SET failed (possibly related to argument 'logfile') - failed to open log file: open "+path+"/redis.log: not a directory"),
+		"CONFIG", "SET", "logfile", path+"/redis.log",
+	)
+	c.expect(resp.Array{resp.BulkString("logfile"), resp.BulkString(path)}, "CONFIG", "GET", "logfile")
+	if err := s.ConfigSet("enable-protected-configs", "no"); err != ErrConfigNotSettable {
+		t.Errorf("ConfigSet(enable-protected-configs) = %v, want ErrConfigNotSettable", err)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// MetricsHandler returns an HTTP handler exposing the server statistics in the
+// Prometheus text format. ListenAndServe serves it on /metrics when MetricsAddr is
+// set, embedders can also mount it on their own HTTP server.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the server statistics to w in the Prometheus text format.
+// They are the same statistics reported by INFO.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	b := bufio.NewWriter(w)
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("redis_connected_clients", "gauge", "Number of client connections.")
+	fmt.Fprintf(b, "redis_connected_clients %d\n", s.connectedClients())
+	metric("redis_connections_received_total", "counter", "Total number of connections accepted by the server.")
+	fmt.Fprintf(b, "redis_connections_received_total %d\n", atomic.LoadInt64(&s.stats.connectionsReceived))
+	metric("redis_memory_used_bytes", "gauge", "Number of bytes allocated by the server.")
+	fmt.Fprintf(b, "redis_memory_used_bytes %d\n", usedMemory())
+	metric("redis_keyspace_hits_total", "counter", "Number of successful lookups of keys.")
+	fmt.Fprintf(b, "redis_keyspace_hits_total %d\n", atomic.LoadInt64(&s.stats.keyspaceHits))
+	metric("redis_keyspace_misses_total", "counter", "Number of failed lookups of keys.")
+	fmt.Fprintf(b, "redis_keyspace_misses_total %d\n", atomic.LoadInt64(&s.stats.keyspaceMisses))
+	metric("redis_expired_keys_total", "counter", "Number of keys removed because they expired.")
+	fmt.Fprintf(b, "redis_expired_keys_total %d\n", atomic.LoadInt64(&s.stats.expiredKeys))
+	metric("redis_evicted_keys_total", "counter", "Number of keys evicted because of the maxmemory limit.")
+	fmt.Fprintf(b, "redis_evicted_keys_total %d\n", atomic.LoadInt64(&s.stats.evictedKeys))
+
+	metric("redis_db_keys", "gauge", "Number of keys in each database.")
+	for _, db := range s.databases {
+		fmt.Fprintf(b, "redis_db_keys{db=\"db%d\"} %d\n", db.id, db.Size())
+	}
+
+	cmds := s.sortedCommands()
+	metric("redis_commands_processed_total", "counter", "Total number of commands processed, by command.")
+	for _, cmd := range cmds {
+		fmt.Fprintf(b, "redis_commands_processed_total{cmd=%q} %d\n", cmd.name, atomic.LoadInt64(&cmd.stats.calls))
+	}
+	metric("redis_command_duration_seconds", "histogram", "Time spent executing commands, by command.")
+	for _, cmd := range cmds {
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += atomic.LoadInt64(&cmd.stats.buckets[i])
+			le := strconv.FormatFloat(float64(bound)/1e6, 'g', -1, 64)
+			fmt.Fprintf(b, "redis_command_duration_seconds_bucket{cmd=%q,le=%q} %d\n", cmd.name, le, cumulative)
+		}
+		cumulative += atomic.LoadInt64(&cmd.stats.buckets[len(latencyBuckets)])
+		fmt.Fprintf(b, "redis_command_duration_seconds_bucket{cmd=%q,le=\"+Inf\"} %d\n", cmd.name, cumulative)
+		fmt.Fprintf(b, "redis_command_duration_seconds_sum{cmd=%q} %g\n", cmd.name, float64(atomic.LoadInt64(&cmd.stats.usec))/1e6)
+		fmt.Fprintf(b, "redis_command_duration_seconds_count{cmd=%q} %d\n", cmd.name, cumulative)
+	}
+
+	return b.Flush()
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// commands are sent before the metrics are scraped
+		commands [][]string
+		want     []string
+	}{
+		{
+			"idle server",
+			nil,
+			[]string{
+				"# TYPE redis_connected_clients gauge\n",
+				"redis_connected_clients 1\n",
+				"redis_connections_received_total 1\n",
+				"redis_keyspace_hits_total 0\n",
+				"redis_keyspace_misses_total 0\n",
+				"redis_db_keys{db=\"db0\"} 0\n",
+				"redis_db_keys{db=\"db15\"} 0\n",
+			},
+		},
+		{
+			"keyspace",
+			[][]string{
+				{"SET", "a", "1"}, {"SET", "b", "2"}, {"GET", "a"}, {"GET", "missing"},
+				{"SELECT", "3"}, {"SET", "c", "3"},
+			},
+			[]string{
+				"redis_keyspace_hits_total 1\n",
+				"redis_keyspace_misses_total 1\n",
+				"redis_db_keys{db=\"db0\"} 2\n",
+				"redis_db_keys{db=\"db3\"} 1\n",
+			},
+		},
+		{
+			"commands",
+			[][]string{{"PING"}, {"PING"}, {"SET", "a", "1"}},
+			[]string{
+				"# TYPE redis_commands_processed_total counter\n",
+				"redis_commands_processed_total{cmd=\"ping\"} 2\n",
+				"redis_commands_processed_total{cmd=\"set\"} 1\n",
+				"# TYPE redis_command_duration_seconds histogram\n",
+				"redis_command_duration_seconds_bucket{cmd=\"ping\",le=\"+Inf\"} 2\n",
+				"redis_command_duration_seconds_count{cmd=\"ping\"} 2\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			for _, args := range tt.commands {
+				if reply, ok := c.do(args...).(resp.Error); ok {
+					t.Fatalf("%q failed: %s", args, reply)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+				t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("metrics don't contain %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+// Command is a command being executed, as seen by a Middleware. It's only valid until
+// the handler returns, as Args are reused for the next command: middleware must copy
+// anything they retain.
+type Command struct {
+	// Name is the lowercase name of the command, as renamed by RenameCommand.
+	Name string
+	// Args are the arguments, the command name excluded.
+	Args [][]byte
+	// Flags are the flags the command was registered with.
+	Flags CommandFlags
+
+	cmd *command
+}
+
+// Keys returns the keys among Args, as reported by COMMAND. Commands registered with
+// RegisterCommand have no keys.
+func (cmd *Command) Keys() [][]byte {
+	return cmd.cmd.keys(cmd.Args)
+}
+
+// CommandHandler executes a command for a client and returns its reply.
+type CommandHandler func(c *Client, cmd *Command) Reply
+
+// Middleware wraps the execution of commands, for concerns such as logging, rate
+// limiting or metrics, without changing every handler. It returns the handler called
+// in place of next, which can run code before and after calling next, or reject the
+// command by replying without calling it, for example with a resp.Error.
+type Middleware func(next CommandHandler) CommandHandler
+
+// Use adds middleware around the execution of every command, the first one added
+// being the outermost. They see the commands sent over RESP, inline or not, through
+// the HTTP gateway, with ExecuteCommand and with LoadCommands, once the arity of the
+// command has been checked. A panic in a middleware is handled as in a command.
+// Use is meant to be called before serving: commands already running keep the
+// middleware they started with.
+func (s *Server) Use(middleware ...Middleware) {
+	s.commandsMu.Lock()
+	defer s.commandsMu.Unlock()
+
+	s.middleware = append(s.middleware, middleware...)
+	s.buildDispatch()
+}
+
+// buildDispatch chains the middleware added with Use around the built-in ones, which
+// record the statistics and the audit log of the commands, so that the commands
+// rejected by a middleware don't count as calls.
+// It must be called with commandsMu held.
+func (s *Server) buildDispatch() {
+	handler := CommandHandler(func(c *Client, cmd *Command) Reply {
+		return cmd.cmd.handler(c, cmd.Args)
+	})
+	handler = s.auditMiddleware(handler)
+	handler = s.commandStatsMiddleware(handler)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+	s.dispatch.Store(handler)
+}
+
+// commandStatsMiddleware records the calls and the duration of every command, as
+// reported by INFO commandstats.
+func (s *Server) commandStatsMiddleware(next CommandHandler) CommandHandler {
+	return func(c *Client, cmd *Command) Reply {
+		start := s.clock.Now()
+		reply := next(c, cmd)
+		cmd.cmd.stats.record(s.clock.Now().Sub(start))
+		return reply
+	}
+}
+
+// auditMiddleware records the write commands in the audit log, once it's opened by
+// ListenAndServe.
+func (s *Server) auditMiddleware(next CommandHandler) CommandHandler {
+	return func(c *Client, cmd *Command) Reply {
+		reply := next(c, cmd)
+		if s.audit != nil && cmd.Flags&FlagWrite != 0 {
+			s.audit.record(c, c.db.id, cmd.Name, cmd.Args)
+		}
+		return reply
+	}
+}
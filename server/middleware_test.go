@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// callLog records the calls of the middleware of a test.
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(call string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, call)
+}
+
+func (l *callLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+// tracing returns a Middleware logging name before and after the commands.
+func (l *callLog) tracing(name string) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(c *Client, cmd *Command) Reply {
+			l.add(name + " " + cmd.Name)
+			reply := next(c, cmd)
+			l.add(name + " done")
+			return reply
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	log := &callLog{}
+	s.Use(log.tracing("outer"), log.tracing("middle"))
+	s.Use(log.tracing("inner"))
+
+	c := dialTest(t, addr)
+	c.expect(resp.SimpleString("PONG"), "PING")
+	want := []string{"outer ping", "middle ping", "inner ping", "inner done", "middle done", "outer done"}
+	if got := log.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewareSeesEveryCommand(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	log := &callLog{}
+	s.Use(func(next CommandHandler) CommandHandler {
+		return func(c *Client, cmd *Command) Reply {
+			args := make([]string, len(cmd.Args))
+			for i, arg := range cmd.Args {
+				args[i] = string(arg)
+			}
+			log.add(cmd.Name + " " + strings.Join(args, " "))
+			return next(c, cmd)
+		}
+	})
+	c := dialTest(t, addr)
+	tests := []struct {
+		name string
+		run  func()
+		want []string
+	}{
+		{"RESP", func() { c.expect(okReply, "SET", "key", "value") }, []string{"set key value"}},
+		{"inline", func() {
+			c.conn.Write([]byte("GET key\r\n"))
+			if got := c.read(); !reflect.DeepEqual(got, resp.BulkString("value")) {
+				t.Errorf("inline GET = %#v", got)
+			}
+		}, []string{"get key"}},
+		{"ExecuteCommand", func() {
+			if _, err := s.ExecuteCommand(context.Background(), 0, "EXISTS", "key"); err != nil {
+				t.Error(err)
+			}
+		}, []string{"exists key"}},
+		{"gateway", func() {
+			req := httptest.NewRequest("GET", "/v1/keys/key", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			s.gatewayHandler().ServeHTTP(httptest.NewRecorder(), req)
+		}, []string{"get key"}},
+		{"LoadCommands", func() {
+			if _, err := s.LoadCommands(strings.NewReader("*2\r\n$4\r\nINCR\r\n$1\r\nn\r\n")); err != nil {
+				t.Error(err)
+			}
+		}, []string{"incr n"}},
+		// The arity is checked before the middleware run
+		{"wrong arity", func() {
+			c.expect(wrongNumArgsReply("get"), "GET")
+		}, nil},
+		{"unknown command", func() {
+			c.send("NOSUCHCOMMAND")
+			c.read()
+		}, nil},
+	}
+	for _, tt := range tests {
+		tt.run()
+		if got := log.take(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: middleware saw %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareCommand(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	var got []Command
+	var mu sync.Mutex
+	s.Use(func(next CommandHandler) CommandHandler {
+		return func(c *Client, cmd *Command) Reply {
+			mu.Lock()
+			// The Args are only valid during the call
+			copied := Command{Name: cmd.Name, Flags: cmd.Flags}
+			for _, key := range cmd.Keys() {
+				copied.Args = append(copied.Args, append([]byte(nil), key...))
+			}
+			got = append(got, copied)
+			mu.Unlock()
+			return next(c, cmd)
+		}
+	})
+	c := dialTest(t, addr)
+	c.expect(resp.Int(0), "del", "a", "b")
+	c.expect(resp.Int(0), "EXISTS", "a")
+
+	want := []Command{
+		{Name: "del", Args: [][]byte{[]byte("a"), []byte("b")}, Flags: FlagWrite},
+		{Name: "exists", Args: [][]byte{[]byte("a")}, Flags: FlagReadOnly | FlagFast},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != len(want) {
+		t.Fatalf("middleware saw %d commands, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || !reflect.DeepEqual(got[i].Args, want[i].Args) || got[i].Flags&want[i].Flags != want[i].Flags {
+			t.Errorf("command %d = %s keys %q flags %d, want %s keys %q flags %d",
+				i, got[i].Name, got[i].Args, got[i].Flags, want[i].Name, want[i].Args, want[i].Flags)
+		}
+	}
+}
+
+func TestMiddlewareRejection(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	readOnly := resp.Error("ERR read-only middleware")
+	log := &callLog{}
+	s.Use(log.tracing("outer"), func(next CommandHandler) CommandHandler {
+		return func(c *Client, cmd *Command) Reply {
+			if cmd.Flags&FlagWrite != 0 {
+				return readOnly
+			}
+			return next(c, cmd)
+		}
+	}, log.tracing("inner"))
+
+	c := dialTest(t, addr)
+	c.expect(readOnly, "SET", "key", "value")
+	c.expect(resp.Int(0), "EXISTS", "key")
+	want := []string{"outer set", "outer done", "outer exists", "inner exists", "inner done", "outer done"}
+	if got := log.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %q, want %q", got, want)
+	}
+	// The rejected commands aren't counted as calls
+	stats := string(c.do("INFO", "commandstats").(resp.BulkString))
+	if strings.Contains(stats, "cmdstat_set:") || !strings.Contains(stats, "cmdstat_exists:calls=1,") {
+		t.Errorf("INFO commandstats:\n%s", stats)
+	}
+}
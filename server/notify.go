@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The classes of keyspace events selected by notify-keyspace-events, as in Redis.
+// Every class is accepted for compatibility, though only the generic events of the
+// flush commands are fired.
+const (
+	notifyKeyspace int32 = 1 << iota // K
+	notifyKeyevent                   // E
+	notifyGeneric                    // g
+	notifyString                     // $
+	notifyList                       // l
+	notifySet                        // s
+	notifyHash                       // h
+	notifyZset                       // z
+	notifyExpired                    // x
+	notifyEvicted                    // e
+	notifyStream                     // t
+	notifyKeyMiss                    // m
+	notifyModule                     // d
+	notifyNew                        // n
+
+	// notifyAll are the classes enabled by A
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+// notifyClasses maps the letters of notify-keyspace-events to their classes, in the
+// order CONFIG GET lists them.
+var notifyClasses = []struct {
+	letter byte
+	class  int32
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'h', notifyHash}, {'z', notifyZset}, {'x', notifyExpired}, {'e', notifyEvicted},
+	{'t', notifyStream}, {'d', notifyModule}, {'K', notifyKeyspace},
+	{'E', notifyKeyevent}, {'m', notifyKeyMiss}, {'n', notifyNew},
+}
+
+// parseKeyspaceEvents parses the letters of notify-keyspace-events.
+func parseKeyspaceEvents(value string) (int32, error) {
+	flags := int32(0)
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, c := range notifyClasses {
+			if c.letter == value[i] {
+				flags |= c.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid event class '%c'", value[i])
+		}
+	}
+	return flags, nil
+}
+
+// keyspaceEventsString returns the letters of flags, A standing for all the classes
+// it enables.
+func keyspaceEventsString(flags int32) string {
+	var b strings.Builder
+	if flags&notifyAll == notifyAll {
+		b.WriteByte('A')
+		flags &^= notifyAll
+	}
+	for _, c := range notifyClasses {
+		if flags&c.class != 0 {
+			b.WriteByte(c.letter)
+		}
+	}
+	return b.String()
+}
+
+// notifyFlush fires the flushdb, or flushall, generic event of the database db. It's
+// published on __keyevent@<db>__:<event>, with the database as the message, as a
+// flush has no key to publish on __keyspace@<db>__. This server only has sharded
+// pub/sub, so the events are delivered to the clients subscribed with SSUBSCRIBE.
+func (s *Server) notifyFlush(event string, db int) {
+	flags := atomic.LoadInt32(&s.notifyFlags)
+	if flags&notifyGeneric == 0 || flags&notifyKeyevent == 0 {
+		return
+	}
+	id := strconv.Itoa(db)
+	s.pubsub.publishShard("__keyevent@"+id+"__:"+event, []byte(id))
+}
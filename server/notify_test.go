@@ -0,0 +1,192 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		value   string
+		flags   int32
+		str     string
+		wantErr bool
+	}{
+		{"", 0, "", false},
+		{"Eg", notifyKeyevent | notifyGeneric, "gE", false},
+		{"KEA", notifyKeyspace | notifyKeyevent | notifyAll, "AKE", false},
+		{"Ag", notifyAll, "A", false},
+		{"g$lshzxetd", notifyAll, "A", false},
+		{"Kmn", notifyKeyspace | notifyKeyMiss | notifyNew, "Kmn", false},
+		{"EgE", notifyKeyevent | notifyGeneric, "gE", false},
+		{"Eq", 0, "", true},
+		{"e ", 0, "", true},
+	}
+	for _, tt := range tests {
+		flags, err := parseKeyspaceEvents(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseKeyspaceEvents(%q) returned %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if flags != tt.flags {
+			t.Errorf("parseKeyspaceEvents(%q) = %b, want %b", tt.value, flags, tt.flags)
+		}
+		if str := keyspaceEventsString(flags); str != tt.str {
+			t.Errorf("keyspaceEventsString(%b) = %q, want %q", flags, str, tt.str)
+		}
+	}
+}
+
+func TestFlushNotifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		events string
+		flush  []string
+		// want are the channels notified, for the databases 0 and 1
+		want []string
+	}{
+		{"FLUSHDB", "Eg", []string{"FLUSHDB"}, []string{"__keyevent@0__:flushdb"}},
+		{"FLUSHDB ASYNC", "EA", []string{"FLUSHDB", "ASYNC"}, []string{"__keyevent@0__:flushdb"}},
+		{"FLUSHALL", "Eg", []string{"FLUSHALL"}, []string{"__keyevent@0__:flushall", "__keyevent@1__:flushall"}},
+		{"disabled", "", []string{"FLUSHALL"}, nil},
+		{"keyspace events only", "Kg", []string{"FLUSHALL"}, nil},
+		{"other classes only", "E$", []string{"FLUSHDB"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			c.expect(okReply, "CONFIG", "SET", "notify-keyspace-events", tt.events)
+
+			sub := dialTest(t, addr)
+			channels := []string{"__keyevent@0__:flushdb", "__keyevent@0__:flushall", "__keyevent@1__:flushall"}
+			sub.send(append([]string{"SSUBSCRIBE"}, channels...)...)
+			for range channels {
+				sub.read()
+			}
+
+			c.expect(okReply, tt.flush...)
+			// A message published afterwards tells that no more notifications follow
+			for _, channel := range channels {
+				c.expect(resp.Int(1), "SPUBLISH", channel, "end")
+			}
+			got := []string{}
+			for ended := 0; ended < len(channels); {
+				message := sub.read().(resp.Array)
+				if reflect.DeepEqual(message[2], resp.BulkString("end")) {
+					ended++
+					continue
+				}
+				channel := string(message[1].(resp.BulkString))
+				got = append(got, channel)
+				// The message is the database flushed
+				want := channel[len("__keyevent@") : len("__keyevent@")+1]
+				if db := string(message[2].(resp.BulkString)); db != want {
+					t.Errorf("message on %s = %q, want %q", channel, db, want)
+				}
+			}
+			if tt.want == nil {
+				tt.want = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("notified on %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyKeyspaceEventsConfig(t *testing.T) {
+	tests := []struct {
+		set  string
+		want resp.Reply
+		get  string
+	}{
+		{"KEA", okReply, "AKE"},
+		{"gE", okReply, "gE"},
+		{"", okReply, ""},
+		{"Eq", resp.Error("ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - invalid event class 'q'"), ""},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, tt := range tests {
+		c.expect(tt.want, "CONFIG", "SET", "notify-keyspace-events", tt.set)
+		c.expect(
+			resp.Array{resp.BulkString("notify-keyspace-events"), resp.BulkString(tt.get)},
+			"CONFIG", "GET", "notify-keyspace-events",
+		)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		// lazy is the value of lazyfree-lazy-user-flush
+		lazy string
+		args []string
+		// want are the number of keys left in the databases 0 and 1
+		want [2]int
+	}{
+		{"FLUSHDB", "no", []string{"FLUSHDB"}, [2]int{0, 10}},
+		{"FLUSHDB SYNC", "yes", []string{"FLUSHDB", "SYNC"}, [2]int{0, 10}},
+		{"FLUSHDB ASYNC", "no", []string{"FLUSHDB", "ASYNC"}, [2]int{0, 10}},
+		{"FLUSHDB lazy", "yes", []string{"FLUSHDB"}, [2]int{0, 10}},
+		{"FLUSHALL", "no", []string{"FLUSHALL"}, [2]int{0, 0}},
+		{"FLUSHALL SYNC", "no", []string{"FLUSHALL", "sync"}, [2]int{0, 0}},
+		{"FLUSHALL ASYNC", "no", []string{"FLUSHALL", "async"}, [2]int{0, 0}},
+		{"FLUSHALL lazy", "yes", []string{"FLUSHALL"}, [2]int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			c := dialTest(t, addr)
+			c.expect(okReply, "CONFIG", "SET", "lazyfree-lazy-user-flush", tt.lazy)
+			for db := 1; db >= 0; db-- {
+				c.expect(okReply, "SELECT", strconv.Itoa(db))
+				for i := 0; i < 10; i++ {
+					c.expect(okReply, "SET", "key:"+strconv.Itoa(i), "value")
+				}
+			}
+			c.expect(okReply, tt.args...)
+			c.expect(resp.Int(tt.want[0]), "DBSIZE")
+			c.expect(resp.Null{}, "RANDOMKEY")
+			c.expect(resp.Null{}, "MEMORY", "USAGE", "key:0")
+
+			// The database is usable right away
+			c.expect(okReply, "SET", "key:0", "new")
+			c.expect(resp.BulkString("new"), "GET", "key:0")
+			c.expect(okReply, "SELECT", "1")
+			c.expect(resp.Int(tt.want[1]), "DBSIZE")
+		})
+	}
+}
+
+func TestFlushErrors(t *testing.T) {
+	tests := [][]string{
+		{"FLUSHDB", "NOW"},
+		{"FLUSHALL", "ASYNC", "SYNC"},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, args := range tests {
+		if reply, ok := c.do(args...).(resp.Error); !ok {
+			t.Errorf("%q = %#v, want an error", args, reply)
+		}
+	}
+}
+
+func BenchmarkFlush(b *testing.B) {
+	for _, async := range []bool{false, true} {
+		b.Run("async="+strconv.FormatBool(async), func(b *testing.B) {
+			db := newMemoryStorage(0)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db.Populate(100000, "key", func(i int) []byte { return []byte("value") })
+				b.StartTimer()
+				db.Flush(async)
+			}
+		})
+	}
+}
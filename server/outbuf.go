@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The classes of clients, each with its own output buffer limits.
+const (
+	classNormal = iota
+	classReplica
+	classPubSub
+	numClientClasses
+)
+
+// clientClassNames are the names of the classes in client-output-buffer-limit, the
+// replicas are called slaves when the setting is read, as Redis does.
+var clientClassNames = [numClientClasses]string{"normal", "slave", "pubsub"}
+
+// outputBufferLimit limits the messages queued for a client that doesn't read them
+// as fast as they're pushed. The client is disconnected as soon as the queue reaches
+// hard bytes, or once it stays above soft bytes for more than softSeconds. A zero
+// limit is disabled.
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int64
+}
+
+// outputBufferLimits are the limits of each client class.
+type outputBufferLimits [numClientClasses]outputBufferLimit
+
+// defaultOutputBufferLimits are the limits of Redis: none for the normal clients,
+// whose replies are only queued while the client isn't reading the previous ones.
+var defaultOutputBufferLimits = outputBufferLimits{
+	classNormal:  {},
+	classReplica: {hard: 256 << 20, soft: 64 << 20, softSeconds: 60},
+	classPubSub:  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60},
+}
+
+func (s *Server) outputBufferLimits() outputBufferLimits {
+	return s.obufLimits.Load().(outputBufferLimits)
+}
+
+// checkPushed enforces the output buffer limits of c, given the size of the queue of
+// messages pushed to it. It returns false once the client is over the limits, after
+// closing its connection.
+func (s *Server) checkPushed(c *Client, queued int) bool {
+	class := classNormal
+	if s.pubsub.subscribed(c) {
+		class = classPubSub
+	}
+	limit := s.outputBufferLimits()[class]
+	size := int64(queued)
+
+	over := limit.hard > 0 && size >= limit.hard
+	if !over && limit.soft > 0 && size >= limit.soft {
+		now := s.clock.Now()
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = now
+		} else {
+			over = now.Sub(c.softLimitSince) > time.Duration(limit.softSeconds)*time.Second
+		}
+	} else if !over {
+		c.softLimitSince = time.Time{}
+	}
+	if !over {
+		return true
+	}
+
+	s.logger.Warningf(
+		"Client id=%d addr=%s closed for overcoming of output buffer limits: %d bytes queued",
+		c.id, c.conn.RemoteAddr(), queued,
+	)
+	atomic.AddInt64(&s.stats.outputBufferLimitDisconnections, 1)
+	c.kill("output buffer limit")
+	return false
+}
+
+// formatOutputBufferLimits formats the limits as client-output-buffer-limit does.
+func formatOutputBufferLimits(limits outputBufferLimits) string {
+	fields := make([]string, 0, 4*len(limits))
+	for class, limit := range limits {
+		fields = append(fields,
+			clientClassNames[class],
+			strconv.FormatInt(limit.hard, 10),
+			strconv.FormatInt(limit.soft, 10),
+			strconv.FormatInt(limit.softSeconds, 10),
+		)
+	}
+	return strings.Join(fields, " ")
+}
+
+// parseOutputBufferLimits parses one or more groups of "class hard soft seconds",
+// e.g. "pubsub 32mb 8mb 60", changing the limits of the classes given in current.
+func parseOutputBufferLimits(current outputBufferLimits, value string) (outputBufferLimits, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return current, fmt.Errorf("wrong number of arguments")
+	}
+	limits := current
+	for i := 0; i < len(fields); i += 4 {
+		class := -1
+		switch strings.ToLower(fields[i]) {
+		case "normal":
+			class = classNormal
+		case "replica", "slave":
+			class = classReplica
+		case "pubsub":
+			class = classPubSub
+		default:
+			return current, fmt.Errorf("invalid client class '%s'", fields[i])
+		}
+		hard, err := parseMemory(fields[i+1])
+		if err != nil {
+			return current, err
+		}
+		soft, err := parseMemory(fields[i+2])
+		if err != nil {
+			return current, err
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return current, fmt.Errorf("invalid soft limit seconds '%s'", fields[i+3])
+		}
+		limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: seconds}
+	}
+	return limits, nil
+}
+
+// parseMemory parses a size in bytes with an optional unit, as in the configuration
+// of Redis: k, m and g are powers of 1000, kb, mb and gb powers of 1024.
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(value)
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, scale = strings.TrimSuffix(lower, unit.suffix), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size '%s'", value)
+	}
+	return n * scale, nil
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestParseOutputBufferLimits(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    outputBufferLimits
+		wantErr bool
+	}{
+		{value: "normal 0 0 0", want: defaultOutputBufferLimits},
+		{
+			value: "pubsub 1mb 512kb 10",
+			want: outputBufferLimits{
+				classReplica: defaultOutputBufferLimits[classReplica],
+				classPubSub:  {hard: 1 << 20, soft: 512 << 10, softSeconds: 10},
+			},
+		},
+		{
+			value: "NORMAL 1k 2m 3 replica 1g 1gb 0",
+			want: outputBufferLimits{
+				classNormal:  {hard: 1000, soft: 2000 * 1000, softSeconds: 3},
+				classReplica: {hard: 1000 * 1000 * 1000, soft: 1 << 30},
+				classPubSub:  defaultOutputBufferLimits[classPubSub],
+			},
+		},
+		{
+			value: "slave 100b 10 1",
+			want: outputBufferLimits{
+				classReplica: {hard: 100, soft: 10, softSeconds: 1},
+				classPubSub:  defaultOutputBufferLimits[classPubSub],
+			},
+		},
+		{value: "", wantErr: true},
+		{value: "pubsub 1mb 512kb", wantErr: true},
+		{value: "master 0 0 0", wantErr: true},
+		{value: "pubsub 1tb 0 0", wantErr: true},
+		{value: "pubsub 0 -1 0", wantErr: true},
+		{value: "pubsub 0 0 -1", wantErr: true},
+		// A valid group doesn't apply when a later one is invalid
+		{value: "normal 1 1 1 pubsub x 0 0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOutputBufferLimits(defaultOutputBufferLimits, tt.value)
+		if tt.wantErr {
+			if err == nil || got != defaultOutputBufferLimits {
+				t.Errorf("parseOutputBufferLimits(%q) = %v, %v, want the current limits and an error", tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseOutputBufferLimits(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatOutputBufferLimits(t *testing.T) {
+	want := "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60"
+	got := formatOutputBufferLimits(defaultOutputBufferLimits)
+	if got != want {
+		t.Errorf("formatOutputBufferLimits = %q, want %q", got, want)
+	}
+	if parsed, err := parseOutputBufferLimits(outputBufferLimits{}, got); err != nil || parsed != defaultOutputBufferLimits {
+		t.Errorf("parsing %q = %v, %v, want the default limits", got, parsed, err)
+	}
+}
+
+func TestCheckPushed(t *testing.T) {
+	type push struct {
+		// elapsed is the time passed since the previous push
+		elapsed time.Duration
+		queued  int
+		wantOK  bool
+	}
+	tests := []struct {
+		name   string
+		limit  outputBufferLimit
+		pushes []push
+	}{
+		{
+			"no limit",
+			outputBufferLimit{},
+			[]push{{0, 1 << 30, true}, {time.Hour, 1 << 30, true}},
+		},
+		{
+			"under the hard limit",
+			outputBufferLimit{hard: 100},
+			[]push{{0, 50, true}, {0, 99, true}},
+		},
+		{
+			"reaching the hard limit",
+			outputBufferLimit{hard: 100},
+			[]push{{0, 50, true}, {0, 100, false}},
+		},
+		{
+			"above the soft limit for too long",
+			outputBufferLimit{soft: 100, softSeconds: 10},
+			[]push{{0, 100, true}, {5 * time.Second, 200, true}, {5 * time.Second, 200, true}, {time.Millisecond, 200, false}},
+		},
+		{
+			"back under the soft limit",
+			outputBufferLimit{soft: 100, softSeconds: 10},
+			[]push{{0, 100, true}, {9 * time.Second, 50, true}, {0, 100, true}, {9 * time.Second, 100, true}},
+		},
+		{
+			"hard limit with a soft one",
+			outputBufferLimit{hard: 200, soft: 100, softSeconds: 10},
+			[]push{{0, 150, true}, {0, 200, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			s := New(Options{Databases: 1, Clock: clock, Logger: NewLogger(io.Discard, LogWarning)})
+			limits := defaultOutputBufferLimits
+			limits[classNormal] = tt.limit
+			s.obufLimits.Store(limits)
+
+			conn, peer := net.Pipe()
+			defer peer.Close()
+			c := newClient(1, conn, s.databases[0], clock.Now())
+			for i, p := range tt.pushes {
+				clock.advance(p.elapsed)
+				if ok := s.checkPushed(c, p.queued); ok != p.wantOK {
+					t.Fatalf("push %d of %d bytes: checkPushed = %v, want %v", i, p.queued, ok, p.wantOK)
+				}
+			}
+			closed := !tt.pushes[len(tt.pushes)-1].wantOK
+			wantReason, wantDisconnections := "", int64(0)
+			if closed {
+				wantReason, wantDisconnections = "output buffer limit", 1
+			}
+			if c.killReason != wantReason {
+				t.Errorf("kill reason %q, want %q", c.killReason, wantReason)
+			}
+			if got := s.stats.outputBufferLimitDisconnections; got != wantDisconnections {
+				t.Errorf("%d disconnections, want %d", got, wantDisconnections)
+			}
+		})
+	}
+}
+
+func TestOutputBufferLimitOfSubscribers(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	admin := dialTest(t, addr)
+	admin.expect(okReply, "CONFIG", "SET", "client-output-buffer-limit", "pubsub 1mb 0 0")
+	admin.expect(resp.Array{
+		resp.BulkString("client-output-buffer-limit"),
+		resp.BulkString("normal 0 0 0 slave 268435456 67108864 60 pubsub 1048576 0 0"),
+	}, "CONFIG", "GET", "client-output-buffer-limit")
+
+	// The subscriber never reads the messages, they're queued once the buffers of
+	// the connection are full
+	subscriber := dialTest(t, addr)
+	subscriber.send("SSUBSCRIBE", "channel")
+	subscriber.conn.(*net.TCPConn).SetReadBuffer(4096)
+
+	message := strings.Repeat("x", 64<<10)
+	waitFor(t, "the subscriber to be disconnected", func() bool {
+		for i := 0; i < 16; i++ {
+			admin.do("SPUBLISH", "channel", message)
+		}
+		return infoField(admin, "stats", "client_output_buffer_limit_disconnections") == "1"
+	})
+	if n := admin.do("SPUBLISH", "channel", message); n != resp.Reply(resp.Int(0)) {
+		t.Errorf("SPUBLISH = %#v after the disconnection, want 0", n)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// protectedModeReply is sent to the clients refused by the protected mode, before
+// closing their connection.
+var protectedModeReply = resp.Error("DENIED Redis is running in protected mode because protected mode is " +
+	"enabled and no password is set. In this mode connections are only accepted from the loopback " +
+	"interface. If you want to connect from external computers you may adopt one of the following " +
+	"solutions: 1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from " +
+	"the loopback interface by connecting from the same host the server is running, however MAKE SURE " +
+	"the server is not publicly accessible from internet if you do so. 2) Alternatively you can just " +
+	"disable the protected mode by editing the configuration file, and setting the protected-mode " +
+	"option to 'no', and then restarting the server. 3) If you started the server manually just for " +
+	"testing, restart it with the '-protected-mode=false' option. NOTE: You only need to do one of the " +
+	"above things in order for the server to start accepting connections from the outside.")
+
+// protectedMode reports whether connections from other hosts are refused.
+func (s *Server) protectedMode() bool {
+	return atomic.LoadInt32(&s.protected) == 1
+}
+
+// isLocalConn reports whether conn comes from the same host: from a unix socket or
+// from a loopback address, IPv4 or IPv6.
+func isLocalConn(conn net.Conn) bool {
+	if strings.HasPrefix(conn.LocalAddr().Network(), "unix") {
+		return true
+	}
+	return isLoopbackAddr(conn.RemoteAddr())
+}
+
+func isLoopbackAddr(addr net.Addr) bool {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	case nil:
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
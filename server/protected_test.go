@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// remoteConn is a loopback connection that pretends to come from addr.
+type remoteConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *remoteConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// serveConnFrom serves a single connection on s, seen by the server as coming from
+// remote, and returns the client side of it.
+func serveConnFrom(t *testing.T, s *Server, remote net.Addr) *testClient {
+	t.Helper()
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	client := dialTest(t, tcp.Addr().String())
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &scriptedListener{closed: make(chan struct{}), script: []interface{}{&remoteConn{conn, remote}}}
+	go s.Serve(ln)
+	return client
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want bool
+	}{
+		{"IPv4 loopback", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}, true},
+		{"IPv4 loopback network", &net.TCPAddr{IP: net.IPv4(127, 1, 2, 3), Port: 1234}, true},
+		{"IPv6 loopback", &net.TCPAddr{IP: net.IPv6loopback, Port: 1234}, true},
+		{"IPv4 mapped loopback", &net.TCPAddr{IP: net.ParseIP("::ffff:127.0.0.1"), Port: 1234}, true},
+		{"remote IPv4", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}, false},
+		{"remote IPv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, false},
+		{"unix socket", &net.UnixAddr{Name: "/tmp/redis.sock", Net: "unix"}, true},
+		{"no address", nil, false},
+		{"loopback string", stringAddr("127.0.0.1:1234"), true},
+		{"remote string", stringAddr("192.0.2.1:1234"), false},
+		{"not an IP", stringAddr("pipe"), false},
+	}
+	for _, tt := range tests {
+		if got := isLoopbackAddr(tt.addr); got != tt.want {
+			t.Errorf("%s: isLoopbackAddr(%v) = %t, want %t", tt.name, tt.addr, got, tt.want)
+		}
+	}
+}
+
+// stringAddr is an address of an unknown network.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "test" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestProtectedMode(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	tests := []struct {
+		name     string
+		disabled bool
+		// disable turns protected mode off with CONFIG SET before connecting
+		disable bool
+		from    net.Addr
+		allowed bool
+	}{
+		{"local client", false, false, local, true},
+		{"remote client", false, false, remote, false},
+		{"remote client without protected mode", true, false, remote, true},
+		{"remote client once protected mode is turned off", false, true, remote, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{
+				Network:              "tcp",
+				Databases:            16,
+				DisableProtectedMode: tt.disabled,
+				Logger:               NewLogger(io.Discard, LogWarning),
+			})
+			t.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				s.Shutdown(ctx)
+			})
+			if tt.disable {
+				serveConnFrom(t, s, local).expect(okReply, "CONFIG", "SET", "protected-mode", "no")
+			}
+
+			c := serveConnFrom(t, s, tt.from)
+			if tt.allowed {
+				c.expect(resp.SimpleString("PONG"), "PING")
+				return
+			}
+			// The client is refused without running its command
+			c.send("SET", "key", "value")
+			if got := c.read(); got != resp.Reply(protectedModeReply) {
+				t.Errorf("remote client got %#v, want the protected mode error", got)
+			}
+			c.expectClosed()
+			if size := s.databases[0].Size(); size != 0 {
+				t.Errorf("%d keys written by the refused client", size)
+			}
+		})
+	}
+}
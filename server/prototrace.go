@@ -0,0 +1,53 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// DefaultTraceProtoMaxLen is the number of bytes of each frame logged when tracing
+// the protocol, if not configured otherwise.
+const DefaultTraceProtoMaxLen = 256
+
+// traceProtoEnabled reports whether the protocol is traced for the connections
+// accepted from now on.
+func (s *Server) traceProtoEnabled() bool {
+	return atomic.LoadInt32(&s.traceProto) == 1
+}
+
+// setProtoTrace starts or stops logging, at debug level, the commands read from the
+// connection of c and the replies and pushes written to it, byte for byte. It must
+// be called by the goroutine of the client, between two commands.
+func (s *Server) setProtoTrace(c *Client, on bool) {
+	if !on {
+		if c.reader != nil {
+			c.reader.SetTracer(nil)
+		}
+		c.conn.setTracer(nil)
+		return
+	}
+	limit := int(atomic.LoadInt64(&s.traceProtoMaxLen))
+	if c.reader != nil {
+		c.reader.SetTracer(s.newProtoTracer(c, "<-", limit))
+	}
+	c.conn.setTracer(s.newProtoTracer(c, "->", limit))
+}
+
+// newProtoTracer returns a tracer logging the frames, in the direction given by
+// arrow, escaped and annotated with their length.
+func (s *Server) newProtoTracer(c *Client, arrow string, limit int) *resp.Tracer {
+	return &resp.Tracer{
+		Limit: limit,
+		Trace: func(frame []byte, size int) {
+			if !s.logger.Enabled(LogDebug) {
+				return
+			}
+			if len(frame) < size {
+				s.logger.Debugf("Client id=%d %s %d bytes, first %d: %q...", c.id, arrow, size, len(frame), frame)
+				return
+			}
+			s.logger.Debugf("Client id=%d %s %d bytes: %q", c.id, arrow, size, frame)
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// tracedFrames counts the frames of the client id in the log.
+func tracedFrames(log *syncBuffer, id string) int {
+	return strings.Count(log.String(), "Client id="+id+" <- ") + strings.Count(log.String(), "Client id="+id+" -> ")
+}
+
+// expectTraced waits for the log to hold the trace of a frame of the client id.
+func expectTraced(t *testing.T, log *syncBuffer, id, frame string) {
+	t.Helper()
+	waitFor(t, "trace "+frame, func() bool {
+		return strings.Contains(log.String(), "Client id="+id+" "+frame+"\n")
+	})
+}
+
+func TestTraceProto(t *testing.T) {
+	log := &syncBuffer{}
+	_, addr := newTestServer(t, Options{Logger: NewLogger(log, LogDebug), TraceProto: true})
+	c := dialTest(t, addr)
+	id := clientID(c)
+
+	c.expect(okReply, "SET", "key", "value")
+	expectTraced(t, log, id, `<- 33 bytes: "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"`)
+	expectTraced(t, log, id, `-> 5 bytes: "+OK\r\n"`)
+	c.expect(resp.BulkString("value"), "GET", "key")
+	expectTraced(t, log, id, `<- 22 bytes: "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"`)
+	expectTraced(t, log, id, `-> 11 bytes: "$5\r\nvalue\r\n"`)
+	c.expect(resp.Error("ERR syntax error"), "CLIENT", "TRACE", "maybe")
+	expectTraced(t, log, id, `-> 19 bytes: "-ERR syntax error\r\n"`)
+
+	// Stopping the trace of one connection leaves the others traced
+	other := dialTest(t, addr)
+	otherID := clientID(other)
+	before := tracedFrames(log, id)
+	c.expect(okReply, "CLIENT", "TRACE", "OFF")
+	c.expect(resp.SimpleString("PONG"), "PING")
+	other.expect(resp.SimpleString("PONG"), "PING")
+	expectTraced(t, log, otherID, `<- 14 bytes: "*1\r\n$4\r\nPING\r\n"`)
+	if n := tracedFrames(log, id); n != before+1 {
+		t.Errorf("%d frames traced after CLIENT TRACE OFF, want only the command:\n%s", n-before, log)
+	}
+}
+
+func TestTraceProtoMaxLen(t *testing.T) {
+	log := &syncBuffer{}
+	_, addr := newTestServer(t, Options{Logger: NewLogger(log, LogDebug), TraceProto: true, TraceProtoMaxLen: 8})
+	c := dialTest(t, addr)
+	id := clientID(c)
+
+	value := strings.Repeat("v", 1000)
+	c.expect(okReply, "SET", "key", value)
+	expectTraced(t, log, id, `<- 1031 bytes, first 8: "*3\r\n$3\r\n"...`)
+	c.expect(resp.BulkString(value), "GET", "key")
+	expectTraced(t, log, id, `-> 1009 bytes, first 8: "$1000\r\nv"...`)
+	for _, line := range strings.Split(log.String(), "\n") {
+		if strings.Contains(line, " bytes") && strings.Contains(line, strings.Repeat("v", 2)) {
+			t.Errorf("the trace holds more than the first 8 bytes: %s", line)
+		}
+	}
+
+	c.expect(
+		resp.Error("ERR CONFIG SET failed (possibly related to argument 'trace-proto-max-len') - argument must be between 1 and 2147483647 inclusive"),
+		"CONFIG", "SET", "trace-proto-max-len", "0",
+	)
+	c.expect(resp.Array{resp.BulkString("trace-proto-max-len"), resp.BulkString("8")}, "CONFIG", "GET", "trace-proto-max-len")
+	// The new limit applies to the connections traced from now on
+	c.expect(okReply, "CONFIG", "SET", "trace-proto-max-len", "4")
+	c.expect(okReply, "CLIENT", "TRACE", "ON")
+	c.expect(resp.BulkString(value), "GET", "key")
+	expectTraced(t, log, id, `-> 1009 bytes, first 4: "$100"...`)
+}
+
+func TestClientTrace(t *testing.T) {
+	log := &syncBuffer{}
+	_, addr := newTestServer(t, Options{Logger: NewLogger(log, LogDebug)})
+	c := dialTest(t, addr)
+	id := clientID(c)
+	other := dialTest(t, addr)
+
+	c.expect(resp.Array{resp.BulkString("trace-proto"), resp.BulkString("no")}, "CONFIG", "GET", "trace-proto")
+	c.expect(resp.SimpleString("PONG"), "PING")
+	c.expect(okReply, "CLIENT", "TRACE", "on")
+	// The reply to CLIENT TRACE ON is the first frame traced
+	expectTraced(t, log, id, `-> 5 bytes: "+OK\r\n"`)
+	c.expect(resp.SimpleString("PONG"), "PING")
+	expectTraced(t, log, id, `<- 14 bytes: "*1\r\n$4\r\nPING\r\n"`)
+	expectTraced(t, log, id, `-> 7 bytes: "+PONG\r\n"`)
+	other.expect(resp.SimpleString("PONG"), "PING")
+	c.expect(okReply, "CLIENT", "TRACE", "off")
+	c.expect(resp.SimpleString("PONG"), "PING")
+	// The frames of the other connection and the reply to CLIENT TRACE OFF aren't
+	if n := strings.Count(log.String(), " bytes: "); n != 4 {
+		t.Errorf("%d frames traced, want 4, only the ones of the traced connection:\n%s", n, log)
+	}
+
+	c.expect(wrongNumArgsReply("client|trace"), "CLIENT", "TRACE")
+	c.expect(wrongNumArgsReply("client|trace"), "CLIENT", "TRACE", "ON", "OFF")
+
+	// CONFIG SET trace-proto applies to the connections accepted from now on
+	c.expect(okReply, "CONFIG", "SET", "trace-proto", "yes")
+	c.expect(resp.SimpleString("PONG"), "PING")
+	traced := dialTest(t, addr)
+	tracedID := clientID(traced)
+	traced.expect(resp.SimpleString("PONG"), "PING")
+	expectTraced(t, log, tracedID, `<- 14 bytes: "*1\r\n$4\r\nPING\r\n"`)
+	if n := tracedFrames(log, id); n != 4 {
+		t.Errorf("%d frames of the connection traced, want 4 after CONFIG SET:\n%s", n, log)
+	}
+}
+
+func TestTraceProtoLogLevel(t *testing.T) {
+	log := &syncBuffer{}
+	logger := NewLogger(log, LogNotice)
+	_, addr := newTestServer(t, Options{Logger: logger, TraceProto: true})
+	c := dialTest(t, addr)
+	c.expect(okReply, "SET", "key", "value")
+	if got := log.String(); strings.Contains(got, "Client id=") {
+		t.Errorf("traced above debug level:\n%s", got)
+	}
+
+	// The level is checked for each frame, not when the trace starts
+	logger.SetLevel(LogDebug)
+	c.expect(resp.BulkString("value"), "GET", "key")
+	waitFor(t, "the GET trace", func() bool {
+		return strings.Contains(log.String(), `"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"`)
+	})
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// pubsub is the registry of the subscribers to the channels. Shard channels are
+// a namespace of their own: a message published with SPUBLISH is only delivered to
+// the clients subscribed with SSUBSCRIBE.
+type pubsub struct {
+	mu            sync.RWMutex
+	shardChannels map[string]map[*Client]struct{}
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		shardChannels: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// subscribedAllowed are the commands a RESP2 client can send once it's subscribed
+// to a channel, since every reply it receives may be a message.
+var subscribedAllowed = map[string]bool{
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+}
+
+// subscribed reports whether c is subscribed to any channel, it can be called by any
+// goroutine, unlike subscriptions.
+func (p *pubsub) subscribed(c *Client) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(c.shardChannels) > 0
+}
+
+// subscriptions returns the number of channels the client is subscribed to.
+func (c *Client) subscriptions() int {
+	return len(c.shardChannels)
+}
+
+func (p *pubsub) subscribeShard(c *Client, channel string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscribers, ok := p.shardChannels[channel]
+	if !ok {
+		subscribers = make(map[*Client]struct{})
+		p.shardChannels[channel] = subscribers
+	}
+	subscribers[c] = struct{}{}
+	if c.shardChannels == nil {
+		c.shardChannels = make(map[string]struct{})
+	}
+	c.shardChannels[channel] = struct{}{}
+}
+
+func (p *pubsub) unsubscribeShard(c *Client, channel string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := c.shardChannels[channel]; !ok {
+		return false
+	}
+	delete(c.shardChannels, channel)
+	subscribers := p.shardChannels[channel]
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(p.shardChannels, channel)
+	}
+	return true
+}
+
+// publishShard delivers message to the subscribers of the shard channel, returning
+// how many received it.
+func (p *pubsub) publishShard(channel string, message []byte) int {
+	p.mu.RLock()
+	subscribers := make([]*Client, 0, len(p.shardChannels[channel]))
+	for c := range p.shardChannels[channel] {
+		subscribers = append(subscribers, c)
+	}
+	p.mu.RUnlock()
+
+	push := resp.Push{resp.BulkString("smessage"), resp.BulkString(channel), resp.BulkString(message)}
+	for _, c := range subscribers {
+		c.conn.WritePush(push)
+	}
+	return len(subscribers)
+}
+
+// unsubscribeAll removes the subscriptions of a client that disconnected.
+func (p *pubsub) unsubscribeAll(c *Client) {
+	for channel := range c.shardChannels {
+		p.unsubscribeShard(c, channel)
+	}
+}
+
+// ssubscribe subscribes the client to the shard channels, replying with a
+// confirmation for each of them. https://redis.io/commands/ssubscribe/
+func (s *Server) ssubscribe(c *Client, args [][]byte) Reply {
+	replies := make(resp.Multi, 0, len(args))
+	for _, arg := range args {
+		channel := string(arg)
+		s.pubsub.subscribeShard(c, channel)
+		replies = append(replies, resp.Push{
+			resp.BulkString("ssubscribe"), resp.BulkString(channel), resp.Int(c.subscriptions()),
+		})
+	}
+	return replies
+}
+
+// sunsubscribe unsubscribes the client from the shard channels, or from all of them
+// if none is given. https://redis.io/commands/sunsubscribe/
+func (s *Server) sunsubscribe(c *Client, args [][]byte) Reply {
+	channels := make([]string, 0, len(args))
+	for _, arg := range args {
+		channels = append(channels, string(arg))
+	}
+	if len(args) == 0 {
+		for channel := range c.shardChannels {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+	}
+	if len(channels) == 0 {
+		return resp.Push{resp.BulkString("sunsubscribe"), resp.Null{}, resp.Int(0)}
+	}
+
+	replies := make(resp.Multi, 0, len(channels))
+	for _, channel := range channels {
+		s.pubsub.unsubscribeShard(c, channel)
+		replies = append(replies, resp.Push{
+			resp.BulkString("sunsubscribe"), resp.BulkString(channel), resp.Int(c.subscriptions()),
+		})
+	}
+	return replies
+}
+
+// spublish posts a message to a shard channel, replying with the number of clients
+// that received it. https://redis.io/commands/spublish/
+func (s *Server) spublish(c *Client, args [][]byte) Reply {
+	return resp.Int(s.pubsub.publishShard(string(args[0]), args[1]))
+}
+
+// pubsubCmd is a container command for the introspection of the pub/sub subsystem.
+// `PUBSUB SHARDCHANNELS [pattern]` lists the shard channels with at least one
+// subscriber matching the glob-style pattern, see stringMatch.
+// `PUBSUB SHARDNUMSUB [channel ...]` returns the number of subscribers of the shard
+// channels.
+// https://redis.io/commands/pubsub-shardchannels/
+// https://redis.io/commands/pubsub-shardnumsub/
+func (s *Server) pubsubCmd(c *Client, args [][]byte) Reply {
+	switch strings.ToLower(string(args[0])) {
+	case "shardchannels":
+		if len(args) > 2 {
+			return wrongNumArgsReply("pubsub|shardchannels")
+		}
+		s.pubsub.mu.RLock()
+		channels := []string{}
+		for channel := range s.pubsub.shardChannels {
+			if len(args) == 2 {
+				if !stringMatch(string(args[1]), channel) {
+					continue
+				}
+			}
+			channels = append(channels, channel)
+		}
+		s.pubsub.mu.RUnlock()
+		sort.Strings(channels)
+
+		reply := make(resp.Array, 0, len(channels))
+		for _, channel := range channels {
+			reply = append(reply, resp.BulkString(channel))
+		}
+		return reply
+	case "shardnumsub":
+		s.pubsub.mu.RLock()
+		defer s.pubsub.mu.RUnlock()
+
+		reply := make(resp.Map, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			reply = append(reply, resp.BulkString(arg), resp.Int(len(s.pubsub.shardChannels[string(arg)])))
+		}
+		return reply
+	}
+	return errorf("ERR unknown subcommand '%s'. Try PUBSUB HELP.", args[0])
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// ReplyWriter wraps a client connection and buffers the replies sent to it, so that
+// a reply doesn't cost a syscall on its own. The first error returned by the
+// connection is recorded and every following write is discarded: handlers can keep
+// replying without checking errors, and handleConnection tears the connection down
+// once the command has been handled.
+// Replies are written by the goroutine of the client and flushed by flushingReader.
+// Messages pushed by other goroutines with WritePush are queued instead, and written
+// by a goroutine of their own between two replies, so a client that stops reading
+// never blocks the clients publishing to it: only its queue grows, up to the limit
+// enforced by checkPushed.
+type ReplyWriter struct {
+	net.Conn
+	// mu serializes the writes to the connection
+	mu      sync.Mutex
+	buf     *bufio.Writer
+	counter *countingWriter
+	enc     *resp.Writer
+	err     error
+	// mode and skipping suppress the replies to the commands, as requested with
+	// CLIENT REPLY, messages pushed with WritePush are always sent
+	mode     replyMode
+	skipping bool
+
+	// pushMu protects the queue of pushed messages, it's acquired after mu when
+	// both are needed
+	pushMu  sync.Mutex
+	pushEnc *resp.Writer
+	pushed  []byte
+	spare   []byte
+	// hasPushed is 1 while pushed isn't empty, so that replies only acquire pushMu
+	// when there's something to write
+	hasPushed int32
+	flushing  bool
+	dropped   bool
+	// checkPushed is called with the size of the queue after each push, the queue
+	// is dropped and nothing else is pushed if it returns false
+	checkPushed func(queued int) bool
+}
+
+// replyMode is the reply mode of a client, set with CLIENT REPLY.
+type replyMode int
+
+const (
+	replyOn replyMode = iota
+	replyOff
+	// replySkip suppresses the reply to the command after the current one
+	replySkip
+)
+
+var bufioWriterPool sync.Pool
+
+// maxSparePushedLen is the largest queue of pushed messages kept to be reused, the
+// memory of a queue grown by a burst of messages is released afterwards.
+const maxSparePushedLen = 64 * 1024
+
+func newReplyWriter(conn net.Conn) *ReplyWriter {
+	buf, _ := bufioWriterPool.Get().(*bufio.Writer)
+	if buf == nil {
+		buf = bufio.NewWriter(conn)
+	} else {
+		buf.Reset(conn)
+	}
+	counter := &countingWriter{w: buf}
+	w := &ReplyWriter{
+		Conn:    conn,
+		buf:     buf,
+		counter: counter,
+		enc:     resp.NewWriter(counter),
+	}
+	w.pushEnc = resp.NewWriter(pushQueue{w})
+	return w
+}
+
+// countingWriter counts the bytes written by the encoder, to report the size of
+// the replies.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// pushQueue appends the messages encoded by pushEnc to the queue, it's only written
+// with pushMu held.
+type pushQueue struct {
+	w *ReplyWriter
+}
+
+func (q pushQueue) Write(p []byte) (int, error) {
+	q.w.pushed = append(q.w.pushed, p...)
+	return len(p), nil
+}
+
+// Write appends p to the buffered replies. It returns the first error encountered
+// while writing to the connection, if any.
+func (w *ReplyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writePushed()
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.buf.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// WriteReply encodes r as a single frame, returning its size in bytes. Nothing is
+// written if the client turned replies off.
+func (w *ReplyWriter) WriteReply(r Reply) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.mode != replyOn || w.skipping {
+		return 0
+	}
+	w.writePushed()
+	return w.write(r)
+}
+
+func (w *ReplyWriter) write(r Reply) int {
+	if w.err != nil {
+		return 0
+	}
+	before := w.counter.n
+	w.err = w.enc.WriteReply(r)
+	return w.counter.n - before
+}
+
+// WritePush queues r as a single frame, regardless of the reply mode, and makes
+// sure it's written and flushed soon, returning its size in bytes. It's used for
+// the messages pushed to the client, such as the ones published to a channel, and
+// never blocks on the connection.
+func (w *ReplyWriter) WritePush(r Reply) int {
+	w.pushMu.Lock()
+	defer w.pushMu.Unlock()
+
+	if w.dropped {
+		return 0
+	}
+	before := len(w.pushed)
+	w.pushEnc.WriteReply(r)
+	n := len(w.pushed) - before
+	if w.checkPushed != nil && !w.checkPushed(len(w.pushed)) {
+		w.dropped = true
+		w.pushed, w.spare = nil, nil
+		atomic.StoreInt32(&w.hasPushed, 0)
+		return n
+	}
+	atomic.StoreInt32(&w.hasPushed, 1)
+	if !w.flushing {
+		w.flushing = true
+		go w.flushPushed()
+	}
+	return n
+}
+
+// flushPushed writes the queued messages to the connection, between two replies.
+func (w *ReplyWriter) flushPushed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writePushed()
+	if w.err == nil {
+		w.err = w.buf.Flush()
+	}
+}
+
+// writePushed moves the queued messages to the buffered replies, it's called with
+// mu held before writing anything else so the messages are sent in order.
+func (w *ReplyWriter) writePushed() {
+	if atomic.LoadInt32(&w.hasPushed) == 0 {
+		return
+	}
+	w.pushMu.Lock()
+	pushed := w.pushed
+	w.pushed, w.spare = w.spare[:0], nil
+	atomic.StoreInt32(&w.hasPushed, 0)
+	w.flushing = false
+	w.pushMu.Unlock()
+
+	if w.err == nil {
+		_, w.err = w.buf.Write(pushed)
+	}
+
+	if cap(pushed) <= maxSparePushedLen {
+		w.pushMu.Lock()
+		w.spare = pushed[:0]
+		w.pushMu.Unlock()
+	}
+}
+
+// setReplyMode changes the reply mode, turning replies on also cancels a pending
+// SKIP.
+func (w *ReplyWriter) setReplyMode(mode replyMode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.mode = mode
+	if mode == replyOn {
+		w.skipping = false
+	}
+}
+
+// setTracer starts tracing the frames written, or stops if t is nil. The pushed
+// messages are traced by a copy of t, as they're encoded separately.
+func (w *ReplyWriter) setTracer(t *resp.Tracer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.enc.SetTracer(t)
+
+	w.pushMu.Lock()
+	defer w.pushMu.Unlock()
+
+	if t == nil {
+		w.pushEnc.SetTracer(nil)
+		return
+	}
+	w.pushEnc.SetTracer(&resp.Tracer{Limit: t.Limit, Trace: t.Trace})
+}
+
+// endCommand is called once a command has been handled, it moves a SKIP requested
+// by the command on to the next one.
+func (w *ReplyWriter) endCommand() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.skipping = false
+	if w.mode == replySkip {
+		w.mode = replyOn
+		w.skipping = true
+	}
+}
+
+// Flush sends the buffered replies and the queued messages to the client.
+func (w *ReplyWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writePushed()
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.buf.Flush()
+	return w.err
+}
+
+// release returns the buffer to the pool once the connection is closed, any later
+// write fails and the queued messages are dropped.
+func (w *ReplyWriter) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pushMu.Lock()
+	w.dropped = true
+	w.pushed, w.spare = nil, nil
+	atomic.StoreInt32(&w.hasPushed, 0)
+	w.pushMu.Unlock()
+
+	if w.buf == nil {
+		return
+	}
+	w.err = net.ErrClosed
+	w.buf.Reset(nil)
+	bufioWriterPool.Put(w.buf)
+	w.buf = nil
+	w.counter.w = nil
+}
+
+// flushingReader reads the commands from the connection, flushing the buffered replies
+// before waiting for more input. Replies are then sent only once every command
+// received so far has been executed, so the replies to a pipeline take a handful of
+// writes, while interactive clients, which wait for a reply before sending the next
+// command, still get it right away.
+type flushingReader struct {
+	w *ReplyWriter
+}
+
+func (r flushingReader) Read(p []byte) (int, error) {
+	if err := r.w.Flush(); err != nil {
+		return 0, err
+	}
+	return r.w.Conn.Read(p)
+}
+
+// Err returns the first error encountered while writing to the connection.
+func (w *ReplyWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+var okReply = resp.SimpleString("OK")
+
+var keyTooLargeReply = resp.Error("ERR key is too large for the storage engine")
+
+var valueIsNotIntReply = resp.Error("ERR value is not an integer or out of range")
+
+func wrongNumArgsReply(name string) Reply {
+	return resp.Error("ERR wrong number of arguments for '" + name + "' command")
+}
+
+// errorf formats an error reply. The newlines of the arguments sent by the client
+// are replaced with spaces, as an error is a single line: one would end the reply
+// early and the rest would be read as another one.
+func errorf(format string, a ...interface{}) Reply {
+	return resp.Error(errorNewlines.Replace(fmt.Sprintf(format, a...)))
+}
+
+var errorNewlines = strings.NewReplacer("\r", " ", "\n", " ")
+
+// unknownCommandReply is the error for a command that doesn't exist, the arguments
+// are quoted to help telling what the client tried to do.
+func unknownCommandReply(name []byte, args [][]byte) Reply {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ERR unknown command '%.128s', with args beginning with: ", name)
+	for _, arg := range args {
+		if b.Len() >= 128 {
+			break
+		}
+		fmt.Fprintf(&b, "'%.128s' ", arg)
+	}
+	return errorf("%s", b.String())
+}
+
+// bulkStringArray returns an array whose elements are all Bulk Strings.
+func bulkStringArray(items [][]byte) resp.Array {
+	reply := make(resp.Array, 0, len(items))
+	for _, item := range items {
+		reply = append(reply, resp.BulkString(item))
+	}
+	return reply
+}
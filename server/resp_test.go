@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// recordingConn records the writes to a connection, failing them with err once
+// it's set.
+type recordingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *recordingConn) written() (writes int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.writes), bytes.Join(c.writes, nil)
+}
+
+func TestReplyWriterBuffersReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []Reply
+		want    string
+	}{
+		{"simple string", []Reply{okReply}, "+OK\r\n"},
+		{
+			"several replies",
+			[]Reply{okReply, resp.Int(42), resp.BulkString("bulk"), resp.Null{}},
+			"+OK\r\n:42\r\n$4\r\nbulk\r\n$-1\r\n",
+		},
+		{
+			"array",
+			[]Reply{resp.Array{resp.BulkString("a"), resp.Int(1)}},
+			"*2\r\n$1\r\na\r\n:1\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			w := newReplyWriter(conn)
+			defer w.release()
+
+			size := 0
+			for _, r := range tt.replies {
+				size += w.WriteReply(r)
+			}
+			if writes, _ := conn.written(); writes != 0 {
+				t.Fatalf("%d writes before Flush, want 0", writes)
+			}
+			if size != len(tt.want) {
+				t.Errorf("WriteReply returned %d bytes in total, want %d", size, len(tt.want))
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			writes, data := conn.written()
+			if writes != 1 {
+				t.Errorf("%d writes, want the replies sent at once", writes)
+			}
+			if string(data) != tt.want {
+				t.Errorf("wrote %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyWriterWriteErrors(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	tests := []struct {
+		name string
+		// write writes to w after the connection failed
+		write func(w *ReplyWriter) error
+	}{
+		{"Flush", func(w *ReplyWriter) error { return w.Flush() }},
+		{"Write", func(w *ReplyWriter) error {
+			_, err := w.Write([]byte("+OK\r\n"))
+			return err
+		}},
+		{"WriteReply then Flush", func(w *ReplyWriter) error {
+			if n := w.WriteReply(okReply); n != 0 {
+				return errors.New("WriteReply wrote after the error")
+			}
+			return w.Flush()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			w := newReplyWriter(conn)
+			defer w.release()
+
+			w.WriteReply(okReply)
+			conn.err = errBroken
+			if err := w.Flush(); !errors.Is(err, errBroken) {
+				t.Fatalf("Flush returned %v, want %v", err, errBroken)
+			}
+			// The first error is kept, even once the connection works again
+			conn.err = nil
+			if err := tt.write(w); !errors.Is(err, errBroken) {
+				t.Errorf("returned %v, want %v", err, errBroken)
+			}
+			if writes, _ := conn.written(); writes != 0 {
+				t.Errorf("%d writes after the error, want 0", writes)
+			}
+		})
+	}
+}
+
+func TestClientClosedOnWriteError(t *testing.T) {
+	s, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	c.expect(resp.SimpleString("PONG"), "PING")
+
+	// A reply larger than the socket buffers can't be written once the client is
+	// gone, the server must notice and drop the client
+	c.expect(okReply, "SET", "big", string(bytes.Repeat([]byte("x"), 1<<20)))
+	c.send("GET", "big")
+	c.conn.Close()
+	waitFor(t, "the client to be dropped", func() bool {
+		return s.connectedClients() == 0
+	})
+}
+
+func TestReplyWriterConcurrentWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		writers int
+		frames  int
+	}{
+		{"one writer", 1, 1000},
+		{"few writers", 4, 500},
+		{"many writers", 64, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			w := newReplyWriter(conn)
+			defer w.release()
+
+			message := resp.BulkString(bytes.Repeat([]byte("m"), 100))
+			var wg sync.WaitGroup
+			for i := 0; i < tt.writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < tt.frames; j++ {
+						// Replies and pushed messages interleave, as when a client
+						// is sent messages published by others
+						if i%2 == 0 {
+							w.WriteReply(message)
+						} else {
+							w.WritePush(resp.Push{resp.BulkString("message"), message})
+						}
+						if j%10 == 0 {
+							w.Flush()
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+
+			_, data := conn.written()
+			r := resp.NewReader(bytes.NewReader(data))
+			defer r.Release()
+			frames := 0
+			for {
+				reply, err := r.ReadReply()
+				if err != nil {
+					break
+				}
+				switch reply := reply.(type) {
+				case resp.BulkString:
+					if !bytes.Equal(reply, message) {
+						t.Fatalf("frame %d is %q, interleaved with another", frames, reply)
+					}
+				case resp.Array:
+					if len(reply) != 2 || !bytes.Equal(reply[1].(resp.BulkString), message) {
+						t.Fatalf("frame %d is %q, interleaved with another", frames, reply)
+					}
+				default:
+					t.Fatalf("frame %d is %#v", frames, reply)
+				}
+				frames++
+			}
+			if want := tt.writers * tt.frames; frames != want {
+				t.Errorf("read %d frames, want %d", frames, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,766 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// ErrServerClosed is returned by the Server's Serve and ListenAndServe methods
+// after a call to Shutdown.
+var ErrServerClosed = errors.New("server closed")
+
+type Options struct {
+	// Network must be "tcp", "tcp4", "tcp6", "unix" or "unixpacket".
+	Network string
+	// Addrs are the addresses to listen on, plaintext connections are only accepted on
+	// UnixSocket if it's empty.
+	Addrs []string
+	// UnixSocket is the path of a unix socket to listen on, in addition to Addrs.
+	UnixSocket string
+	// Listeners are already bound listeners, such as the sockets passed by systemd,
+	// if any is given they're served instead of binding Addrs and UnixSocket.
+	Listeners []net.Listener
+	// UnixSocketPerm are the permissions of the unix sockets, if zero the default
+	// permissions are kept.
+	UnixSocketPerm os.FileMode
+	// Databases is the number of logical databases.
+	Databases int
+	// DefaultDB is the database selected by the new connections, 0 by default. It
+	// must be less than Databases.
+	DefaultDB int
+	// Storage returns the Storage of the logical database id, such as those of a
+	// DiskStorage. The keys are kept in memory if it's nil.
+	Storage func(id int) Storage
+	// Timeout closes the connection after a client is idle for this duration, zero
+	// disables it.
+	Timeout time.Duration
+	// MaxClients is the maximum number of clients connected at the same time, further
+	// connections are refused. It defaults to DefaultMaxClients.
+	MaxClients int
+	// MaxClientsPerIP is the maximum number of clients connected at the same time
+	// from a single IP address, zero for no limit.
+	MaxClientsPerIP int
+	// AcceptRatePerIP is the number of connections accepted per second from a single
+	// IP address, with bursts of as many, zero for no limit. The connections over the
+	// rate are closed right away.
+	AcceptRatePerIP int
+	// IPLimitsIncludeLocal applies MaxClientsPerIP and AcceptRatePerIP to the clients
+	// connecting through the loopback interface or a unix socket, which are exempt
+	// by default.
+	IPLimitsIncludeLocal bool
+	// TCPKeepAlive is the period of the TCP keepalive probes sent to clients. It
+	// defaults to DefaultTCPKeepAlive, a negative value disables keepalive.
+	TCPKeepAlive time.Duration
+	// Logger is where the server logs its messages, it defaults to a Logger that
+	// writes to stderr messages at LogNotice level or above.
+	Logger *Logger
+	// DisableTCPNoDelay enables Nagle's algorithm on client connections, which
+	// trades latency for fewer packets.
+	DisableTCPNoDelay bool
+
+	// TLSPort is the port to listen on for TLS connections, on the same hosts as Addrs,
+	// or on 127.0.0.1 if Addrs has no TCP addresses. Zero disables TLS.
+	TLSPort int
+	// TLSAddrs are addresses to listen on for TLS connections, in addition to the
+	// ones of TLSPort.
+	TLSAddrs []string
+	// TLSCertFile and TLSKeyFile are the paths of the server certificate and private key.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCACertFile is the path of the CA certificate used to verify clients.
+	TLSCACertFile string
+	// TLSAuthClients is "yes" to require clients to authenticate with a certificate
+	// signed by the CA, "optional" to verify it only if it's provided, or "no".
+	TLSAuthClients string
+	// MetricsAddr is the address of the HTTP server exposing the metrics on /metrics,
+	// in the Prometheus format. The metrics aren't served if it's empty.
+	MetricsAddr string
+	// TracerProvider enables the OpenTelemetry instrumentation: every command, and
+	// every connection accepted and closed, is recorded as a span. Commands aren't
+	// traced if it's nil.
+	TracerProvider trace.TracerProvider
+	// AuditLogFile is the path of the file where every write command executed is
+	// recorded, one per line. The audit log is disabled if it's empty.
+	AuditLogFile string
+	// AuditLogMaxArgLen is the length at which arguments are truncated in the audit
+	// log, DefaultAuditLogMaxArgLen if zero.
+	AuditLogMaxArgLen int
+	// AuditLogMaxSize is the size in bytes after which the audit log is rotated, it's
+	// never rotated if zero.
+	AuditLogMaxSize int64
+	// DebugAddr is the address of the HTTP server exposing the debugging endpoints,
+	// such as the expvar variables on /debug/vars and pprof on /debug/pprof/. They
+	// aren't served if it's empty.
+	DebugAddr string
+	// DebugAllowRemote allows DebugAddr to be a non-loopback address.
+	DebugAllowRemote bool
+	// HTTPAddr is the address of the HTTP gateway, mapping a small REST API and JSON
+	// commands onto the command table, see gatewayHandler. It's disabled if empty.
+	HTTPAddr string
+	// DisableProtectedMode accepts connections from any address. By default, as no
+	// password can be set, only the clients connecting from the same host, through
+	// the loopback interface or a unix socket, are accepted.
+	DisableProtectedMode bool
+	// EnableProtectedConfigs lets CONFIG SET change the protected parameters, such as
+	// logfile, with which clients could write files anywhere the server can.
+	EnableProtectedConfigs bool
+	// TraceProto logs at debug level the raw bytes of every command read and every
+	// reply written, for each connection. It can also be enabled for a single
+	// connection with CLIENT TRACE.
+	TraceProto bool
+	// TraceProtoMaxLen is the number of bytes of each command and reply logged by
+	// TraceProto, DefaultTraceProtoMaxLen if zero.
+	TraceProtoMaxLen int
+	// ValueCompression is "snappy" to compress the values of at least
+	// ValueCompressionMinSize bytes, DefaultValueCompressionMinSize if zero. Values
+	// aren't compressed if it's empty or "no".
+	ValueCompression        string
+	ValueCompressionMinSize int64
+	// Clock tells the time to the server, the real clock if nil.
+	Clock Clock
+	// LazyFreeUserFlush makes FLUSHDB and FLUSHALL asynchronous when neither ASYNC
+	// nor SYNC is given.
+	LazyFreeUserFlush bool
+}
+
+const DefaultMaxClients = 10000
+
+// Server is a Redis server. Each Server holds its own databases, so several of them
+// can run in the same process.
+type Server struct {
+	opts         Options
+	logger       *Logger
+	databases    []*Database
+	configParams map[string]configParam
+
+	commandsMu sync.RWMutex
+	commands   map[string]*command
+	// middleware are the ones added with Use, dispatch holds the CommandHandler
+	// chaining them around the command handlers
+	middleware []Middleware
+	dispatch   atomic.Value
+
+	pubsub      *pubsub
+	tracking    *trackingTable
+	stats       serverStats
+	httpServers []*http.Server
+	tracer      trace.Tracer
+	audit       *auditLog
+	compression valueCompression
+	clock       Clock
+	ipLimits    *ipLimiter
+
+	// timeout is the idle timeout in nanoseconds, it's accessed atomically
+	timeout      int64
+	maxClients   int64
+	keepAlive    int64
+	noDelay      int32
+	lazyFlush    int32
+	notifyFlags  int32
+	protected    int32
+	traceProto   int32
+	lastClientID uint64
+	// maxClientsPerIP, acceptRatePerIP and ipLimitsExemptLocal configure ipLimits
+	maxClientsPerIP     int64
+	acceptRatePerIP     int64
+	ipLimitsExemptLocal int32
+	// traceProtoMaxLen is the number of bytes of each frame logged when tracing
+	traceProtoMaxLen int64
+	// obufLimits holds the outputBufferLimits of each client class, obufLimitsMu
+	// serializes their changes
+	obufLimits   atomic.Value
+	obufLimitsMu sync.Mutex
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	clients   map[*Client]struct{}
+	ready     chan struct{}
+	wg        sync.WaitGroup
+	closing   bool
+	// done is closed by Shutdown, to stop clientsCron
+	done     chan struct{}
+	cronOnce sync.Once
+}
+
+// New creates a Server configured with opts. The server starts accepting connections
+// once Serve or ListenAndServe is called.
+func New(opts Options) *Server {
+	s := &Server{
+		opts:      opts,
+		pubsub:    newPubSub(),
+		tracking:  newTrackingTable(),
+		listeners: make(map[net.Listener]struct{}),
+		clients:   make(map[*Client]struct{}),
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+		clock:     opts.Clock,
+		ipLimits:  newIPLimiter(),
+		timeout:   int64(opts.Timeout),
+		logger:    opts.Logger,
+	}
+	if s.logger == nil {
+		s.logger = NewLogger(os.Stderr, LogNotice)
+	}
+	if s.clock == nil {
+		s.clock = realClock{}
+	}
+	if opts.MaxClients > 0 {
+		s.maxClients = int64(opts.MaxClients)
+	} else {
+		s.maxClients = DefaultMaxClients
+	}
+	if opts.TCPKeepAlive == 0 {
+		s.keepAlive = int64(DefaultTCPKeepAlive)
+	} else if opts.TCPKeepAlive > 0 {
+		s.keepAlive = int64(opts.TCPKeepAlive)
+	}
+	if !opts.DisableTCPNoDelay {
+		s.noDelay = 1
+	}
+	if !opts.DisableProtectedMode {
+		s.protected = 1
+	}
+	if opts.LazyFreeUserFlush {
+		s.lazyFlush = 1
+	}
+	s.maxClientsPerIP = int64(opts.MaxClientsPerIP)
+	s.acceptRatePerIP = int64(opts.AcceptRatePerIP)
+	if !opts.IPLimitsIncludeLocal {
+		s.ipLimitsExemptLocal = 1
+	}
+	if opts.ValueCompression == "snappy" {
+		s.compression.enabled = 1
+	}
+	if opts.ValueCompressionMinSize > 0 {
+		s.compression.minSize = opts.ValueCompressionMinSize
+	} else {
+		s.compression.minSize = DefaultValueCompressionMinSize
+	}
+	s.databases = newDatabases(opts.Databases, opts.Storage, &s.compression)
+	if opts.DefaultDB < 0 || opts.DefaultDB >= len(s.databases) {
+		s.logger.Warningf("DefaultDB %d is out of range, using 0", opts.DefaultDB)
+		s.opts.DefaultDB = 0
+	}
+	if opts.TraceProto {
+		s.traceProto = 1
+	}
+	if opts.TraceProtoMaxLen > 0 {
+		s.traceProtoMaxLen = int64(opts.TraceProtoMaxLen)
+	} else {
+		s.traceProtoMaxLen = DefaultTraceProtoMaxLen
+	}
+	s.obufLimits.Store(defaultOutputBufferLimits)
+	s.buildDispatch()
+	if opts.TracerProvider != nil {
+		s.tracer = opts.TracerProvider.Tracer(tracerName)
+	}
+	s.configParams = s.newConfigParams()
+	publishExpvar(s)
+	s.commands = make(map[string]*command)
+	builtins := []struct {
+		name  string
+		arity int
+		flags CommandFlags
+		// firstKey, lastKey and keyStep are the positions of the keys in the
+		// arguments, see command.keys
+		firstKey, lastKey, keyStep int
+		handler                    commandHandler
+	}{
+		{"client", -2, FlagAdmin | FlagNoScript | FlagLoading | FlagStale, 0, 0, 0, s.client},
+		{"command", -1, FlagLoading | FlagStale, 0, 0, 0, s.commandCmd},
+		{"config", -2, FlagAdmin | FlagNoScript | FlagLoading | FlagStale, 0, 0, 0, s.config},
+		{"dbsize", 1, FlagReadOnly | FlagFast, 0, 0, 0, s.dbSize},
+		{"debug", -2, FlagAdmin | FlagNoScript | FlagLoading | FlagStale, 0, 0, 0, s.debug},
+		{"decr", 2, FlagWrite | FlagDenyOOM | FlagFast, 1, 1, 1, s.incrDecrGenerator(dirDecr, false)},
+		{"decrby", 3, FlagWrite | FlagDenyOOM | FlagFast, 1, 1, 1, s.incrDecrGenerator(dirDecr, true)},
+		{"del", -2, FlagWrite, 1, -1, 1, s.del},
+		{"echo", 2, FlagFast, 0, 0, 0, s.echo},
+		{"exists", -2, FlagReadOnly | FlagFast, 1, -1, 1, s.exists},
+		{"flushall", -1, FlagWrite, 0, 0, 0, s.flushAll},
+		{"flushdb", -1, FlagWrite, 0, 0, 0, s.flushDB},
+		{"get", 2, FlagReadOnly | FlagFast, 1, 1, 1, s.get},
+		{"hello", -1, FlagNoScript | FlagLoading | FlagStale | FlagFast | FlagNoAuth, 0, 0, 0, s.hello},
+		{"incr", 2, FlagWrite | FlagDenyOOM | FlagFast, 1, 1, 1, s.incrDecrGenerator(dirIncr, false)},
+		{"incrby", 3, FlagWrite | FlagDenyOOM | FlagFast, 1, 1, 1, s.incrDecrGenerator(dirIncr, true)},
+		{"info", -1, FlagLoading | FlagStale, 0, 0, 0, s.info},
+		{"keys", 2, FlagReadOnly, 0, 0, 0, s.keys},
+		{"memory", -2, FlagReadOnly, 0, 0, 0, s.memory},
+		{"move", 3, FlagWrite | FlagFast, 1, 1, 1, s.move},
+		{"ping", -1, FlagFast, 0, 0, 0, s.ping},
+		{"pubsub", -2, FlagPubSub | FlagLoading | FlagStale, 0, 0, 0, s.pubsubCmd},
+		{"quit", -1, FlagNoScript | FlagLoading | FlagStale | FlagFast | FlagNoAuth, 0, 0, 0, s.quit},
+		{"randomkey", 1, FlagReadOnly, 0, 0, 0, s.randomKey},
+		{"select", 2, FlagLoading | FlagStale | FlagFast, 0, 0, 0, s.selectDB},
+		{"set", 3, FlagWrite | FlagDenyOOM, 1, 1, 1, s.set},
+		{"spublish", 3, FlagPubSub | FlagLoading | FlagStale | FlagFast, 0, 0, 0, s.spublish},
+		{"ssubscribe", -2, FlagPubSub | FlagNoScript | FlagLoading | FlagStale, 0, 0, 0, s.ssubscribe},
+		{"sunsubscribe", -1, FlagPubSub | FlagNoScript | FlagLoading | FlagStale, 0, 0, 0, s.sunsubscribe},
+	}
+	for _, cmd := range builtins {
+		err := s.addCommand(&command{
+			name:     cmd.name,
+			arity:    cmd.arity,
+			flags:    cmd.flags,
+			firstKey: cmd.firstKey,
+			lastKey:  cmd.lastKey,
+			keyStep:  cmd.keyStep,
+			handler:  cmd.handler,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// ListenAndServe listens on every address configured in the Options: Addrs, the
+// unix socket and, if TLS is enabled, the TLS port, and then calls Serve to handle
+// incoming connections on each of them. It returns once all the listeners are closed.
+// The HTTP endpoints enabled by MetricsAddr and DebugAddr are served until the server
+// shuts down.
+func (s *Server) ListenAndServe() error {
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if err := s.listenHTTP(); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	if err := s.openAuditLog(); err != nil {
+		closeListeners(listeners)
+		s.closeHTTP()
+		return err
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		s.logger.Noticef("Listening on %s", ln.Addr())
+		go func(ln net.Listener) {
+			errs <- s.Serve(ln)
+		}(ln)
+	}
+	close(s.ready)
+
+	// If a listener fails the other ones are closed too, the first error is returned.
+	var first error
+	for range listeners {
+		err := <-errs
+		if first == nil {
+			first = err
+			if err != ErrServerClosed {
+				closeListeners(listeners)
+				s.closeHTTP()
+			}
+		}
+	}
+	return first
+}
+
+// Ready returns a channel that's closed once ListenAndServe has bound every address
+// and the server is accepting connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// listen binds all the configured addresses. If any of them fails, the listeners
+// that were already bound are closed.
+func (s *Server) listen() ([]net.Listener, error) {
+	listeners := append([]net.Listener{}, s.opts.Listeners...)
+	addrs, unixSocket := s.opts.Addrs, s.opts.UnixSocket
+	if len(s.opts.Listeners) > 0 {
+		addrs, unixSocket = nil, ""
+	}
+
+	for _, addr := range addrs {
+		var ln net.Listener
+		var err error
+		if strings.HasPrefix(s.opts.Network, "unix") {
+			ln, err = s.listenUnix(s.opts.Network, addr)
+		} else {
+			ln, err = net.Listen(s.opts.Network, addr)
+			if err != nil {
+				err = fmt.Errorf("failed to start listening on %s: %w", addr, err)
+			}
+		}
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	if unixSocket != "" {
+		ln, err := s.listenUnix("unix", unixSocket)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	if s.opts.TLSPort != 0 || len(s.opts.TLSAddrs) > 0 {
+		tlsListeners, err := s.listenTLS()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, tlsListeners...)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, ln := range listeners {
+		ln.Close()
+	}
+}
+
+// Serve accepts incoming connections on the listener, handling each of them in a
+// new goroutine. Serve always closes ln and returns a non-nil error, after Shutdown
+// the returned error is ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+
+	if !s.trackListener(ln) {
+		return ErrServerClosed
+	}
+	defer s.untrackListener(ln)
+	s.cronOnce.Do(func() {
+		go s.clientsCron()
+	})
+
+	// How long to sleep on accept failure
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			// Temporary errors, such as running out of file descriptors, are retried
+			// with an exponential backoff so that the loop doesn't spin.
+			if ne, ok := err.(interface{ Temporary() bool }); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				s.logger.Warningf("Accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		ip, err := s.admitConn(conn)
+		if err == errAcceptRateLimited {
+			conn.Close()
+			continue
+		}
+		if err != nil {
+			go rejectConn(conn, maxClientsPerIPReply)
+			continue
+		}
+		s.configureConn(conn)
+
+		// New connections use DefaultDB, 0 unless configured otherwise
+		c := newClient(atomic.AddUint64(&s.lastClientID, 1), conn, s.databases[s.opts.DefaultDB], s.clock.Now())
+		c.ip = ip
+		c.conn.checkPushed = func(queued int) bool {
+			return s.checkPushed(c, queued)
+		}
+		if err := s.trackClient(c); err != nil {
+			s.releaseIP(ip)
+			if err == ErrServerClosed {
+				conn.Close()
+				return err
+			}
+			c.conn.release()
+			go rejectConn(conn, resp.Error("ERR "+err.Error()))
+			continue
+		}
+		atomic.AddInt64(&s.stats.connectionsReceived, 1)
+		if s.tracer != nil {
+			s.traceConnection("connection.accept", c)
+		}
+		go s.handleConnection(c)
+	}
+}
+
+// rejectTimeout bounds the time spent telling a refused client why.
+const rejectTimeout = time.Second
+
+// rejectConn tells a client refused by the accept loop why and closes its
+// connection. It's run in its own goroutine, as a client stalling the TLS handshake
+// would otherwise block the accept loop.
+func rejectConn(conn net.Conn, reply Reply) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	resp.NewWriter(conn).WriteReply(reply)
+}
+
+// Shutdown gracefully shuts down the server: it closes the listeners, stops every
+// connection from reading new commands and waits for them to reply to the commands
+// they already received. If ctx expires first the remaining connections are closed
+// forcibly and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closing {
+		close(s.done)
+	}
+	s.closing = true
+	for ln := range s.listeners {
+		// For unix sockets this also removes the socket file.
+		ln.Close()
+	}
+	for c := range s.clients {
+		c.interrupt()
+	}
+	httpServers := s.httpServers
+	s.mu.Unlock()
+
+	for _, srv := range httpServers {
+		srv.Shutdown(ctx)
+	}
+	unpublishExpvar(s)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.closeAuditLog()
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for c := range s.clients {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+		s.closeAuditLog()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+func (s *Server) trackListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(ln net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.listeners, ln)
+}
+
+// trackClient registers a new client, it must be called before its connection is
+// handled. Clients are only added to the wait group while the server is not
+// shutting down, so that Shutdown never waits on a growing group, and while there
+// are less than maxclients connected.
+func (s *Server) trackClient(c *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return ErrServerClosed
+	}
+	if int64(len(s.clients)) >= atomic.LoadInt64(&s.maxClients) {
+		return maxClientsReachedError
+	}
+	s.clients[c] = struct{}{}
+	s.wg.Add(1)
+	return nil
+}
+
+// connectedClients returns the number of clients currently connected.
+func (s *Server) connectedClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+func (s *Server) untrackClient(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, c)
+	s.wg.Done()
+	s.releaseIP(c.ip)
+}
+
+// handleConnection reads and executes the commands sent by the client until the
+// connection is closed. Whatever the reason the loop exits for, the connection is
+// closed and the client state is released by the deferred calls.
+func (s *Server) handleConnection(client *Client) {
+	defer s.untrackClient(client)
+	defer s.pubsub.unsubscribeAll(client)
+	defer s.tracking.disable(client)
+	conn := client.conn
+	defer conn.release()
+	defer conn.Close()
+
+	s.logger.Noticef("Accepted %s id=%d", conn.RemoteAddr(), client.id)
+	s.logger.Debugf("Client id=%d protocol=RESP2 user=default", client.id)
+	reason := "EOF"
+	defer func() {
+		s.logger.Noticef(
+			"Client id=%d addr=%s disconnected (%s) after %s",
+			client.id, conn.RemoteAddr(), reason, s.clock.Now().Sub(client.createdAt).Round(time.Millisecond),
+		)
+		if s.tracer != nil {
+			s.traceConnection("connection.close", client, attribute.String("db.redis.close_reason", reason))
+		}
+	}()
+
+	if s.protectedMode() && !isLocalConn(conn) {
+		conn.WriteReply(protectedModeReply)
+		conn.Flush()
+		reason = "protected mode"
+		return
+	}
+
+	reader := resp.NewReader(flushingReader{conn})
+	defer reader.Release()
+	client.reader = reader
+	if s.traceProtoEnabled() {
+		s.setProtoTrace(client, true)
+	}
+
+	for {
+		client.waitCommand(s.clock.Now())
+		args, err := reader.ReadCommand()
+		if err != nil {
+			var protoErr *resp.ProtocolError
+			if errors.As(err, &protoErr) {
+				// As Redis does, the client is told what went wrong before closing
+				// the connection, since the rest of the stream can't be trusted.
+				s.logger.Verbosef("%v from client id=%d addr=%s", err, client.id, conn.RemoteAddr())
+				conn.WriteReply(resp.Error("ERR " + err.Error()))
+				conn.Flush()
+				reason = "protocol error"
+				return
+			}
+			reason = client.closeReason(err)
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if s.logger.Enabled(LogDebug) {
+			s.logger.Debugf("Command received %q", args)
+		}
+		s.handleCommand(client, args[0], args[1:])
+		if client.closeAfterReply != "" {
+			conn.Flush()
+			reason = client.closeAfterReply
+			return
+		}
+	}
+}
+
+// call runs cmd through the middleware and its handler, recovering from a panic so
+// that a bug in a command only affects the connection that sent it. The panic is
+// logged with the command, the client is told about the error and its connection is
+// closed, as the state left behind by the handler can't be trusted.
+func (s *Server) call(c *Client, cmd *Command) (reply Reply) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Warningf(
+				"Panic executing '%s' with %d arguments from client id=%d addr=%s: %v\n%s",
+				cmd.Name, len(cmd.Args), c.id, c.conn.RemoteAddr(), r, debug.Stack(),
+			)
+			if s.logger.Enabled(LogDebug) {
+				s.logger.Debugf("Arguments of the panicking command %q", cmd.Args)
+			}
+			reply = resp.Error("ERR internal error")
+			c.closeAfterReply = "panic"
+		}
+	}()
+	return s.dispatch.Load().(CommandHandler)(c, cmd)
+}
+
+// handleCommand executes the command named name and writes its reply. The arguments
+// are only valid until the reply is written, as the buffer holding them is reused to
+// read the next command.
+func (s *Server) handleCommand(c *Client, name []byte, args [][]byte) {
+	defer c.conn.endCommand()
+
+	cmd, ok := s.findCommand(name)
+	if !ok {
+		c.conn.WriteReply(unknownCommandReply(name, args))
+		return
+	}
+	reply, span := s.execute(c, cmd, args)
+	size := c.conn.WriteReply(reply)
+	if span != nil {
+		endCommandSpan(span, reply, size)
+	}
+}
+
+// execute checks that c can call cmd with args and runs it, recording its
+// statistics. The span tracing the command is returned for the caller to end once
+// the reply is sent, it's nil if tracing is disabled or the command was rejected.
+func (s *Server) execute(c *Client, cmd *command, args [][]byte) (Reply, trace.Span) {
+	c.setCommand(cmd.name, s.clock.Now())
+	if !cmd.checkArity(len(args) + 1) {
+		return wrongNumArgsReply(cmd.name), nil
+	}
+	if c.subscriptions() > 0 && !subscribedAllowed[cmd.name] {
+		return resp.Error(fmt.Sprintf(
+			"ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+			cmd.name,
+		)), nil
+	}
+	if c.db.maxKeySize > 0 && cmd.flags&FlagWrite != 0 && c.db.keysTooLarge(cmd.keys(args)) {
+		return keyTooLargeReply, nil
+	}
+	var span trace.Span
+	if s.tracer != nil {
+		span = s.startCommandSpan(c, cmd, args)
+	}
+	c.caching, c.nextCaching = c.nextCaching, cachingDefault
+	c.command = Command{Name: cmd.name, Args: args, Flags: cmd.flags, cmd: cmd}
+	reply := s.call(c, &c.command)
+	c.command = Command{}
+	atomic.AddInt64(&s.stats.commandsProcessed, 1)
+	return reply, span
+}
@@ -0,0 +1,682 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// testTimeout bounds every wait of the tests on the server, so that a bug hangs a
+// test for seconds rather than until the whole run times out.
+const testTimeout = 5 * time.Second
+
+// newTestServer serves a Server created with opts on a loopback port, and shuts it
+// down at the end of the test. It returns the server and the address to dial.
+func newTestServer(t testing.TB, opts Options) (*Server, string) {
+	t.Helper()
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Databases == 0 {
+		opts.Databases = 16
+	}
+	if opts.Logger == nil {
+		opts.Logger = NewLogger(io.Discard, LogWarning)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := New(opts)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	})
+	return s, ln.Addr().String()
+}
+
+// testClient sends commands to a test server and reads its replies.
+type testClient struct {
+	t    testing.TB
+	conn net.Conn
+	buf  *bufio.Writer
+	w    *resp.Writer
+	r    *resp.Reader
+}
+
+// dialTest connects to addr, the connection is closed at the end of the test.
+func dialTest(t testing.TB, addr string) *testClient {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, testTimeout)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newTestClient(t, conn)
+}
+
+func newTestClient(t testing.TB, conn net.Conn) *testClient {
+	buf := bufio.NewWriter(conn)
+	return &testClient{t: t, conn: conn, buf: buf, w: resp.NewWriter(buf), r: resp.NewReader(conn)}
+}
+
+// send writes a command without waiting for its reply.
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	c.w.WriteArrayLen(len(args))
+	for _, arg := range args {
+		c.w.WriteBulkString(arg)
+	}
+	if err := c.buf.Flush(); err != nil {
+		c.t.Fatalf("failed to send %q: %v", args, err)
+	}
+}
+
+// read returns the next reply, failing the test if none arrives in time.
+func (c *testClient) read() resp.Reply {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	reply, err := c.r.ReadReply()
+	if err != nil {
+		c.t.Fatalf("failed to read reply: %v", err)
+	}
+	return reply
+}
+
+// do sends a command and returns its reply.
+func (c *testClient) do(args ...string) resp.Reply {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// expect sends a command and fails the test unless it gets want as reply.
+func (c *testClient) expect(want resp.Reply, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); !reflect.DeepEqual(got, want) {
+		c.t.Errorf("%q = %#v, want %#v", args, got, want)
+	}
+}
+
+// expectClosed fails the test unless the server closes the connection, after the
+// replies already sent.
+func (c *testClient) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		_, err := c.r.ReadReply()
+		if err == nil {
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.t.Errorf("connection not closed: %v", err)
+		}
+		return
+	}
+}
+
+// waitFor polls cond until it's true, failing the test if it's still false after
+// testTimeout.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPipelinedCommands(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		c.w.WriteArrayLen(1)
+		c.w.WriteBulkString("PING")
+	}
+	if err := c.buf.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if got := c.read(); got != resp.Reply(resp.SimpleString("PONG")) {
+			t.Fatalf("reply %d = %#v, want PONG", i, got)
+		}
+	}
+}
+
+// TestClientHandshakes replays the bytes sent by client libraries when they open a
+// connection, the handshake must get a reply to each command, errors included, for
+// the connection to be handed to the application.
+func TestClientHandshakes(t *testing.T) {
+	unknownSetInfo := func(name string) resp.Reply {
+		return resp.Error("ERR unknown subcommand '" + name + "'. Try CLIENT HELP.")
+	}
+	type step struct {
+		// sent are the bytes written at once, replies the ones expected in return
+		sent    string
+		replies []resp.Reply
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			// go-redis v9 sends HELLO alone, then pipelines CLIENT SETINFO and ignores
+			// the errors
+			"go-redis", []step{
+				{"*2\r\n$5\r\nhello\r\n$1\r\n3\r\n", []resp.Reply{noProtoReply}},
+				{
+					"*4\r\n$6\r\nclient\r\n$7\r\nsetinfo\r\n$8\r\nLIB-NAME\r\n$19\r\ngo-redis(,go1.21.0)\r\n" +
+						"*4\r\n$6\r\nclient\r\n$7\r\nsetinfo\r\n$7\r\nLIB-VER\r\n$5\r\n9.0.5\r\n",
+					[]resp.Reply{unknownSetInfo("setinfo"), unknownSetInfo("setinfo")},
+				},
+			},
+		},
+		{
+			// redis-py sends HELLO 3 with protocol=3, then each CLIENT SETINFO on its
+			// own, ignoring the errors
+			"redis-py", []step{
+				{"*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", []resp.Reply{noProtoReply}},
+				{"*4\r\n$6\r\nCLIENT\r\n$7\r\nSETINFO\r\n$8\r\nLIB-NAME\r\n$8\r\nredis-py\r\n", []resp.Reply{unknownSetInfo("SETINFO")}},
+				{"*4\r\n$6\r\nCLIENT\r\n$7\r\nSETINFO\r\n$7\r\nLIB-VER\r\n$5\r\n5.0.1\r\n", []resp.Reply{unknownSetInfo("SETINFO")}},
+			},
+		},
+	}
+	_, addr := newTestServer(t, Options{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialTest(t, addr)
+			for _, step := range tt.steps {
+				if _, err := io.WriteString(c.conn, step.sent); err != nil {
+					t.Fatal(err)
+				}
+				for _, want := range step.replies {
+					if got := c.read(); !reflect.DeepEqual(got, want) {
+						t.Errorf("reply to %q = %#v, want %#v", step.sent, got, want)
+					}
+				}
+			}
+			// The connection is left usable, with RESP2
+			c.expect(okReply, "SET", "key:"+tt.name, "value")
+			c.expect(resp.BulkString("value"), "GET", "key:"+tt.name)
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// release lets the command in flight complete before the deadline
+		release bool
+		wantErr error
+	}{
+		{"command in flight completes", true, nil},
+		{"deadline exceeded", false, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := newTestServer(t, Options{})
+			started, release := make(chan struct{}), make(chan struct{})
+			s.RegisterCommand("slow", 1, 0, func(c *Client, args [][]byte) Reply {
+				close(started)
+				<-release
+				return okReply
+			})
+			released := false
+			t.Cleanup(func() {
+				if !released {
+					close(release)
+				}
+			})
+
+			idle := dialTest(t, addr)
+			idle.expect(resp.SimpleString("PONG"), "PING")
+			busy := dialTest(t, addr)
+			busy.send("SLOW")
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			shutdown := make(chan error, 1)
+			go func() {
+				shutdown <- s.Shutdown(ctx)
+			}()
+			if tt.release {
+				released = true
+				close(release)
+			}
+			if err := <-shutdown; err != tt.wantErr {
+				t.Errorf("Shutdown returned %v, want %v", err, tt.wantErr)
+			}
+			if tt.release {
+				if got := busy.read(); got != resp.Reply(okReply) {
+					t.Errorf("reply to the command in flight = %#v, want OK", got)
+				}
+			}
+			busy.expectClosed()
+			idle.expectClosed()
+			if conn, err := net.DialTimeout("tcp", addr, testTimeout); err == nil {
+				conn.Close()
+				t.Error("new connection accepted after Shutdown")
+			}
+		})
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxClients int
+	}{
+		{"one client", 1},
+		{"several clients", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := newTestServer(t, Options{MaxClients: tt.maxClients})
+			clients := []*testClient{}
+			for i := 0; i < tt.maxClients; i++ {
+				c := dialTest(t, addr)
+				c.expect(resp.SimpleString("PONG"), "PING")
+				clients = append(clients, c)
+			}
+
+			refused := dialTest(t, addr)
+			if got := refused.read(); got != resp.Reply(resp.Error("ERR max number of clients reached")) {
+				t.Errorf("client over the limit got %#v", got)
+			}
+			refused.expectClosed()
+
+			info := string(clients[0].do("INFO", "clients").(resp.BulkString))
+			want := fmt.Sprintf("connected_clients:%d\r\nmaxclients:%d\r\n", tt.maxClients, tt.maxClients)
+			if !strings.Contains(info, want) {
+				t.Errorf("INFO clients = %q, want it to contain %q", info, want)
+			}
+
+			// A client leaving makes room for another
+			clients[0].conn.Close()
+			waitFor(t, "the client to leave", func() bool {
+				return s.connectedClients() == tt.maxClients-1
+			})
+			replacement := dialTest(t, addr)
+			replacement.expect(resp.SimpleString("PONG"), "PING")
+
+			// Raising the limit at runtime applies to the next connections
+			replacement.expect(okReply, "CONFIG", "SET", "maxclients", strconv.Itoa(tt.maxClients+1))
+			dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+		})
+	}
+}
+
+// temporaryError is an Accept error the server should retry.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// scriptedListener returns the errors, or connections, of its script from Accept,
+// then blocks until it's closed. It records when Accept was called.
+type scriptedListener struct {
+	script []interface{}
+	closed chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (l *scriptedListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, time.Now())
+	var next interface{}
+	if len(l.script) > 0 {
+		next, l.script = l.script[0], l.script[1:]
+	}
+	l.mu.Unlock()
+
+	switch next := next.(type) {
+	case error:
+		return nil, next
+	case net.Conn:
+		return next, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *scriptedListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *scriptedListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6379}
+}
+
+func (l *scriptedListener) acceptCalls() []time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]time.Time(nil), l.calls...)
+}
+
+func TestServeAcceptErrors(t *testing.T) {
+	errPermanent := errors.New("listener broken")
+	tests := []struct {
+		name     string
+		failures int
+		// permanent ends the script with an error Serve must return
+		permanent bool
+	}{
+		{"one temporary error", 1, false},
+		{"several temporary errors", 4, false},
+		{"permanent error", 0, true},
+		{"permanent error after temporary ones", 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{Network: "tcp", Databases: 16, Logger: NewLogger(io.Discard, LogWarning)})
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+				defer cancel()
+				s.Shutdown(ctx)
+			}()
+			ln := &scriptedListener{closed: make(chan struct{})}
+			for i := 0; i < tt.failures; i++ {
+				ln.script = append(ln.script, temporaryError{})
+			}
+			var client net.Conn
+			if tt.permanent {
+				ln.script = append(ln.script, errPermanent)
+			} else {
+				// A loopback connection, that protected mode lets in
+				tcp, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tcp.Close()
+				client, err = net.Dial("tcp", tcp.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer client.Close()
+				server, err := tcp.Accept()
+				if err != nil {
+					t.Fatal(err)
+				}
+				ln.script = append(ln.script, server)
+			}
+
+			served := make(chan error, 1)
+			go func() {
+				served <- s.Serve(ln)
+			}()
+			if tt.permanent {
+				select {
+				case err := <-served:
+					if err != errPermanent {
+						t.Errorf("Serve returned %v, want %v", err, errPermanent)
+					}
+				case <-time.After(testTimeout):
+					t.Fatal("Serve didn't return on a permanent error")
+				}
+			} else {
+				// The connection accepted after the errors is served
+				newTestClient(t, client).expect(resp.SimpleString("PONG"), "PING")
+			}
+
+			// Each retry waits at least twice as long as the previous one, from 5ms
+			calls := ln.acceptCalls()
+			if len(calls) < tt.failures+1 {
+				t.Fatalf("Accept called %d times, want at least %d", len(calls), tt.failures+1)
+			}
+			delay := 5 * time.Millisecond
+			for i := 1; i <= tt.failures; i++ {
+				if waited := calls[i].Sub(calls[i-1]); waited < delay {
+					t.Errorf("retry %d after %v, want at least %v", i, waited, delay)
+				}
+				delay *= 2
+			}
+		})
+	}
+}
+
+func TestServeAcceptErrorAfterShutdown(t *testing.T) {
+	s := New(Options{Network: "tcp", Databases: 16, Logger: NewLogger(io.Discard, LogWarning)})
+	ln := &scriptedListener{closed: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ln)
+	}()
+	waitFor(t, "Serve to accept", func() bool { return len(ln.acceptCalls()) > 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, to capture the logs.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPanicRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// command is the command panicking, registered or built-in
+		command []string
+		// middleware panics instead of the command, if set
+		middleware bool
+	}{
+		{"panic with a string", []string{"PANIC", "string"}, false},
+		{"panic with an error", []string{"PANIC", "error"}, false},
+		{"runtime error", []string{"PANIC", "nil"}, false},
+		{"panic in a middleware", []string{"GET", "key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &syncBuffer{}
+			s, addr := newTestServer(t, Options{Logger: NewLogger(logs, LogWarning)})
+			s.RegisterCommand("panic", 2, 0, func(c *Client, args [][]byte) Reply {
+				switch string(args[0]) {
+				case "string":
+					panic("boom")
+				case "error":
+					panic(errors.New("boom"))
+				}
+				var m map[string]int
+				m["boom"]++
+				return okReply
+			})
+			if tt.middleware {
+				s.Use(func(next CommandHandler) CommandHandler {
+					return func(c *Client, cmd *Command) Reply {
+						if cmd.Name == "get" {
+							panic("boom")
+						}
+						return next(c, cmd)
+					}
+				})
+			}
+
+			other := dialTest(t, addr)
+			other.expect(okReply, "SET", "key", "value")
+			c := dialTest(t, addr)
+			c.expect(resp.Error("ERR internal error"), tt.command...)
+			c.expectClosed()
+
+			// Only the connection that sent the command is closed
+			other.expect(resp.SimpleString("PONG"), "PING")
+			dialTest(t, addr).expect(resp.SimpleString("PONG"), "PING")
+			if !strings.Contains(logs.String(), "Panic executing '"+strings.ToLower(tt.command[0])+"'") {
+				t.Errorf("panic not logged:\n%s", logs)
+			}
+		})
+	}
+}
+
+// TestConcurrentClientsAcrossDatabases stresses the selection of the databases by
+// fresh connections, run it with -race.
+func TestConcurrentClientsAcrossDatabases(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients int
+		// commands is the number of commands sent by each client
+		commands int
+	}{
+		{"many short-lived clients", 64, 10},
+		{"few busy clients", 8, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			errs := make(chan error, tt.clients)
+			var wg sync.WaitGroup
+			for i := 0; i < tt.clients; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- stressDatabases(addr, i, tt.commands)
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+// stressDatabases connects to addr and writes then reads keys, alternating between
+// the default database and the database i%16. The keys are shared by every client,
+// with a value telling the databases apart.
+func stressDatabases(addr string, i, commands int) error {
+	conn, err := net.DialTimeout("tcp", addr, testTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	buf := bufio.NewWriter(conn)
+	w, r := resp.NewWriter(buf), resp.NewReader(conn)
+	defer r.Release()
+	do := func(args ...string) (resp.Reply, error) {
+		w.WriteArrayLen(len(args))
+		for _, arg := range args {
+			w.WriteBulkString(arg)
+		}
+		if err := buf.Flush(); err != nil {
+			return nil, err
+		}
+		return r.ReadReply()
+	}
+
+	// A fresh connection starts on database 0
+	if reply, err := do("GET", "db"); err != nil || !reflect.DeepEqual(reply, resp.Reply(resp.BulkString("0"))) && reply != resp.Reply(resp.Null{}) {
+		return fmt.Errorf("client %d: GET on a new connection = %#v, %v", i, reply, err)
+	}
+	for j := 0; j < commands; j++ {
+		db := strconv.Itoa(i % 16)
+		if j%2 == 0 {
+			db = "0"
+		}
+		for _, args := range [][]string{{"SELECT", db}, {"SET", "db", db}, {"SET", "key:" + strconv.Itoa(i), db}} {
+			if reply, err := do(args...); err != nil || reply != resp.Reply(okReply) {
+				return fmt.Errorf("client %d: %q = %#v, %v", i, args, reply, err)
+			}
+		}
+		// Other clients overwrite db, but only with the number of this database
+		for _, key := range []string{"db", "key:" + strconv.Itoa(i)} {
+			reply, err := do("GET", key)
+			if err != nil {
+				return err
+			}
+			if value, ok := reply.(resp.BulkString); !ok || string(value) != db {
+				return fmt.Errorf("client %d: GET %s on database %s = %#v", i, key, db, reply)
+			}
+		}
+	}
+	return nil
+}
+
+func TestDatabaseCount(t *testing.T) {
+	s, addr := newTestServer(t, Options{Databases: 4})
+	c := dialTest(t, addr)
+	outOfRange := resp.Error("ERR DB index is out of range")
+	tests := []struct {
+		index string
+		want  resp.Reply
+	}{
+		{"0", okReply},
+		{"3", okReply},
+		{"4", outOfRange},
+		{"-1", outOfRange},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, "SELECT", tt.index)
+	}
+	if _, err := s.ExecuteCommand(context.Background(), 4, "PING"); err == nil {
+		t.Error("ExecuteCommand ran on database 4 of 4")
+	}
+
+	req := httptest.NewRequest("GET", "/v1/keys/key?db=4", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	s.gatewayHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("gateway on database 4 of 4: status %d, want 400", rec.Code)
+	}
+
+	if got := New(Options{Databases: 4, DefaultDB: 4, Logger: NewLogger(io.Discard, LogWarning)}).opts.DefaultDB; got != 0 {
+		t.Errorf("DefaultDB 4 of 4 databases kept as %d, want 0", got)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in microseconds, of the buckets of the command
+// latency histograms.
+var latencyBuckets = []int64{10, 50, 100, 500, 1000, 5000, 10000, 50000, 100000, 500000, 1000000}
+
+// commandStats are the statistics of a single command, reported by INFO commandstats
+// and by the metrics endpoint. Fields are accessed atomically.
+type commandStats struct {
+	calls int64
+	usec  int64
+	// buckets counts the calls by latency, the last bucket holds the calls slower
+	// than the largest bound.
+	buckets [12]int64
+}
+
+func (st *commandStats) record(d time.Duration) {
+	usec := d.Microseconds()
+	atomic.AddInt64(&st.calls, 1)
+	atomic.AddInt64(&st.usec, usec)
+	i := 0
+	for i < len(latencyBuckets) && usec > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&st.buckets[i], 1)
+}
+
+// serverStats are the server wide statistics reported by INFO stats. Fields are
+// accessed atomically.
+type serverStats struct {
+	commandsProcessed   int64
+	connectionsReceived int64
+	keyspaceHits        int64
+	keyspaceMisses      int64
+	expiredKeys         int64
+	evictedKeys         int64
+	// outputBufferLimitDisconnections counts the clients closed by checkPushed
+	outputBufferLimitDisconnections int64
+	// ipRejectedConnections and ipRateLimitedConnections count the connections
+	// refused by maxclients-per-ip and accept-rate-per-ip
+	ipRejectedConnections    int64
+	ipRateLimitedConnections int64
+}
+
+// lookupKeyRead reads key from the database selected by the client, counting the
+// keyspace hits and misses and remembering the key for client side caching.
+func (s *Server) lookupKeyRead(c *Client, key string) ([]byte, error) {
+	s.trackKey(c, key)
+	val, err := c.db.Read(key)
+	if err == KeyDoesNotExist {
+		atomic.AddInt64(&s.stats.keyspaceMisses, 1)
+	} else {
+		atomic.AddInt64(&s.stats.keyspaceHits, 1)
+	}
+	return val, err
+}
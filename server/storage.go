@@ -0,0 +1,47 @@
+package server
+
+// Storage holds the keys and values of a logical database. The keys are kept in
+// memory by default, Options.Storage plugs in another engine, such as DiskStorage.
+// Every engine must behave the same as seen by the commands, only the memory and
+// latency they trade differ. Storages are used concurrently by the clients.
+type Storage interface {
+	// Read returns the value of key, ok is false if it doesn't exist. The value
+	// must not be modified.
+	Read(key DBKey) (value []byte, ok bool)
+	// Write sets key to value, the storage owns value from now on.
+	Write(key DBKey, value []byte)
+	// Delete deletes key, if it exists.
+	Delete(key DBKey)
+	// DeleteKeys deletes keys atomically, returning how many of them existed.
+	DeleteKeys(keys ...DBKey) int
+	// Move moves key to dst, a Storage of the same engine, unless it doesn't exist
+	// here or it already exists in dst. It reports whether the key was moved.
+	Move(dst Storage, key DBKey) bool
+	// Flush deletes all the keys. If async is set the memory they used can be
+	// reclaimed in the background once Flush returns.
+	Flush(async bool)
+	// Size returns the number of keys.
+	Size() int
+	// RandomKey returns a key picked at random, ok is false if there are none.
+	RandomKey() (key DBKey, ok bool)
+	// Keys returns the keys for which match returns true.
+	Keys(match func(key DBKey) bool) []DBKey
+	// Iterate calls fn for every key and its value, until fn returns false. A key
+	// that exists for the whole iteration is visited exactly once.
+	Iterate(fn func(key DBKey, value []byte) bool)
+	// Populate adds count keys named prefix:N with the values returned by value,
+	// which the storage owns, leaving the existing keys untouched, and returns the number of keys added.
+	Populate(count int, prefix string, value func(i int) []byte) int
+	// UsedMemory returns the estimated memory used by the keys and values.
+	UsedMemory() int64
+	// KeyMemory returns the estimated memory used by key and its value, ok is false
+	// if the key doesn't exist.
+	KeyMemory(key DBKey) (size int64, ok bool)
+}
+
+// keySizeLimiter is implemented by the storages that can only hold keys up to
+// MaxKeySize bytes, such as DiskStorage. The write commands refuse the longer keys
+// with keyTooLargeReply before running.
+type keySizeLimiter interface {
+	MaxKeySize() int
+}
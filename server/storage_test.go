@@ -0,0 +1,246 @@
+package server
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// storageEngines are the engines every Storage test runs against, returning the
+// storages of two databases of the same engine.
+var storageEngines = []struct {
+	name string
+	open func(t *testing.T) (Storage, Storage)
+}{
+	{"memory", func(t *testing.T) (Storage, Storage) {
+		return newMemoryStorage(0), newMemoryStorage(1)
+	}},
+	{"disk", func(t *testing.T) (Storage, Storage) {
+		d := openTestDiskStorage(t)
+		return d.Database(0), d.Database(1)
+	}},
+}
+
+func openTestDiskStorage(t *testing.T) *DiskStorage {
+	t.Helper()
+	d, err := OpenDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func sortedKeys(st Storage) []DBKey {
+	keys := st.Keys(func(DBKey) bool { return true })
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, st, other Storage)
+	}{
+		{"read and write", func(t *testing.T, st, _ Storage) {
+			if _, ok := st.Read("missing"); ok {
+				t.Error("read a missing key")
+			}
+			for key, value := range map[DBKey]string{"key": "value", "": "empty key", "binary\x00key": "\x00\xff", "empty": ""} {
+				st.Write(key, []byte(value))
+				if got, ok := st.Read(key); !ok || string(got) != value {
+					t.Errorf("Read(%q) = %q, %v, want %q", key, got, ok, value)
+				}
+			}
+			st.Write("key", []byte("overwritten"))
+			if got, _ := st.Read("key"); string(got) != "overwritten" {
+				t.Errorf("Read after overwriting = %q", got)
+			}
+			if st.Size() != 4 {
+				t.Errorf("Size = %d, want 4", st.Size())
+			}
+		}},
+		{"delete", func(t *testing.T, st, _ Storage) {
+			st.Write("a", []byte("1"))
+			st.Write("b", []byte("2"))
+			st.Write("c", []byte("3"))
+			st.Delete("a")
+			st.Delete("missing")
+			if n := st.DeleteKeys("b", "missing", "c", "c"); n != 2 {
+				t.Errorf("DeleteKeys = %d, want 2", n)
+			}
+			if st.Size() != 0 || len(sortedKeys(st)) != 0 {
+				t.Errorf("Size = %d, keys %q after deleting everything", st.Size(), sortedKeys(st))
+			}
+		}},
+		{"move", func(t *testing.T, st, other Storage) {
+			st.Write("a", []byte("1"))
+			st.Write("b", []byte("2"))
+			other.Write("b", []byte("other"))
+			if !st.Move(other, "a") {
+				t.Error("Move(a) = false, want true")
+			}
+			if st.Move(other, "b") {
+				t.Error("Move(b) = true, with b in the destination")
+			}
+			if st.Move(other, "missing") {
+				t.Error("Move(missing) = true")
+			}
+			if st.Move(st, "b") {
+				t.Error("moved b to its own storage")
+			}
+			if !reflect.DeepEqual(sortedKeys(st), []DBKey{"b"}) || !reflect.DeepEqual(sortedKeys(other), []DBKey{"a", "b"}) {
+				t.Errorf("keys %q and %q after moving, want [b] and [a b]", sortedKeys(st), sortedKeys(other))
+			}
+			if got, _ := other.Read("a"); string(got) != "1" {
+				t.Errorf("moved value %q, want 1", got)
+			}
+			if got, _ := other.Read("b"); string(got) != "other" {
+				t.Errorf("value in the destination %q, want other", got)
+			}
+			if st.Size() != 1 || other.Size() != 2 {
+				t.Errorf("sizes %d and %d, want 1 and 2", st.Size(), other.Size())
+			}
+		}},
+		{"flush", func(t *testing.T, st, other Storage) {
+			for _, async := range []bool{false, true} {
+				st.Populate(100, "key", func(int) []byte { return []byte("value") })
+				other.Write("kept", []byte("value"))
+				st.Flush(async)
+				if st.Size() != 0 || len(sortedKeys(st)) != 0 {
+					t.Errorf("Flush(%v) left %d keys", async, st.Size())
+				}
+				if _, ok := st.RandomKey(); ok {
+					t.Errorf("RandomKey found a key after Flush(%v)", async)
+				}
+				if other.Size() != 1 {
+					t.Errorf("Flush(%v) changed the other database", async)
+				}
+			}
+		}},
+		{"populate", func(t *testing.T, st, _ Storage) {
+			st.Write("key:1", []byte("existing"))
+			if n := st.Populate(1000, "key", func(i int) []byte { return []byte(strconv.Itoa(i)) }); n != 999 {
+				t.Errorf("Populate = %d, want 999", n)
+			}
+			if st.Size() != 1000 {
+				t.Errorf("Size = %d, want 1000", st.Size())
+			}
+			if got, _ := st.Read("key:1"); string(got) != "existing" {
+				t.Errorf("Populate overwrote key:1 with %q", got)
+			}
+			if got, _ := st.Read("key:999"); string(got) != "999" {
+				t.Errorf("key:999 = %q, want 999", got)
+			}
+		}},
+		{"keys and iterate", func(t *testing.T, st, _ Storage) {
+			// More keys than a batch of the snapshots
+			count := 3*snapshotBatch + 1
+			st.Populate(count, "key", func(i int) []byte { return []byte(strconv.Itoa(i)) })
+			st.Write("other", []byte("x"))
+
+			matched := st.Keys(func(key DBKey) bool { return strings.HasPrefix(key, "key:") })
+			if len(matched) != count {
+				t.Errorf("Keys matched %d keys, want %d", len(matched), count)
+			}
+			seen := map[DBKey]bool{}
+			st.Iterate(func(key DBKey, value []byte) bool {
+				if seen[key] {
+					t.Errorf("%q visited twice", key)
+				}
+				seen[key] = true
+				if want, _ := st.Read(key); string(value) != string(want) {
+					t.Errorf("Iterate gave %q = %q, want %q", key, value, want)
+				}
+				return true
+			})
+			if len(seen) != count+1 {
+				t.Errorf("Iterate visited %d keys, want %d", len(seen), count+1)
+			}
+			visited := 0
+			st.Iterate(func(DBKey, []byte) bool {
+				visited++
+				return visited < 10
+			})
+			if visited != 10 {
+				t.Errorf("Iterate visited %d keys after stopping at 10", visited)
+			}
+		}},
+		{"random key", func(t *testing.T, st, _ Storage) {
+			if _, ok := st.RandomKey(); ok {
+				t.Error("RandomKey found a key in an empty storage")
+			}
+			st.Write("a", []byte("1"))
+			st.Write("b", []byte("2"))
+			found := map[DBKey]bool{}
+			for i := 0; i < 200 && len(found) < 2; i++ {
+				key, ok := st.RandomKey()
+				if !ok || (key != "a" && key != "b") {
+					t.Fatalf("RandomKey = %q, %v", key, ok)
+				}
+				found[key] = true
+			}
+			if len(found) != 2 {
+				t.Errorf("RandomKey only returned %v", found)
+			}
+		}},
+		{"key memory", func(t *testing.T, st, _ Storage) {
+			if _, ok := st.KeyMemory("missing"); ok {
+				t.Error("KeyMemory found a missing key")
+			}
+			st.Write("key", []byte("value"))
+			value, _ := st.Read("key")
+			if size, ok := st.KeyMemory("key"); !ok || size != stringEntrySize("key", value) {
+				t.Errorf("KeyMemory = %d, %v, want %d", size, ok, stringEntrySize("key", value))
+			}
+		}},
+	}
+	for _, engine := range storageEngines {
+		t.Run(engine.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					st, other := engine.open(t)
+					tt.run(t, st, other)
+				})
+			}
+		})
+	}
+}
+
+func TestDiskStorageKeySize(t *testing.T) {
+	d := openTestDiskStorage(t)
+	_, addr := newTestServer(t, Options{Storage: d.Database})
+	c := dialTest(t, addr)
+	maxKey := strings.Repeat("k", d.Database(0).(keySizeLimiter).MaxKeySize())
+	tooLarge := maxKey + "k"
+	tests := []struct {
+		args []string
+		want resp.Reply
+	}{
+		{[]string{"SET", "", "empty"}, okReply},
+		{[]string{"GET", ""}, resp.BulkString("empty")},
+		{[]string{"SET", maxKey, "value"}, okReply},
+		{[]string{"GET", maxKey}, resp.BulkString("value")},
+		{[]string{"SET", tooLarge, "value"}, keyTooLargeReply},
+		{[]string{"INCR", tooLarge}, keyTooLargeReply},
+		// Reading a key that can't exist isn't an error
+		{[]string{"GET", tooLarge}, resp.Null{}},
+		{[]string{"EXISTS", tooLarge}, resp.Int(0)},
+		{[]string{"DBSIZE"}, resp.Int(2)},
+	}
+	for _, tt := range tests {
+		c.expect(tt.want, tt.args...)
+	}
+}
+
+func TestMemoryStorageKeySize(t *testing.T) {
+	s := New(Options{Databases: 1, Logger: NewLogger(io.Discard, LogWarning)})
+	if _, ok := s.databases[0].Storage.(keySizeLimiter); ok {
+		t.Error("the memory storage limits the size of the keys")
+	}
+}
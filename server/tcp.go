@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTCPKeepAlive is the default period of the TCP keepalive probes.
+const DefaultTCPKeepAlive = 300 * time.Second
+
+// tcpKeepAlive is the period of the TCP keepalive probes, zero means that keepalive
+// is disabled.
+func (s *Server) tcpKeepAlive() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.keepAlive))
+}
+
+func (s *Server) tcpNoDelay() bool {
+	return atomic.LoadInt32(&s.noDelay) == 1
+}
+
+// configureConn applies the TCP options to a newly accepted connection. Connections
+// that are not TCP, such as unix sockets, are left untouched.
+func (s *Server) configureConn(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if period := s.tcpKeepAlive(); period > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			s.logger.Warningf("Failed to enable TCP keepalive: %v", err)
+		} else if err := tcpConn.SetKeepAlivePeriod(period); err != nil {
+			s.logger.Warningf("Failed to set TCP keepalive period: %v", err)
+		}
+	} else if err := tcpConn.SetKeepAlive(false); err != nil {
+		s.logger.Warningf("Failed to disable TCP keepalive: %v", err)
+	}
+
+	if err := tcpConn.SetNoDelay(s.tcpNoDelay()); err != nil {
+		s.logger.Warningf("Failed to set TCP_NODELAY: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tlsConfig loads the certificates configured in the Options.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.opts.TLSCertFile, s.opts.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %s and key %s: %w", s.opts.TLSCertFile, s.opts.TLSKeyFile, err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.opts.TLSCACertFile != "" {
+		pem, err := os.ReadFile(s.opts.TLSCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS CA certificate: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to load TLS CA certificate: no certificates found in %s", s.opts.TLSCACertFile)
+		}
+	}
+
+	switch strings.ToLower(s.opts.TLSAuthClients) {
+	case "", "no":
+		config.ClientAuth = tls.NoClientCert
+	case "yes":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf(`invalid tls-auth-clients %q, must be "yes", "no" or "optional"`, s.opts.TLSAuthClients)
+	}
+	if config.ClientAuth != tls.NoClientCert && config.ClientCAs == nil {
+		return nil, fmt.Errorf("tls-auth-clients requires a CA certificate to verify clients, set tls-ca-cert-file")
+	}
+	return config, nil
+}
+
+// listenTLS listens for TLS connections on TLSAddrs, and on TLSPort on each of the
+// hosts of the plaintext TCP listeners, or on the loopback interface if there are
+// none, so that TLS doesn't expose a server only listening on a unix socket.
+func (s *Server) listenTLS() ([]net.Listener, error) {
+	config, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := append([]string{}, s.opts.TLSAddrs...)
+	if s.opts.TLSPort != 0 {
+		hosts := []string{}
+		if strings.HasPrefix(s.opts.Network, "tcp") {
+			for _, addr := range s.opts.Addrs {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid address %s: %w", addr, err)
+				}
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) == 0 {
+			hosts = append(hosts, "127.0.0.1")
+		}
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(s.opts.TLSPort)))
+		}
+	}
+
+	listeners := []net.Listener{}
+	for _, addr := range addrs {
+		ln, err := tls.Listen("tcp", addr, config)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to start listening on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// testCerts are a CA and the certificates it signed for the server and a client,
+// written to PEM files.
+type testCerts struct {
+	caFile, certFile, keyFile string
+	pool                      *x509.CertPool
+	client                    tls.Certificate
+}
+
+func newTestCerts(t *testing.T) *testCerts {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	certs := &testCerts{pool: x509.NewCertPool()}
+	certs.pool.AddCert(ca)
+	certs.caFile = writePEM("ca.pem", "CERTIFICATE", caDER)
+
+	serverDER, serverKey := sign(2, x509.ExtKeyUsageServerAuth)
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs.certFile = writePEM("server.pem", "CERTIFICATE", serverDER)
+	certs.keyFile = writePEM("server-key.pem", "EC PRIVATE KEY", serverKeyDER)
+
+	clientDER, clientKey := sign(3, x509.ExtKeyUsageClientAuth)
+	certs.client = tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+	return certs
+}
+
+// newTLSTestServer serves s on the TLS listeners of its Options, returning their
+// addresses.
+func newTLSTestServer(t *testing.T, opts Options) []string {
+	t.Helper()
+	opts.Network = "tcp"
+	opts.Databases = 16
+	opts.Logger = NewLogger(io.Discard, LogWarning)
+	s := New(opts)
+	listeners, err := s.listenTLS()
+	if err != nil {
+		t.Fatalf("listenTLS: %v", err)
+	}
+	addrs := []string{}
+	for _, ln := range listeners {
+		addrs = append(addrs, ln.Addr().String())
+		go s.Serve(ln)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return addrs
+}
+
+func TestTLS(t *testing.T) {
+	certs := newTestCerts(t)
+	tests := []struct {
+		name        string
+		authClients string
+		clientCert  bool
+		wantOK      bool
+	}{
+		{"no client auth", "no", false, true},
+		{"no client auth with a certificate", "no", true, true},
+		{"optional client auth without a certificate", "optional", false, true},
+		{"optional client auth with a certificate", "optional", true, true},
+		{"required client auth without a certificate", "yes", false, false},
+		{"required client auth with a certificate", "yes", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs := newTLSTestServer(t, Options{
+				TLSAddrs:       []string{"127.0.0.1:0"},
+				TLSCertFile:    certs.certFile,
+				TLSKeyFile:     certs.keyFile,
+				TLSCACertFile:  certs.caFile,
+				TLSAuthClients: tt.authClients,
+			})
+			config := &tls.Config{RootCAs: certs.pool, ServerName: "localhost"}
+			if tt.clientCert {
+				config.Certificates = []tls.Certificate{certs.client}
+			}
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: testTimeout}, "tcp", addrs[0], config)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			// With TLS 1.3 a missing client certificate is only reported once the
+			// client reads
+			c := newTestClient(t, conn)
+			c.send("PING")
+			conn.SetReadDeadline(time.Now().Add(testTimeout))
+			reply, err := c.r.ReadReply()
+			if tt.wantOK {
+				if err != nil || reply != resp.Reply(resp.SimpleString("PONG")) {
+					t.Errorf("PING = %#v, %v, want PONG", reply, err)
+				}
+			} else if err == nil {
+				t.Errorf("PING = %#v, want the handshake to fail", reply)
+			}
+		})
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	certs := newTestCerts(t)
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"missing certificate", Options{TLSCertFile: "missing.pem", TLSKeyFile: certs.keyFile}},
+		{"missing CA", Options{TLSCertFile: certs.certFile, TLSKeyFile: certs.keyFile, TLSCACertFile: "missing.pem"}},
+		{"client auth without CA", Options{TLSCertFile: certs.certFile, TLSKeyFile: certs.keyFile, TLSAuthClients: "yes"}},
+		{"invalid client auth", Options{TLSCertFile: certs.certFile, TLSKeyFile: certs.keyFile, TLSAuthClients: "maybe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Logger = NewLogger(io.Discard, LogWarning)
+			if _, err := New(tt.opts).tlsConfig(); err == nil {
+				t.Error("tlsConfig succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestTLSPortBindsLoopbackWithoutTCPAddresses(t *testing.T) {
+	certs := newTestCerts(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	addrs := newTLSTestServer(t, Options{
+		UnixSocket:  filepath.Join(t.TempDir(), "redis.sock"),
+		TLSPort:     port,
+		TLSCertFile: certs.certFile,
+		TLSKeyFile:  certs.keyFile,
+	})
+	if want := []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}; len(addrs) != 1 || addrs[0] != want[0] {
+		t.Errorf("listening on %q, want %q", addrs, want)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+const tracerName = "tommasoamici/redis-clone/server"
+
+// startCommandSpan starts the span of a command, named after it. Only the first key
+// is recorded to bound the cardinality of the attributes.
+func (s *Server) startCommandSpan(c *Client, cmd *command, args [][]byte) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd.name),
+		attribute.Int("db.redis.database_index", c.db.id),
+		attribute.Int("db.redis.args_count", len(args)),
+	}
+	if keys := cmd.keys(args); len(keys) > 0 {
+		attrs = append(attrs, attribute.String("db.redis.key", string(keys[0])))
+	}
+	_, span := s.tracer.Start(context.Background(), cmd.name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+	return span
+}
+
+func endCommandSpan(span trace.Span, reply Reply, size int) {
+	span.SetAttributes(attribute.Int("db.redis.reply_size", size))
+	if err, ok := reply.(resp.Error); ok {
+		span.SetStatus(codes.Error, string(err))
+	}
+	span.End()
+}
+
+// traceConnection records an event of the life of a connection, such as its accept
+// or its close, as a span of its own.
+func (s *Server) traceConnection(name string, c *Client, attrs ...attribute.KeyValue) {
+	attrs = append(attrs,
+		attribute.Int64("db.redis.client_id", int64(c.id)),
+		attribute.String("net.peer.name", c.conn.RemoteAddr().String()),
+	)
+	_, span := s.tracer.Start(context.Background(), name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+	span.End()
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// recordingTracer is a TracerProvider and Tracer that records the spans ended.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return t
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordedSpan{
+		Span:   trace.SpanFromContext(ctx),
+		tracer: t,
+		name:   name,
+		kind:   config.SpanKind(),
+		attrs:  map[attribute.Key]attribute.Value{},
+	}
+	span.SetAttributes(config.Attributes()...)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (t *recordingTracer) ended() []*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*recordedSpan(nil), t.spans...)
+}
+
+// find returns the last span ended with the name, or nil.
+func (t *recordingTracer) find(name string) *recordedSpan {
+	spans := t.ended()
+	for i := len(spans) - 1; i >= 0; i-- {
+		if spans[i].name == name {
+			return spans[i]
+		}
+	}
+	return nil
+}
+
+type recordedSpan struct {
+	trace.Span
+	tracer *recordingTracer
+	name   string
+	kind   trace.SpanKind
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) {
+	s.status = code
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func TestCommandSpans(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		span   string
+		attrs  map[attribute.Key]attribute.Value
+		status codes.Code
+	}{
+		{
+			"command without keys",
+			[]string{"PING"},
+			"ping",
+			map[attribute.Key]attribute.Value{
+				"db.system":               attribute.StringValue("redis"),
+				"db.operation":            attribute.StringValue("ping"),
+				"db.redis.database_index": attribute.IntValue(0),
+				"db.redis.reply_size":     attribute.IntValue(len("+PONG\r\n")),
+			},
+			codes.Unset,
+		},
+		{
+			"command with keys",
+			[]string{"DEL", "first", "second", "third"},
+			"del",
+			map[attribute.Key]attribute.Value{
+				"db.operation":        attribute.StringValue("del"),
+				"db.redis.key":        attribute.StringValue("first"),
+				"db.redis.args_count": attribute.IntValue(3),
+			},
+			codes.Unset,
+		},
+		{
+			"failed command",
+			[]string{"INCR", "text"},
+			"incr",
+			map[attribute.Key]attribute.Value{
+				"db.operation": attribute.StringValue("incr"),
+				"db.redis.key": attribute.StringValue("text"),
+			},
+			codes.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordingTracer{}
+			_, addr := newTestServer(t, Options{TracerProvider: tracer})
+			c := dialTest(t, addr)
+			c.expect(okReply, "SET", "text", "not a number")
+			c.do(tt.args...)
+			// The span ends once the reply is written, after the client reads it
+			waitFor(t, "the span to end", func() bool {
+				span := tracer.find(tt.span)
+				return span != nil && span.attrs["db.redis.reply_size"].Type() != attribute.INVALID
+			})
+
+			span := tracer.find(tt.span)
+			if span.kind != trace.SpanKindServer {
+				t.Errorf("span kind = %v, want server", span.kind)
+			}
+			for key, want := range tt.attrs {
+				if got := span.attrs[key]; got != want {
+					t.Errorf("attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+				}
+			}
+			if span.status != tt.status {
+				t.Errorf("status = %v, want %v", span.status, tt.status)
+			}
+		})
+	}
+}
+
+func TestConnectionSpans(t *testing.T) {
+	tracer := &recordingTracer{}
+	_, addr := newTestServer(t, Options{TracerProvider: tracer})
+	c := dialTest(t, addr)
+	c.expect(resp.SimpleString("PONG"), "PING")
+	c.expect(okReply, "QUIT")
+
+	waitFor(t, "the connection to be closed", func() bool {
+		return tracer.find("connection.close") != nil
+	})
+	accept := tracer.find("connection.accept")
+	if accept == nil {
+		t.Fatal("no connection.accept span")
+	}
+	closed := tracer.find("connection.close")
+	for _, span := range []*recordedSpan{accept, closed} {
+		if got, want := span.attrs["net.peer.name"].AsString(), c.conn.LocalAddr().String(); got != want {
+			t.Errorf("%s net.peer.name = %q, want %q", span.name, got, want)
+		}
+		if got := span.attrs["db.redis.client_id"]; got != closed.attrs["db.redis.client_id"] {
+			t.Errorf("%s db.redis.client_id = %v, want the same client", span.name, got.Emit())
+		}
+	}
+	if reason := closed.attrs["db.redis.close_reason"].AsString(); reason == "" {
+		t.Error("connection.close has no db.redis.close_reason")
+	}
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// DefaultTrackingTableMaxKeys is the default maximum number of keys remembered for
+// the clients doing client side caching.
+const DefaultTrackingTableMaxKeys = 1000000
+
+// invalidateChannel is the channel the invalidation messages are published to for
+// the clients redirecting them to another connection.
+const invalidateChannel = "__redis__:invalidate"
+
+// trackingOptions are the options given to CLIENT TRACKING ON. They're never
+// modified once tracking is enabled, so they can be read by the connections that
+// invalidate the keys.
+type trackingOptions struct {
+	// redirect is the id of the client receiving the invalidation messages, zero
+	// to send them to the tracking client itself
+	redirect uint64
+	bcast    bool
+	prefixes []string
+	optin    bool
+	optout   bool
+	noloop   bool
+}
+
+// Values of Client.caching, set with CLIENT CACHING for the command that follows.
+const (
+	cachingDefault = iota
+	cachingYes
+	cachingNo
+)
+
+// trackingTable remembers the keys read by the clients with tracking enabled, so
+// that they can be told to drop them from their cache once they're modified. In
+// BCAST mode the clients are instead told about every key matching their prefixes.
+// Clients are removed from the keys lazily: the entries of a client that turned
+// tracking off are skipped, and dropped when the key is invalidated. The number of
+// keys is bounded by maxKeys, unless it's zero: when it's exceeded keys are
+// invalidated even if they weren't modified.
+type trackingTable struct {
+	mu       sync.Mutex
+	clients  map[*Client]*trackingOptions
+	keys     map[string]map[*Client]struct{}
+	prefixes map[string]map[*Client]struct{}
+	maxKeys  int64
+	// enabled is the number of tracking clients, to skip the table altogether when
+	// it's zero
+	enabled int64
+}
+
+func newTrackingTable() *trackingTable {
+	return &trackingTable{
+		clients:  make(map[*Client]*trackingOptions),
+		keys:     make(map[string]map[*Client]struct{}),
+		prefixes: make(map[string]map[*Client]struct{}),
+		maxKeys:  DefaultTrackingTableMaxKeys,
+	}
+}
+
+// invalidation is a message telling target that keys were modified, a nil keys
+// means that the whole keyspace was flushed.
+type invalidation struct {
+	target *Client
+	opts   *trackingOptions
+	keys   []string
+}
+
+func (t *trackingTable) enable(c *Client, opts *trackingOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, ok := t.clients[c]; ok {
+		t.removePrefixes(c, old)
+	} else {
+		atomic.AddInt64(&t.enabled, 1)
+	}
+	t.clients[c] = opts
+	for _, prefix := range opts.prefixes {
+		clients, ok := t.prefixes[prefix]
+		if !ok {
+			clients = make(map[*Client]struct{})
+			t.prefixes[prefix] = clients
+		}
+		clients[c] = struct{}{}
+	}
+	c.tracking = opts
+}
+
+// disable turns tracking off for c, it's also called when the client disconnects.
+func (t *trackingTable) disable(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	opts, ok := t.clients[c]
+	if !ok {
+		return
+	}
+	t.removePrefixes(c, opts)
+	delete(t.clients, c)
+	atomic.AddInt64(&t.enabled, -1)
+	c.tracking = nil
+}
+
+func (t *trackingTable) removePrefixes(c *Client, opts *trackingOptions) {
+	for _, prefix := range opts.prefixes {
+		delete(t.prefixes[prefix], c)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+}
+
+// shouldTrack reports whether the keys read by the current command of c must be
+// remembered.
+func (c *Client) shouldTrack() bool {
+	opts := c.tracking
+	switch {
+	case opts == nil || opts.bcast:
+		return false
+	case opts.optin:
+		return c.caching == cachingYes
+	case opts.optout:
+		return c.caching != cachingNo
+	}
+	return true
+}
+
+// trackKey remembers that c read key, to invalidate it once it's modified.
+func (s *Server) trackKey(c *Client, key string) {
+	if !c.shouldTrack() {
+		return
+	}
+	t := s.tracking
+	t.mu.Lock()
+	clients, ok := t.keys[key]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		t.keys[key] = clients
+	}
+	clients[c] = struct{}{}
+
+	var evicted []invalidation
+	maxKeys := atomic.LoadInt64(&t.maxKeys)
+	for excess := int64(len(t.keys)) - maxKeys; maxKeys > 0 && excess > 0; excess-- {
+		for k := range t.keys {
+			evicted = append(evicted, t.invalidateKey(nil, k)...)
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	s.sendInvalidations(evicted)
+}
+
+// invalidateKey removes key from the table, returning the messages to send to the
+// clients that read it or that are interested in its prefix. modifiedBy is the
+// client that modified the key, nil if it's evicted from the table.
+func (t *trackingTable) invalidateKey(modifiedBy *Client, key string) []invalidation {
+	var messages []invalidation
+	add := func(target *Client) {
+		opts, ok := t.clients[target]
+		if !ok || (opts.noloop && target == modifiedBy) {
+			return
+		}
+		messages = append(messages, invalidation{target: target, opts: opts, keys: []string{key}})
+	}
+	for target := range t.keys[key] {
+		add(target)
+	}
+	delete(t.keys, key)
+	for prefix, clients := range t.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			for target := range clients {
+				add(target)
+			}
+		}
+	}
+	return messages
+}
+
+// signalModifiedKey tells the clients tracking key that it was modified by c.
+func (s *Server) signalModifiedKey(c *Client, key string) {
+	t := s.tracking
+	if atomic.LoadInt64(&t.enabled) == 0 {
+		return
+	}
+	t.mu.Lock()
+	messages := t.invalidateKey(c, key)
+	t.mu.Unlock()
+
+	s.sendInvalidations(messages)
+}
+
+// signalFlushed tells every tracking client that the keyspace was flushed, so that
+// they drop their whole cache.
+func (s *Server) signalFlushed() {
+	t := s.tracking
+	if atomic.LoadInt64(&t.enabled) == 0 {
+		return
+	}
+	t.mu.Lock()
+	messages := make([]invalidation, 0, len(t.clients))
+	for target, opts := range t.clients {
+		messages = append(messages, invalidation{target: target, opts: opts})
+	}
+	t.keys = make(map[string]map[*Client]struct{})
+	t.mu.Unlock()
+
+	s.sendInvalidations(messages)
+}
+
+// sendInvalidations pushes the invalidation messages to the tracking clients, or
+// to the clients they're redirected to.
+func (s *Server) sendInvalidations(messages []invalidation) {
+	for _, m := range messages {
+		var keys Reply = resp.Null{}
+		if m.keys != nil {
+			array := make(resp.Array, 0, len(m.keys))
+			for _, key := range m.keys {
+				array = append(array, resp.BulkString(key))
+			}
+			keys = array
+		}
+
+		target := m.target
+		push := resp.Push{resp.BulkString("invalidate"), keys}
+		if m.opts.redirect != 0 {
+			target = s.clientByID(m.opts.redirect)
+			if target == nil {
+				m.target.conn.WritePush(resp.Push{resp.BulkString("tracking-redir-broken"), resp.Int(int64(m.opts.redirect))})
+				continue
+			}
+			push = resp.Push{resp.BulkString("message"), resp.BulkString(invalidateChannel), keys}
+		}
+		target.conn.WritePush(push)
+	}
+}
+
+// clientByID returns the connected client with the given id, or nil.
+func (s *Server) clientByID(id uint64) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// clientTracking handles `CLIENT TRACKING ON|OFF [REDIRECT id] [BCAST] [PREFIX prefix
+// [PREFIX prefix ...]] [OPTIN] [OPTOUT] [NOLOOP]`.
+// https://redis.io/commands/client-tracking/
+func (s *Server) clientTracking(c *Client, args [][]byte) Reply {
+	if len(args) == 0 {
+		return wrongNumArgsReply("client|tracking")
+	}
+	var on bool
+	switch strings.ToLower(string(args[0])) {
+	case "on":
+		on = true
+	case "off":
+	default:
+		return resp.Error("ERR syntax error")
+	}
+
+	opts := &trackingOptions{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "redirect":
+			if i+1 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			i++
+			id, err := strconv.ParseUint(string(args[i]), 10, 64)
+			if err != nil {
+				return valueIsNotIntReply
+			}
+			if id != c.id && s.clientByID(id) == nil {
+				return resp.Error("ERR The client ID you want redirect to does not exist")
+			}
+			opts.redirect = id
+		case "bcast":
+			opts.bcast = true
+		case "prefix":
+			if i+1 >= len(args) {
+				return resp.Error("ERR syntax error")
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, string(args[i]))
+		case "optin":
+			opts.optin = true
+		case "optout":
+			opts.optout = true
+		case "noloop":
+			opts.noloop = true
+		default:
+			return resp.Error("ERR syntax error")
+		}
+	}
+
+	if !on {
+		s.tracking.disable(c)
+		return okReply
+	}
+	switch {
+	case len(opts.prefixes) > 0 && !opts.bcast:
+		return resp.Error("ERR PREFIX option requires BCAST mode to be enabled")
+	case opts.optin && opts.optout:
+		return resp.Error("ERR You can't use OPTIN and OPTOUT at the same time")
+	case opts.bcast && (opts.optin || opts.optout):
+		return resp.Error("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if opts.bcast && len(opts.prefixes) == 0 {
+		opts.prefixes = []string{""}
+	}
+	s.tracking.enable(c, opts)
+	return okReply
+}
+
+// clientCaching handles `CLIENT CACHING YES|NO`, which decides whether the keys read
+// by the next command are tracked in OPTIN and OPTOUT mode.
+// https://redis.io/commands/client-caching/
+func (s *Server) clientCaching(c *Client, args [][]byte) Reply {
+	if len(args) != 1 {
+		return wrongNumArgsReply("client|caching")
+	}
+	if c.tracking == nil || !(c.tracking.optin || c.tracking.optout) {
+		return resp.Error("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "yes":
+		if !c.tracking.optin {
+			return resp.Error("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		c.nextCaching = cachingYes
+	case "no":
+		if !c.tracking.optout {
+			return resp.Error("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		c.nextCaching = cachingNo
+	default:
+		return resp.Error("ERR syntax error")
+	}
+	return okReply
+}
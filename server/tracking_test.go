@@ -0,0 +1,225 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+// trackingStep is a command sent by the tracking client, or by another one.
+type trackingStep struct {
+	byTracker bool
+	args      []string
+}
+
+func tracker(args ...string) trackingStep { return trackingStep{true, args} }
+func other(args ...string) trackingStep   { return trackingStep{false, args} }
+
+// invalidated returns the invalidation message of keys as received in RESP2, nil
+// keys meaning that the keyspace was flushed.
+func invalidated(keys ...string) resp.Reply {
+	if keys == nil {
+		return resp.Array{resp.BulkString("invalidate"), resp.Null{}}
+	}
+	array := resp.Array{}
+	for _, key := range keys {
+		array = append(array, resp.BulkString(key))
+	}
+	return resp.Array{resp.BulkString("invalidate"), array}
+}
+
+// isInvalidation reports whether reply is an invalidation message rather than the
+// reply to a command.
+func isInvalidation(reply resp.Reply) bool {
+	array, ok := reply.(resp.Array)
+	return ok && len(array) == 2 && reflect.DeepEqual(array[0], resp.BulkString("invalidate"))
+}
+
+// clientID returns the id of c, as listed by CLIENT LIST.
+func clientID(c *testClient) string {
+	c.t.Helper()
+	list := string(c.do("CLIENT", "LIST").(resp.BulkString))
+	for _, line := range strings.Split(list, "\n") {
+		if strings.Contains(line, " addr="+c.conn.LocalAddr().String()+" ") {
+			return strings.TrimPrefix(strings.Fields(line)[0], "id=")
+		}
+	}
+	c.t.Fatalf("client %s not in CLIENT LIST:\n%s", c.conn.LocalAddr(), list)
+	return ""
+}
+
+func TestClientTracking(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []trackingStep
+		want  []resp.Reply
+	}{
+		{
+			"key read then modified",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), other("SET", "a", "1")},
+			[]resp.Reply{invalidated("a")},
+		},
+		{
+			"key deleted",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), other("SET", "a", "1"), tracker("GET", "a"), other("DEL", "a")},
+			[]resp.Reply{invalidated("a"), invalidated("a")},
+		},
+		{
+			"key not read",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), other("SET", "b", "1")},
+			nil,
+		},
+		{
+			"invalidated once until read again",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), other("SET", "a", "1"), other("SET", "a", "2")},
+			[]resp.Reply{invalidated("a")},
+		},
+		{
+			"modified by the tracking client",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), tracker("SET", "a", "1")},
+			[]resp.Reply{invalidated("a")},
+		},
+		{
+			"NOLOOP",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON", "NOLOOP"), tracker("GET", "a"), tracker("SET", "a", "1")},
+			nil,
+		},
+		{
+			"BCAST",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON", "BCAST"), other("SET", "a", "1"), other("SET", "b", "1")},
+			[]resp.Reply{invalidated("a"), invalidated("b")},
+		},
+		{
+			"BCAST with prefixes",
+			[]trackingStep{
+				tracker("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "session:"),
+				other("SET", "user:1", "1"), other("SET", "other:1", "1"), other("SET", "session:1", "1"),
+			},
+			[]resp.Reply{invalidated("user:1"), invalidated("session:1")},
+		},
+		{
+			"OPTIN",
+			[]trackingStep{
+				tracker("CLIENT", "TRACKING", "ON", "OPTIN"), tracker("GET", "a"),
+				tracker("CLIENT", "CACHING", "YES"), tracker("GET", "b"), tracker("GET", "c"),
+				other("SET", "a", "1"), other("SET", "b", "1"), other("SET", "c", "1"),
+			},
+			[]resp.Reply{invalidated("b")},
+		},
+		{
+			"OPTOUT",
+			[]trackingStep{
+				tracker("CLIENT", "TRACKING", "ON", "OPTOUT"), tracker("CLIENT", "CACHING", "NO"),
+				tracker("GET", "a"), tracker("GET", "b"), other("SET", "a", "1"), other("SET", "b", "1"),
+			},
+			[]resp.Reply{invalidated("b")},
+		},
+		{
+			"flush",
+			[]trackingStep{tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"), other("FLUSHALL")},
+			[]resp.Reply{invalidated()},
+		},
+		{
+			"tracking turned off",
+			[]trackingStep{
+				tracker("CLIENT", "TRACKING", "ON"), tracker("GET", "a"),
+				tracker("CLIENT", "TRACKING", "OFF"), other("SET", "a", "1"),
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := newTestServer(t, Options{})
+			c, o := dialTest(t, addr), dialTest(t, addr)
+			received := []resp.Reply{}
+			// collect reads the replies of the tracking client up to the reply to
+			// its last command, keeping the invalidation messages sent before it
+			collect := func() resp.Reply {
+				for {
+					reply := c.read()
+					if !isInvalidation(reply) {
+						return reply
+					}
+					received = append(received, reply)
+				}
+			}
+			for _, step := range tt.steps {
+				var reply resp.Reply
+				if step.byTracker {
+					c.send(step.args...)
+					reply = collect()
+				} else {
+					reply = o.do(step.args...)
+				}
+				if err, ok := reply.(resp.Error); ok {
+					t.Fatalf("%q failed: %s", step.args, err)
+				}
+			}
+			// The messages are sent before the reply to the next command
+			c.send("PING")
+			collect()
+			if len(tt.want) == 0 {
+				tt.want = []resp.Reply{}
+			}
+			if !reflect.DeepEqual(received, tt.want) {
+				t.Errorf("received %#v, want %#v", received, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientTrackingRedirect(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+	c, receiver, o := dialTest(t, addr), dialTest(t, addr), dialTest(t, addr)
+	id := clientID(receiver)
+	c.expect(okReply, "CLIENT", "TRACKING", "ON", "REDIRECT", id)
+	c.expect(resp.Null{}, "GET", "a")
+	o.expect(okReply, "SET", "a", "1")
+
+	want := resp.Array{resp.BulkString("message"), resp.BulkString(invalidateChannel), resp.Array{resp.BulkString("a")}}
+	if got := receiver.read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("redirected message = %#v, want %#v", got, want)
+	}
+
+	// Once the receiver is gone, the tracking client is told the redirection broke
+	receiver.conn.Close()
+	waitFor(t, "the receiver to leave", func() bool {
+		return !strings.Contains(string(o.do("CLIENT", "LIST").(resp.BulkString)), "id="+id+" ")
+	})
+	c.expect(resp.Null{}, "GET", "b")
+	o.expect(okReply, "SET", "b", "1")
+	n, _ := strconv.ParseInt(id, 10, 64)
+	want = resp.Array{resp.BulkString("tracking-redir-broken"), resp.Int(n)}
+	if got := c.read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %#v, want %#v", got, want)
+	}
+}
+
+func TestClientTrackingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want resp.Reply
+	}{
+		{"no mode", []string{"CLIENT", "TRACKING"}, resp.Error("ERR wrong number of arguments for 'client|tracking' command")},
+		{"invalid mode", []string{"CLIENT", "TRACKING", "MAYBE"}, resp.Error("ERR syntax error")},
+		{"unknown option", []string{"CLIENT", "TRACKING", "ON", "FAST"}, resp.Error("ERR syntax error")},
+		{"PREFIX without BCAST", []string{"CLIENT", "TRACKING", "ON", "PREFIX", "a"}, resp.Error("ERR PREFIX option requires BCAST mode to be enabled")},
+		{"OPTIN and OPTOUT", []string{"CLIENT", "TRACKING", "ON", "OPTIN", "OPTOUT"}, resp.Error("ERR You can't use OPTIN and OPTOUT at the same time")},
+		{"BCAST and OPTIN", []string{"CLIENT", "TRACKING", "ON", "BCAST", "OPTIN"}, resp.Error("ERR OPTIN and OPTOUT are not compatible with BCAST")},
+		{"missing redirect target", []string{"CLIENT", "TRACKING", "ON", "REDIRECT", "9999"}, resp.Error("ERR The client ID you want redirect to does not exist")},
+		{"CACHING without tracking", []string{"CLIENT", "CACHING", "YES"}, resp.Error("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")},
+	}
+	_, addr := newTestServer(t, Options{})
+	c := dialTest(t, addr)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.t = t
+			c.expect(tt.want, tt.args...)
+		})
+	}
+}
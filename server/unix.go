@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix listens on the unix socket at path. A socket file left behind by a
+// server that didn't shut down cleanly is removed first, and the socket file is
+// removed again when the listener is closed.
+func (s *Server) listenUnix(network, path string) (net.Listener, error) {
+	if err := s.removeStaleSocket(network, path); err != nil {
+		return nil, fmt.Errorf("failed to remove stale unix socket %s: %w", path, err)
+	}
+
+	ln, err := net.Listen(network, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listening on %s: %w", path, err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if s.opts.UnixSocketPerm != 0 {
+		if err := os.Chmod(path, s.opts.UnixSocketPerm); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set permissions of unix socket %s: %w", path, err)
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket file at path if nothing is listening on it.
+// When another server is still using the socket, the file is left alone and binding
+// it fails with "address already in use".
+func (s *Server) removeStaleSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial(network, path)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	s.logger.Noticef("Removing stale unix socket %s", path)
+	return os.Remove(path)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tommasoamici/redis-clone/resp"
+)
+
+func TestListenUnix(t *testing.T) {
+	tests := []struct {
+		name string
+		perm os.FileMode
+		// setup prepares the socket path before the server binds it
+		setup    func(t *testing.T, path string)
+		wantErr  bool
+		wantPerm os.FileMode
+	}{
+		{"new socket", 0, func(t *testing.T, path string) {}, false, 0},
+		{"permissions", 0700, func(t *testing.T, path string) {}, false, 0700},
+		{"wider permissions", 0777, func(t *testing.T, path string) {}, false, 0777},
+		{"stale socket", 0, func(t *testing.T, path string) {
+			ln, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The file is left behind, as by a server that crashed
+			ln.(*net.UnixListener).SetUnlinkOnClose(false)
+			ln.Close()
+		}, false, 0},
+		{"socket in use", 0, func(t *testing.T, path string) {
+			ln, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { ln.Close() })
+		}, true, 0},
+		{"regular file", 0, func(t *testing.T, path string) {
+			if err := os.WriteFile(path, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "redis.sock")
+			tt.setup(t, path)
+			s := New(Options{
+				Network:        "unix",
+				Databases:      16,
+				UnixSocketPerm: tt.perm,
+				Logger:         NewLogger(io.Discard, LogWarning),
+			})
+			ln, err := s.listenUnix("unix", path)
+			if tt.wantErr {
+				if err == nil {
+					ln.Close()
+					t.Fatal("listenUnix succeeded, want an error")
+				}
+				if _, err := os.Lstat(path); err != nil {
+					t.Errorf("file in use was removed: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenUnix: %v", err)
+			}
+			go s.Serve(ln)
+
+			if tt.wantPerm != 0 {
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if perm := fi.Mode().Perm(); perm != tt.wantPerm {
+					t.Errorf("socket permissions = %v, want %v", perm, tt.wantPerm)
+				}
+			}
+
+			conn, err := net.DialTimeout("unix", path, testTimeout)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			c := newTestClient(t, conn)
+			c.expect(resp.SimpleString("PONG"), "PING")
+			conn.Close()
+
+			ln.Close()
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("socket file left after closing the listener: %v", err)
+			}
+		})
+	}
+}
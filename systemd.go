@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"tommasoamici/redis-clone/server"
+)
+
+// The first file descriptor passed by systemd with socket activation.
+// https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+const sdListenFDsStart = 3
+
+// sdNotify sends state to the service manager over the socket in $NOTIFY_SOCKET,
+// e.g. "READY=1". It does nothing if the variable is unset, so it's safe to call
+// when the server isn't run by systemd.
+// https://www.freedesktop.org/software/systemd/man/sd_notify.html
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	// A leading @ refers to a socket in the abstract namespace
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return nil
+}
+
+// sdWatchdogInterval returns the interval at which the service manager expects
+// WATCHDOG=1, half of WatchdogSec as recommended by sd_watchdog_enabled, or zero if
+// the watchdog isn't enabled for this process.
+// https://www.freedesktop.org/software/systemd/man/sd_watchdog_enabled.html
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdWatchdog sends WATCHDOG=1 to the service manager until stop is closed, if the
+// watchdog is enabled.
+func sdWatchdog(logger *server.Logger, stop <-chan struct{}) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				logger.Warningf("%v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sdListeners returns the sockets passed by systemd with socket activation, either
+// TCP or unix sockets, or nil if there are none for this process. The variables are
+// unset so the sockets aren't passed on to child processes.
+// https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+func sdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := sdListenFDsStart; fd < sdListenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener duplicates the descriptor, so the file can be closed
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("failed to use socket %d passed by systemd: %w", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
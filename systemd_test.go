@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tommasoamici/redis-clone/server"
+)
+
+// listenNotify listens on a unixgram socket as the service manager does, and sets
+// NOTIFY_SOCKET to it for the duration of the test.
+func listenNotify(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if name[0] == 0 {
+		name = "@" + name[1:]
+	}
+	t.Setenv("NOTIFY_SOCKET", name)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Run("path", func(t *testing.T) {
+		conn := listenNotify(t, filepath.Join(t.TempDir(), "notify.sock"))
+		if err := sdNotify("READY=1"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readNotify(t, conn); got != "READY=1" {
+			t.Errorf("received %q, want READY=1", got)
+		}
+	})
+	t.Run("abstract", func(t *testing.T) {
+		conn := listenNotify(t, "\x00redis-clone-test-"+strconv.Itoa(os.Getpid()))
+		if err := sdNotify("STOPPING=1"); err != nil {
+			t.Fatal(err)
+		}
+		if got := readNotify(t, conn); got != "STOPPING=1" {
+			t.Errorf("received %q, want STOPPING=1", got)
+		}
+	})
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		if err := sdNotify("READY=1"); err != nil {
+			t.Errorf("sdNotify without NOTIFY_SOCKET = %v", err)
+		}
+	})
+	t.Run("missing socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "notify.sock"))
+		if err := sdNotify("READY=1"); err == nil {
+			t.Error("sdNotify to a missing socket succeeded")
+		}
+	})
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"0", "", 0},
+		{"-1", "", 0},
+		{"ten", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", pid, 15 * time.Second},
+		{"30000000", "1", 0},
+		{"1000", "", 500 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := sdWatchdogInterval(); got != tt.want {
+			t.Errorf("sdWatchdogInterval() with WATCHDOG_USEC=%q WATCHDOG_PID=%q = %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestSdWatchdog(t *testing.T) {
+	conn := listenNotify(t, filepath.Join(t.TempDir(), "notify.sock"))
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sdWatchdog(server.NewLogger(io.Discard, server.LogWarning), stop)
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		if got := readNotify(t, conn); got != "WATCHDOG=1" {
+			t.Errorf("received %q, want WATCHDOG=1", got)
+		}
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sdWatchdog didn't return once stopped")
+	}
+
+	// It returns right away if the watchdog isn't enabled
+	t.Setenv("WATCHDOG_USEC", "")
+	sdWatchdog(server.NewLogger(io.Discard, server.LogWarning), make(chan struct{}))
+}
+
+func TestSdListenersNotPassed(t *testing.T) {
+	tests := []struct{ pid, fds string }{
+		{"", ""},
+		{"1", "2"},
+		{strconv.Itoa(os.Getpid()), ""},
+		{strconv.Itoa(os.Getpid()), "0"},
+		{strconv.Itoa(os.Getpid()), "two"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+		listeners, err := sdListeners()
+		if listeners != nil || err != nil {
+			t.Errorf("sdListeners() with LISTEN_PID=%q LISTEN_FDS=%q = %v, %v, want none", tt.pid, tt.fds, listeners, err)
+		}
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			if _, ok := os.LookupEnv(name); ok {
+				t.Errorf("%s still set", name)
+			}
+		}
+	}
+}
+
+// TestSdListeners passes sockets to a child process, as systemd does, since the
+// descriptors must start at 3. The child runs TestSdListenersChild, which prints the
+// addresses of the listeners it got.
+func TestSdListeners(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "redis.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close()
+	var files []*os.File
+	for _, ln := range []interface{ File() (*os.File, error) }{tcp.(*net.TCPListener), unix.(*net.UnixListener)} {
+		f, err := ln.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSdListenersChild$")
+	cmd.Env = append(os.Environ(), "SD_LISTENERS_CHILD=1", "LISTEN_FDS=2")
+	cmd.ExtraFiles = files
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out)
+	}
+	want := "listener " + tcp.Addr().String() + "\nlistener " + unix.Addr().String() + "\n"
+	if !strings.Contains(string(out), want) {
+		t.Errorf("child output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestSdListenersChild(t *testing.T) {
+	if os.Getenv("SD_LISTENERS_CHILD") == "" {
+		t.Skip("only run by TestSdListeners")
+	}
+	// The pid of the child isn't known before it starts
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := sdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ln := range listeners {
+		os.Stdout.WriteString("listener " + ln.Addr().String() + "\n")
+		ln.Close()
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Errorf("%s still set", name)
+		}
+	}
+}
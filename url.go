@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// redisURL is an address given as a URL, as deploy tools hand them out:
+//
+//	redis://[[user]:password@]host[:port][/db]  plaintext TCP, port 6379 by default
+//	rediss://[[user]:password@]host[:port][/db] TLS
+//	unix://[[user]:password@]/path/to/socket[?db=N]
+//
+// The database can also be given with the db query parameter.
+type redisURL struct {
+	network  string
+	addr     string
+	tls      bool
+	user     string
+	password string
+	db       int
+}
+
+// isURL reports whether addr is a URL rather than a host:port address.
+func isURL(addr string) bool {
+	return strings.Contains(addr, "://")
+}
+
+// parseRedisURL parses a redis://, rediss:// or unix:// URL. The errors name the
+// component that's invalid, without repeating the URL, as it may hold a password.
+func parseRedisURL(raw string) (redisURL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return redisURL{}, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	var r redisURL
+	switch strings.ToLower(u.Scheme) {
+	case "redis":
+		r.network = "tcp"
+	case "rediss":
+		r.network, r.tls = "tcp", true
+	case "unix":
+		r.network = "unix"
+	default:
+		return redisURL{}, fmt.Errorf("invalid URL scheme '%s', it must be redis, rediss or unix", u.Scheme)
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+
+	db := u.Query().Get("db")
+	if r.network == "unix" {
+		if u.Host != "" {
+			return redisURL{}, fmt.Errorf("invalid URL host '%s', the path of the socket must follow unix://", u.Host)
+		}
+		if u.Path == "" {
+			return redisURL{}, errors.New("invalid URL: missing the path of the unix socket")
+		}
+		r.addr = u.Path
+	} else {
+		host, port := u.Hostname(), u.Port()
+		if host == "" {
+			return redisURL{}, errors.New("invalid URL: missing host")
+		}
+		if port == "" {
+			port = "6379"
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return redisURL{}, fmt.Errorf("invalid URL port '%s'", port)
+		}
+		r.addr = net.JoinHostPort(host, port)
+		if path := strings.Trim(u.Path, "/"); path != "" {
+			db = path
+		}
+	}
+	if db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return redisURL{}, fmt.Errorf("invalid URL database '%s'", db)
+		}
+		r.db = n
+	}
+	return r, nil
+}